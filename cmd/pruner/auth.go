@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	errUnauthenticated = errors.New("unauthorized")
+	errForbidden       = errors.New("forbidden")
+)
+
+// authorizer decides whether a request may use a protected endpoint. It
+// returns errUnauthenticated or errForbidden (possibly wrapped) to reject
+// the request, and any other error when the decision could not be made.
+type authorizer interface {
+	authorize(r *http.Request) error
+}
+
+// requireAuth rejects requests the authorizer does not allow.
+func requireAuth(auth authorizer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := auth.authorize(r)
+		switch {
+		case err == nil:
+			next.ServeHTTP(w, r)
+		case errors.Is(err, errUnauthenticated):
+			w.Header().Set("WWW-Authenticate", `Bearer realm="helm-release-pruner"`)
+			writeJSONError(w, http.StatusUnauthorized, errUnauthenticated)
+		case errors.Is(err, errForbidden):
+			writeJSONError(w, http.StatusForbidden, errForbidden)
+		default:
+			fmt.Fprintf(os.Stderr, "authorization error: %v\n", err)
+			writeJSONError(w, http.StatusInternalServerError, errors.New("authorization failed"))
+		}
+	})
+}
+
+// bearerToken returns the bearer token of a request, or "".
+func bearerToken(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	return token
+}
+
+// tokenAuthorizer allows requests that carry a shared bearer token.
+type tokenAuthorizer struct {
+	token func() (string, error)
+}
+
+// staticToken returns a token source for a fixed token.
+func staticToken(token string) func() (string, error) {
+	return func() (string, error) { return token, nil }
+}
+
+func (a tokenAuthorizer) authorize(r *http.Request) error {
+	expected, err := a.token()
+	if err != nil {
+		return err
+	}
+	got := bearerToken(r)
+	if got == "" || expected == "" || subtle.ConstantTimeCompare([]byte(got), []byte(expected)) != 1 {
+		return errUnauthenticated
+	}
+	return nil
+}
+
+// tokenFile reads a token from a file, re-reading it whenever the file
+// changes so that a rotated token takes effect without a restart.
+type tokenFile struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	token   string
+}
+
+// newTokenFile reads the token file once, so that a missing or empty file
+// fails at startup.
+func newTokenFile(path string) (*tokenFile, error) {
+	f := &tokenFile{path: path}
+	token, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		return nil, fmt.Errorf("token file %s is empty", path)
+	}
+	return f, nil
+}
+
+// read returns the current token.
+func (f *tokenFile) read() (string, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if info.ModTime().Equal(f.modTime) {
+		return f.token, nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+	f.token = strings.TrimSpace(string(data))
+	f.modTime = info.ModTime()
+	return f.token, nil
+}
+
+// tokenReviewAuthorizer authenticates bearer tokens with a Kubernetes
+// TokenReview and authorizes the request with a SubjectAccessReview for its
+// path as a non-resource URL, so access is granted through RBAC, e.g.:
+//
+//	rules:
+//	- nonResourceURLs: ["/metrics", "/status", "/plan"]
+//	  verbs: ["get"]
+//	- nonResourceURLs: ["/trigger", "/pause", "/resume"]
+//	  verbs: ["create"]
+type tokenReviewAuthorizer struct {
+	client kubernetes.Interface
+}
+
+func (a tokenReviewAuthorizer) authorize(r *http.Request) error {
+	token := bearerToken(r)
+	if token == "" {
+		return errUnauthenticated
+	}
+
+	review, err := a.client.AuthenticationV1().TokenReviews().Create(r.Context(), &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("token review: %w", err)
+	}
+	if !review.Status.Authenticated {
+		return errUnauthenticated
+	}
+
+	user := review.Status.User
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	access, err := a.client.AuthorizationV1().SubjectAccessReviews().Create(r.Context(), &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			Groups: user.Groups,
+			UID:    user.UID,
+			Extra:  extra,
+			NonResourceAttributes: &authorizationv1.NonResourceAttributes{
+				Path: r.URL.Path,
+				Verb: requestVerb(r.Method),
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("subject access review: %w", err)
+	}
+	if !access.Status.Allowed {
+		return fmt.Errorf("%w: %s %s for %s", errForbidden, r.Method, r.URL.Path, user.Username)
+	}
+	return nil
+}
+
+// requestVerb maps an HTTP method to the Kubernetes verb used in RBAC rules.
+func requestVerb(method string) string {
+	switch method {
+	case http.MethodPost:
+		return "create"
+	case http.MethodPut:
+		return "update"
+	case http.MethodPatch:
+		return "patch"
+	case http.MethodDelete:
+		return "delete"
+	default:
+		return "get"
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// authCode returns the status code requireAuth responds with for a request
+// to path with the given bearer token.
+func authCode(auth authorizer, method, path, token string) int {
+	handler := requireAuth(auth, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestTokenAuthorizer(t *testing.T) {
+	auth := tokenAuthorizer{token: staticToken("s3cret")}
+
+	tests := []struct {
+		token string
+		code  int
+	}{
+		{"s3cret", http.StatusOK},
+		{"", http.StatusUnauthorized},
+		{"s3cret-not", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if code := authCode(auth, http.MethodGet, "/status", tt.token); code != tt.code {
+			t.Errorf("token %q: got %d, want %d", tt.token, code, tt.code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/status", nil)
+	req.Header.Set("Authorization", "Basic s3cret")
+	if err := auth.authorize(req); !errors.Is(err, errUnauthenticated) {
+		t.Errorf("expected non-bearer credentials to be rejected, got %v", err)
+	}
+}
+
+func TestTokenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("first\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tf, err := newTokenFile(path)
+	if err != nil {
+		t.Fatalf("newTokenFile: %v", err)
+	}
+	auth := tokenAuthorizer{token: tf.read}
+	if code := authCode(auth, http.MethodGet, "/status", "first"); code != http.StatusOK {
+		t.Errorf("first token: got %d, want 200", code)
+	}
+
+	// Rotate the token; the new modification time triggers a reload.
+	if err := os.WriteFile(path, []byte("second"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if code := authCode(auth, http.MethodGet, "/status", "first"); code != http.StatusUnauthorized {
+		t.Errorf("old token after rotation: got %d, want 401", code)
+	}
+	if code := authCode(auth, http.MethodGet, "/status", "second"); code != http.StatusOK {
+		t.Errorf("new token after rotation: got %d, want 200", code)
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty")
+	if err := os.WriteFile(empty, []byte("\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTokenFile(empty); err == nil {
+		t.Error("expected an empty token file to be rejected")
+	}
+}
+
+func TestTokenReviewAuthorizer(t *testing.T) {
+	client := fake.NewClientset()
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		switch review.Spec.Token {
+		case "ci-token":
+			review.Status = authenticationv1.TokenReviewStatus{
+				Authenticated: true,
+				User:          authenticationv1.UserInfo{Username: "system:serviceaccount:ci:deployer"},
+			}
+		case "broken":
+			return true, nil, errors.New("apiserver unavailable")
+		}
+		return true, review, nil
+	})
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		sar := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attrs := sar.Spec.NonResourceAttributes
+		sar.Status.Allowed = sar.Spec.User == "system:serviceaccount:ci:deployer" &&
+			attrs.Path == "/trigger" && attrs.Verb == "create"
+		return true, sar, nil
+	})
+
+	auth := tokenReviewAuthorizer{client: client}
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		code   int
+	}{
+		{"allowed", http.MethodPost, "/trigger", "ci-token", http.StatusOK},
+		{"not allowed by RBAC", http.MethodPost, "/pause", "ci-token", http.StatusForbidden},
+		{"unknown token", http.MethodPost, "/trigger", "stolen", http.StatusUnauthorized},
+		{"no token", http.MethodPost, "/trigger", "", http.StatusUnauthorized},
+		{"review failure", http.MethodPost, "/trigger", "broken", http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := authCode(auth, tt.method, tt.path, tt.token); code != tt.code {
+				t.Errorf("got %d, want %d", code, tt.code)
+			}
+		})
+	}
+}
+
+func TestServerFlagsAuthorizer(t *testing.T) {
+	t.Setenv("HELM_PRUNER_CONTROL_TOKEN", "")
+	auth, err := (&serverFlags{}).authorizer()
+	if err != nil || auth != nil {
+		t.Errorf("expected no authorizer by default, got %v, %v", auth, err)
+	}
+
+	if _, err := (&serverFlags{controlToken: "a", tokenReview: true}).authorizer(); err == nil {
+		t.Error("expected combining a token with token review to fail")
+	}
+
+	if _, err := (&serverFlags{controlTokenFile: filepath.Join(t.TempDir(), "missing")}).authorizer(); err == nil {
+		t.Error("expected a missing token file to fail")
+	}
+}
+
+func TestServerFlagsControlTokenEnv(t *testing.T) {
+	t.Setenv("HELM_PRUNER_CONTROL_TOKEN", "s3cret")
+
+	var cfg serverFlags
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	cfg.addFlags(fs)
+	if usage := fs.FlagUsages(); strings.Contains(usage, "s3cret") {
+		t.Errorf("expected the token not to be shown in the usage, got:\n%s", usage)
+	}
+
+	auth, err := cfg.authorizer()
+	if err != nil || auth == nil {
+		t.Fatalf("expected a token authorizer from the environment, got %v, %v", auth, err)
+	}
+	if code := authCode(auth, http.MethodPost, "/trigger", "s3cret"); code != http.StatusOK {
+		t.Errorf("got %d with the environment token, want 200", code)
+	}
+}
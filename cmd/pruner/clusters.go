@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+
+	"github.com/FairwindsOps/helm-release-pruner/pkg/pruner"
+)
+
+// clustersConfig is the file format of --clusters-config.
+type clustersConfig struct {
+	Clusters []clusterConfig `json:"clusters"`
+}
+
+// clusterConfig describes one cluster managed by the pruner. Flags override
+// the policy flags given on the command line for this cluster only, using
+// the flag names without the leading dashes.
+type clusterConfig struct {
+	Name    string         `json:"name"`
+	Context string         `json:"context"`
+	Flags   map[string]any `json:"flags"`
+}
+
+// loadClusterOptions reads a clusters config file and builds pruner options
+// for each cluster. Policy flags set on the command line (global) apply to
+// every cluster unless the cluster overrides them.
+func loadClusterOptions(path string, global *pflag.FlagSet) ([]pruner.Options, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read clusters config: %w", err)
+	}
+
+	var cfg clustersConfig
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse clusters config: %w", err)
+	}
+	if len(cfg.Clusters) == 0 {
+		return nil, fmt.Errorf("clusters config %s defines no clusters", path)
+	}
+
+	seen := make(map[string]bool)
+	clusters := make([]pruner.Options, 0, len(cfg.Clusters))
+	for _, c := range cfg.Clusters {
+		if c.Name == "" {
+			return nil, fmt.Errorf("clusters config: every cluster needs a name")
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("clusters config: duplicate cluster name %q", c.Name)
+		}
+		seen[c.Name] = true
+
+		opts, err := clusterOptions(c, global)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %q: %w", c.Name, err)
+		}
+		clusters = append(clusters, opts)
+	}
+
+	if err := separateStateFiles(clusters, global); err != nil {
+		return nil, err
+	}
+	return clusters, nil
+}
+
+// separateStateFiles gives every cluster that inherits the global
+// --state-file its own file, suffixed with the cluster name, since the
+// clusters would otherwise overwrite each other's state. It rejects state
+// files that clusters still share after that.
+func separateStateFiles(clusters []pruner.Options, global *pflag.FlagSet) error {
+	globalFile := ""
+	if f := global.Lookup("state-file"); f != nil {
+		globalFile = f.Value.String()
+	}
+
+	owners := make(map[string]string)
+	for i := range clusters {
+		opts := &clusters[i]
+		if opts.StateFile == "" {
+			continue
+		}
+		if len(clusters) > 1 && opts.StateFile == globalFile {
+			opts.StateFile += "." + opts.ClusterName
+		}
+		if owner, ok := owners[opts.StateFile]; ok {
+			return fmt.Errorf("clusters %q and %q cannot share the state file %s", owner, opts.ClusterName, opts.StateFile)
+		}
+		owners[opts.StateFile] = opts.ClusterName
+	}
+	return nil
+}
+
+// clusterOptions builds the options for a single cluster by replaying the
+// policy flags changed on the command line and then the cluster's overrides.
+func clusterOptions(c clusterConfig, global *pflag.FlagSet) (pruner.Options, error) {
+	var policy policyFlags
+	fs := pflag.NewFlagSet(c.Name, pflag.ContinueOnError)
+	policy.addFlags(fs)
+
+	var replayErr error
+	global.Visit(func(f *pflag.Flag) {
+		if replayErr != nil || fs.Lookup(f.Name) == nil {
+			return
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			replayErr = fs.Lookup(f.Name).Value.(pflag.SliceValue).Replace(sv.GetSlice())
+			return
+		}
+		replayErr = fs.Set(f.Name, f.Value.String())
+	})
+	if replayErr != nil {
+		return pruner.Options{}, replayErr
+	}
+
+	for name, value := range c.Flags {
+		if fs.Lookup(name) == nil {
+			return pruner.Options{}, fmt.Errorf("unknown or non-policy flag %q", name)
+		}
+		if err := setFlag(fs.Lookup(name), value); err != nil {
+			return pruner.Options{}, fmt.Errorf("invalid value for %q: %w", name, err)
+		}
+	}
+
+	opts, err := policy.options()
+	if err != nil {
+		return opts, err
+	}
+
+	opts.ClusterName = c.Name
+	opts.KubeContext = c.Context
+	if opts.KubeContext == "" {
+		opts.KubeContext = c.Name
+	}
+	return opts, nil
+}
+
+// setFlag sets a flag from a YAML value. Lists are accepted for slice flags.
+func setFlag(f *pflag.Flag, value any) error {
+	list, isList := value.([]any)
+	sv, isSlice := f.Value.(pflag.SliceValue)
+	switch {
+	case isList && isSlice:
+		items := make([]string, 0, len(list))
+		for _, item := range list {
+			items = append(items, fmt.Sprint(item))
+		}
+		return sv.Replace(items)
+	case isList:
+		return fmt.Errorf("flag does not accept a list")
+	default:
+		return f.Value.Set(fmt.Sprint(value))
+	}
+}
+
+// runDaemons runs a daemon per pruner until ctx is cancelled. Each cluster
+// runs in its own goroutine so a slow or failing cluster does not hold up
+// the others.
+func runDaemons(ctx context.Context, pruners []*pruner.Pruner) error {
+	return errors.Join(forEachPruner(pruners, func(_ int, p *pruner.Pruner) error {
+		if err := p.RunDaemon(ctx); !errors.Is(err, context.Canceled) {
+			return err
+		}
+		return nil // Normal shutdown
+	})...)
+}
+
+// runOnceAll runs a single prune cycle on every pruner concurrently and
+// reports the outcome of each.
+func runOnceAll(ctx context.Context, pruners []*pruner.Pruner) []cycleReport {
+	reports := make([]cycleReport, len(pruners))
+	errs := forEachPruner(pruners, func(i int, p *pruner.Pruner) error {
+		start := time.Now()
+		result, err := p.RunOnce(ctx)
+		reports[i].StartedAt = start
+		reports[i].DurationSeconds = time.Since(start).Seconds()
+		reports[i].Result = result
+		return err
+	})
+
+	for i, p := range pruners {
+		reports[i].Cluster = p.ClusterName()
+		reports[i].err = errs[i]
+	}
+	return reports
+}
+
+// forEachPruner calls fn for each pruner in its own goroutine and returns
+// each pruner's error, labelled by cluster. A panic in one cluster is
+// reported as that cluster's error rather than taking down the others.
+func forEachPruner(pruners []*pruner.Pruner, fn func(int, *pruner.Pruner) error) []error {
+	errs := make([]error, len(pruners))
+
+	var wg sync.WaitGroup
+	for i, p := range pruners {
+		wg.Go(func() {
+			defer func() {
+				if r := recover(); r != nil {
+					errs[i] = fmt.Errorf("panic: %v", r)
+				}
+			}()
+			errs[i] = fn(i, p)
+		})
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if name := pruners[i].ClusterName(); name != "" {
+			errs[i] = fmt.Errorf("cluster %s: %w", name, err)
+		}
+	}
+	return errs
+}
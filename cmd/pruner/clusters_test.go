@@ -0,0 +1,243 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// globalFlags parses args into a fresh set of policy flags, like the root command.
+func globalFlags(t *testing.T, args ...string) *pflag.FlagSet {
+	t.Helper()
+	var policy policyFlags
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	policy.addFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("failed to parse flags %v: %v", args, err)
+	}
+	return fs
+}
+
+// writeClustersConfig writes a clusters config file and returns its path.
+func writeClustersConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "clusters.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write clusters config: %v", err)
+	}
+	return path
+}
+
+func TestLoadClusterOptions(t *testing.T) {
+	path := writeClustersConfig(t, `
+clusters:
+  - name: prod-east
+    flags:
+      older-than: 3d
+      no-hooks: true
+      release-filter: "^feature-"
+      as-group: [ops, pruners]
+  - name: staging
+    context: staging-admin
+`)
+	global := globalFlags(t, "--older-than=2w", "--dry-run", "--interval=30m", "--as-group=a,b", "--kube-qps=50")
+
+	clusters, err := loadClusterOptions(path, global)
+	if err != nil {
+		t.Fatalf("loadClusterOptions: %v", err)
+	}
+	if len(clusters) != 2 {
+		t.Fatalf("expected 2 clusters, got %d", len(clusters))
+	}
+
+	east, staging := clusters[0], clusters[1]
+
+	if east.ClusterName != "prod-east" || east.KubeContext != "prod-east" {
+		t.Errorf("prod-east: name/context = %q/%q, want context to default to name", east.ClusterName, east.KubeContext)
+	}
+	if east.OlderThan != 3*24*time.Hour {
+		t.Errorf("prod-east: OlderThan = %v, want override of 72h", east.OlderThan)
+	}
+	if !east.Uninstall.DisableHooks {
+		t.Error("prod-east: expected no-hooks override to apply")
+	}
+	if east.ReleaseFilter == nil || east.ReleaseFilter.String() != "^feature-" {
+		t.Errorf("prod-east: ReleaseFilter = %v, want ^feature-", east.ReleaseFilter)
+	}
+	if !east.DryRun || east.Interval != 30*time.Minute {
+		t.Error("prod-east: expected global --dry-run and --interval to apply")
+	}
+
+	if !slices.Equal(east.ImpersonateGroups, []string{"ops", "pruners"}) {
+		t.Errorf("prod-east: ImpersonateGroups = %v, want list override", east.ImpersonateGroups)
+	}
+
+	if staging.KubeContext != "staging-admin" {
+		t.Errorf("staging: KubeContext = %q, want staging-admin", staging.KubeContext)
+	}
+	if staging.OlderThan != 14*24*time.Hour {
+		t.Errorf("staging: OlderThan = %v, want global 2w", staging.OlderThan)
+	}
+	if !slices.Equal(staging.ImpersonateGroups, []string{"a", "b"}) || staging.QPS != 50 {
+		t.Errorf("staging: ImpersonateGroups/QPS = %v/%v, want global a,b/50", staging.ImpersonateGroups, staging.QPS)
+	}
+	if staging.Uninstall.DisableHooks || staging.ReleaseFilter != nil {
+		t.Error("staging: overrides from prod-east leaked into staging")
+	}
+}
+
+func TestLoadClusterOptions_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{
+			name:   "no clusters",
+			config: "clusters: []\n",
+		},
+		{
+			name:   "missing name",
+			config: "clusters:\n  - context: foo\n    flags: {older-than: 1d}\n",
+		},
+		{
+			name:   "duplicate name",
+			config: "clusters:\n  - name: a\n    flags: {older-than: 1d}\n  - name: a\n    flags: {older-than: 1d}\n",
+		},
+		{
+			name:   "unknown flag",
+			config: "clusters:\n  - name: a\n    flags: {older-than: 1d, bogus: x}\n",
+		},
+		{
+			name:   "process flag is not a policy flag",
+			config: "clusters:\n  - name: a\n    flags: {older-than: 1d, health-addr: ':9090'}\n",
+		},
+		{
+			name:   "invalid policy",
+			config: "clusters:\n  - name: a\n    flags: {release-filter: '('}\n",
+		},
+		{
+			name:   "group scope without pattern",
+			config: "clusters:\n  - name: a\n    flags: {older-than: 1d, limit-scope: group}\n",
+		},
+		{
+			name:   "invalid max lifetime",
+			config: "clusters:\n  - name: a\n    flags: {max-lifetime: forever}\n",
+		},
+		{
+			name:   "invalid limit mode",
+			config: "clusters:\n  - name: a\n    flags: {older-than: 1d, limit-mode: both}\n",
+		},
+		{
+			name:   "invalid expression",
+			config: "clusters:\n  - name: a\n    flags: {delete-expr: [release.owner == 'a']}\n",
+		},
+		{
+			name:   "no pruning configured",
+			config: "clusters:\n  - name: a\n",
+		},
+		{
+			name:   "unknown field",
+			config: "clusters:\n  - name: a\n    policy: {older-than: 1d}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeClustersConfig(t, tt.config)
+			if _, err := loadClusterOptions(path, globalFlags(t)); err == nil {
+				t.Error("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestLoadClusterOptions_Expressions(t *testing.T) {
+	path := writeClustersConfig(t, `
+clusters:
+  - name: previews
+    flags:
+      filter-expr: ['release.chart.name == "preview"']
+      delete-expr:
+        - 'age > duration("72h")'
+        - 'release.status == "failed"'
+  - name: staging
+`)
+	global := globalFlags(t, `--delete-expr=release.status in ["failed", "pending-install"]`)
+
+	clusters, err := loadClusterOptions(path, global)
+	if err != nil {
+		t.Fatalf("loadClusterOptions: %v", err)
+	}
+	previews, staging := clusters[0], clusters[1]
+	if len(previews.Filters) != 1 || len(previews.Rules) != 2 {
+		t.Errorf("previews: %d filters and %d rules, want the cluster's 1 and 2", len(previews.Filters), len(previews.Rules))
+	}
+	// --delete-expr is not split on commas.
+	if len(staging.Filters) != 0 || len(staging.Rules) != 1 {
+		t.Errorf("staging: %d filters and %d rules, want the global rule only", len(staging.Filters), len(staging.Rules))
+	}
+}
+
+func TestPolicyFlags_SQLConnectionStringEnv(t *testing.T) {
+	t.Setenv("HELM_DRIVER_SQL_CONNECTION_STRING", "postgres://pruner:s3cret@db/helm")
+
+	var policy policyFlags
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	policy.addFlags(fs)
+	if usage := fs.FlagUsages(); strings.Contains(usage, "s3cret") {
+		t.Errorf("expected the connection string not to be shown in the usage, got:\n%s", usage)
+	}
+	if err := fs.Parse([]string{"--older-than=1d"}); err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+
+	opts, err := policy.options()
+	if err != nil {
+		t.Fatalf("options: %v", err)
+	}
+	if opts.HelmDriverSQLConnectionString != "postgres://pruner:s3cret@db/helm" {
+		t.Errorf("HelmDriverSQLConnectionString = %q, want the environment value", opts.HelmDriverSQLConnectionString)
+	}
+}
+
+func TestLoadClusterOptions_StateFiles(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	path := writeClustersConfig(t, `
+clusters:
+  - name: prod-east
+  - name: prod-west
+  - name: staging
+    flags:
+      state-file: /data/staging.json
+`)
+	global := globalFlags(t, "--older-than=2w", "--state-file="+stateFile)
+
+	clusters, err := loadClusterOptions(path, global)
+	if err != nil {
+		t.Fatalf("loadClusterOptions: %v", err)
+	}
+	var got []string
+	for _, opts := range clusters {
+		got = append(got, opts.StateFile)
+	}
+	want := []string{stateFile + ".prod-east", stateFile + ".prod-west", "/data/staging.json"}
+	if !slices.Equal(got, want) {
+		t.Errorf("state files = %v, want %v", got, want)
+	}
+
+	shared := writeClustersConfig(t, `
+clusters:
+  - name: a
+    flags: {state-file: /data/state.json}
+  - name: b
+    flags: {state-file: /data/state.json}
+`)
+	if _, err := loadClusterOptions(shared, globalFlags(t, "--older-than=2w")); err == nil {
+		t.Error("expected clusters sharing a state file to be rejected")
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/FairwindsOps/helm-release-pruner/pkg/pruner"
+)
+
+// registerControlAPI adds the control endpoints to mux. Every endpoint
+// requires authorization. Endpoints act on all clusters, or on the one
+// named by the cluster query parameter.
+func registerControlAPI(mux *http.ServeMux, pruners []*pruner.Pruner, auth authorizer) {
+	mux.Handle("POST /trigger", requireAuth(auth, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		selected, ok := selectPruners(w, r, pruners)
+		if !ok {
+			return
+		}
+		// Trigger all selected clusters or none, so that a 409 means no
+		// cycle was started.
+		for _, p := range selected {
+			if p.Paused() {
+				writeJSONError(w, http.StatusConflict, clusterError(p, pruner.ErrPaused))
+				return
+			}
+		}
+		for _, p := range selected {
+			if err := p.Trigger(); err != nil {
+				writeJSONError(w, http.StatusConflict, clusterError(p, err))
+				return
+			}
+		}
+		writeStatuses(w, http.StatusAccepted, selected)
+	})))
+
+	mux.Handle("POST /pause", requireAuth(auth, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		selected, ok := selectPruners(w, r, pruners)
+		if !ok {
+			return
+		}
+		for _, p := range selected {
+			p.Pause()
+		}
+		writeStatuses(w, http.StatusOK, selected)
+	})))
+
+	mux.Handle("POST /resume", requireAuth(auth, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		selected, ok := selectPruners(w, r, pruners)
+		if !ok {
+			return
+		}
+		for _, p := range selected {
+			p.Resume()
+		}
+		writeStatuses(w, http.StatusOK, selected)
+	})))
+
+	mux.Handle("GET /status", requireAuth(auth, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		selected, ok := selectPruners(w, r, pruners)
+		if !ok {
+			return
+		}
+		writeStatuses(w, http.StatusOK, selected)
+	})))
+
+	mux.Handle("GET /plan", requireAuth(auth, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		selected, ok := selectPruners(w, r, pruners)
+		if !ok {
+			return
+		}
+		plans := make([]*pruner.Plan, 0, len(selected))
+		for _, p := range selected {
+			plan, err := p.Plan(r.Context())
+			if errors.Is(err, pruner.ErrCycleRunning) {
+				writeJSONError(w, http.StatusConflict, clusterError(p, err))
+				return
+			}
+			if err != nil {
+				writeJSONError(w, http.StatusInternalServerError, clusterError(p, err))
+				return
+			}
+			plans = append(plans, plan)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"clusters": plans})
+	})))
+
+	mux.Handle("GET /quarantine", requireAuth(auth, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		selected, ok := selectPruners(w, r, pruners)
+		if !ok {
+			return
+		}
+		type clusterFailures struct {
+			Cluster  string                  `json:"cluster,omitempty"`
+			Releases []pruner.ReleaseFailure `json:"releases"`
+		}
+		clusters := make([]clusterFailures, 0, len(selected))
+		for _, p := range selected {
+			clusters = append(clusters, clusterFailures{Cluster: p.ClusterName(), Releases: p.ReleaseFailures()})
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"clusters": clusters})
+	})))
+
+	mux.Handle("DELETE /quarantine/{namespace}/{name}", requireAuth(auth, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		selected, ok := selectPruners(w, r, pruners)
+		if !ok {
+			return
+		}
+		namespace, name := r.PathValue("namespace"), r.PathValue("name")
+		cleared := false
+		for _, p := range selected {
+			ok, err := p.ClearReleaseFailures(r.Context(), namespace, name)
+			if err != nil {
+				writeJSONError(w, http.StatusInternalServerError, clusterError(p, err))
+				return
+			}
+			cleared = cleared || ok
+		}
+		if !cleared {
+			writeJSONError(w, http.StatusNotFound, fmt.Errorf("no uninstall failures recorded for release %s/%s", namespace, name))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})))
+}
+
+// selectPruners returns the pruners a request applies to. It writes a 404
+// and returns false when the cluster query parameter names no cluster.
+func selectPruners(w http.ResponseWriter, r *http.Request, pruners []*pruner.Pruner) ([]*pruner.Pruner, bool) {
+	name := r.URL.Query().Get("cluster")
+	if name == "" {
+		return pruners, true
+	}
+	for _, p := range pruners {
+		if p.ClusterName() == name {
+			return []*pruner.Pruner{p}, true
+		}
+	}
+	writeJSONError(w, http.StatusNotFound, fmt.Errorf("unknown cluster %q", name))
+	return nil, false
+}
+
+// clusterError prefixes err with the cluster name, if any.
+func clusterError(p *pruner.Pruner, err error) error {
+	if p.ClusterName() == "" {
+		return err
+	}
+	return fmt.Errorf("cluster %s: %w", p.ClusterName(), err)
+}
+
+func writeStatuses(w http.ResponseWriter, code int, pruners []*pruner.Pruner) {
+	statuses := make([]pruner.Status, 0, len(pruners))
+	for _, p := range pruners {
+		statuses = append(statuses, p.Status())
+	}
+	writeJSON(w, code, map[string]any{"clusters": statuses})
+}
+
+func writeJSONError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "control endpoint write error: %v\n", err)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FairwindsOps/helm-release-pruner/pkg/pruner"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://127.0.0.1:1
+contexts:
+- name: staging
+  context:
+    cluster: test
+    user: test
+- name: production
+  context:
+    cluster: test
+    user: test
+current-context: staging
+users:
+- name: test
+  user:
+    token: test
+`
+
+// newTestPruners creates pruners for the staging and production contexts of
+// a kubeconfig whose API server is unreachable.
+func newTestPruners(t *testing.T) []*pruner.Pruner {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := os.WriteFile(path, []byte(testKubeconfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var pruners []*pruner.Pruner
+	for _, name := range []string{"staging", "production"} {
+		p, err := pruner.New(pruner.Options{
+			ClusterName: name,
+			Kubeconfig:  path,
+			KubeContext: name,
+			Interval:    time.Hour,
+			OlderThan:   time.Hour,
+		})
+		if err != nil {
+			t.Fatalf("pruner.New: %v", err)
+		}
+		pruners = append(pruners, p)
+	}
+	return pruners
+}
+
+func TestControlAPI(t *testing.T) {
+	pruners := newTestPruners(t)
+	mux := http.NewServeMux()
+	registerControlAPI(mux, pruners, tokenAuthorizer{token: staticToken("s3cret")})
+
+	do := func(method, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		token  string
+		code   int
+	}{
+		{"no token", http.MethodGet, "/status", "", http.StatusUnauthorized},
+		{"wrong token", http.MethodGet, "/status", "guess", http.StatusUnauthorized},
+		{"status", http.MethodGet, "/status", "s3cret", http.StatusOK},
+		{"unknown cluster", http.MethodGet, "/status?cluster=dev", "s3cret", http.StatusNotFound},
+		{"wrong method", http.MethodGet, "/pause", "s3cret", http.StatusMethodNotAllowed},
+		{"pause one cluster", http.MethodPost, "/pause?cluster=production", "s3cret", http.StatusOK},
+		{"trigger paused cluster", http.MethodPost, "/trigger", "s3cret", http.StatusConflict},
+		{"trigger running cluster", http.MethodPost, "/trigger?cluster=staging", "s3cret", http.StatusAccepted},
+		{"resume", http.MethodPost, "/resume", "s3cret", http.StatusOK},
+		{"trigger all", http.MethodPost, "/trigger", "s3cret", http.StatusAccepted},
+		{"plan with unreachable cluster", http.MethodGet, "/plan?cluster=staging", "s3cret", http.StatusInternalServerError},
+		{"quarantine", http.MethodGet, "/quarantine", "s3cret", http.StatusOK},
+		{"quarantine without token", http.MethodGet, "/quarantine", "", http.StatusUnauthorized},
+		{"clear unknown release", http.MethodDelete, "/quarantine/feature-a/web", "s3cret", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(tt.method, tt.target, tt.token)
+			if rec.Code != tt.code {
+				t.Errorf("%s %s = %d, want %d: %s", tt.method, tt.target, rec.Code, tt.code, rec.Body)
+			}
+		})
+	}
+
+	do(http.MethodPost, "/pause?cluster=production", "s3cret")
+	rec := do(http.MethodPost, "/trigger", "s3cret")
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "cluster production") {
+		t.Errorf("trigger with a paused cluster = %d: %s, want a conflict naming production", rec.Code, rec.Body)
+	}
+
+	rec = do(http.MethodGet, "/status", "s3cret")
+	var body struct {
+		Clusters []pruner.Status `json:"clusters"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode status: %v", err)
+	}
+	if len(body.Clusters) != 2 || body.Clusters[0].Paused || !body.Clusters[1].Paused {
+		t.Errorf("unexpected statuses %+v", body.Clusters)
+	}
+}
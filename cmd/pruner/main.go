@@ -7,12 +7,10 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"regexp"
 	"strings"
 	"syscall"
 	"time"
 
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 
 	"github.com/FairwindsOps/helm-release-pruner/pkg/pruner"
@@ -26,27 +24,30 @@ var (
 
 func main() {
 	if err := newRootCmd().Execute(); err != nil {
+		var exit *exitCodeError
+		if errors.As(err, &exit) {
+			if exit.err != nil {
+				fmt.Fprintln(os.Stderr, exit.err)
+			}
+			os.Exit(exit.code)
+		}
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
 
 func newRootCmd() *cobra.Command {
-	var opts pruner.Options
+	var (
+		policy  policyFlags
+		server  serverFlags
+		tracing tracingFlags
+		report  reportFlags
+	)
 
 	var (
-		interval                   time.Duration
-		olderThan                  string
-		releaseFilter              string
-		namespaceFilter            string
-		releaseExcludeFilter       string
-		namespaceExclude           string
-		orphanNamespaceFilter      string
-		orphanNamespaceExclude     string
-		healthAddr                 string
-		deleteRateLimit            time.Duration
-		additionalSystemNamespaces string
-		runOnce                    bool
+		clustersConfig string
+		runOnce        bool
+		clusters       []pruner.Options
 	)
 
 	cmd := &cobra.Command{
@@ -57,91 +58,46 @@ count limits, and regex filters. Can also clean up orphaned namespaces that
 have no Helm releases. Runs continuously and prunes at configurable intervals.`,
 		Version: fmt.Sprintf("%s (commit: %s, built: %s)", version, commit, date),
 		PreRunE: func(cmd *cobra.Command, args []string) error {
-			opts.Interval = interval
-			opts.DeleteRateLimit = deleteRateLimit
-
-			if additionalSystemNamespaces != "" {
-				opts.AdditionalSystemNamespaces = strings.Split(additionalSystemNamespaces, ",")
-				for i, ns := range opts.AdditionalSystemNamespaces {
-					opts.AdditionalSystemNamespaces[i] = strings.TrimSpace(ns)
-				}
+			if err := report.validate(runOnce); err != nil {
+				return err
 			}
 
-			if olderThan != "" {
-				d, err := parseDuration(olderThan)
-				if err != nil {
-					return fmt.Errorf("invalid --older-than value: %w", err)
-				}
-				opts.OlderThan = d
+			if clustersConfig != "" {
+				var err error
+				clusters, err = loadClusterOptions(clustersConfig, cmd.Flags())
+				return err
 			}
 
-			if releaseFilter != "" {
-				re, err := regexp.Compile(releaseFilter)
-				if err != nil {
-					return fmt.Errorf("invalid --release-filter regex: %w", err)
-				}
-				opts.ReleaseFilter = re
-			}
-
-			if namespaceFilter != "" {
-				re, err := regexp.Compile(namespaceFilter)
-				if err != nil {
-					return fmt.Errorf("invalid --namespace-filter regex: %w", err)
-				}
-				opts.NamespaceFilter = re
-			}
-
-			if releaseExcludeFilter != "" {
-				re, err := regexp.Compile(releaseExcludeFilter)
-				if err != nil {
-					return fmt.Errorf("invalid --release-exclude regex: %w", err)
-				}
-				opts.ReleaseExclude = re
+			opts, err := policy.options()
+			if err != nil {
+				return err
 			}
-
-			if namespaceExclude != "" {
-				re, err := regexp.Compile(namespaceExclude)
-				if err != nil {
-					return fmt.Errorf("invalid --namespace-exclude regex: %w", err)
-				}
-				opts.NamespaceExclude = re
+			clusters = []pruner.Options{opts}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			shutdownTracing, err := tracing.setup(cmd.Context())
+			if err != nil {
+				return err
 			}
-
-			if orphanNamespaceFilter != "" {
-				re, err := regexp.Compile(orphanNamespaceFilter)
-				if err != nil {
-					return fmt.Errorf("invalid --orphan-namespace-filter regex: %w", err)
+			defer func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if err := shutdownTracing(ctx); err != nil {
+					fmt.Fprintf(os.Stderr, "trace exporter shutdown error: %v\n", err)
 				}
-				opts.OrphanNamespaceFilter = re
-			}
+			}()
 
-			if orphanNamespaceExclude != "" {
-				re, err := regexp.Compile(orphanNamespaceExclude)
+			pruners := make([]*pruner.Pruner, 0, len(clusters))
+			for _, opts := range clusters {
+				p, err := pruner.New(opts)
 				if err != nil {
-					return fmt.Errorf("invalid --orphan-namespace-exclude regex: %w", err)
+					if opts.ClusterName != "" {
+						return fmt.Errorf("failed to initialize pruner for cluster %s: %w", opts.ClusterName, err)
+					}
+					return fmt.Errorf("failed to initialize pruner: %w", err)
 				}
-				opts.OrphanNamespaceExclude = re
-			}
-
-			if opts.CleanupOrphanNamespaces && opts.OrphanNamespaceFilter == nil {
-				fmt.Fprintln(os.Stderr, "WARNING: --cleanup-orphan-namespaces requires --orphan-namespace-filter for safety; orphan cleanup disabled")
-				opts.CleanupOrphanNamespaces = false
-			}
-
-			hasReleasePruning := opts.OlderThan > 0 || opts.MaxReleasesToKeep > 0 ||
-				opts.ReleaseFilter != nil || opts.NamespaceFilter != nil ||
-				opts.ReleaseExclude != nil || opts.NamespaceExclude != nil
-
-			if !hasReleasePruning && !opts.CleanupOrphanNamespaces {
-				return fmt.Errorf("at least one of release pruning filters or --cleanup-orphan-namespaces (with --orphan-namespace-filter) must be specified")
-			}
-
-			return nil
-		},
-		RunE: func(cmd *cobra.Command, args []string) error {
-			p, err := pruner.New(opts)
-			if err != nil {
-				return fmt.Errorf("failed to initialize pruner: %w", err)
+				pruners = append(pruners, p)
 			}
 
 			ctx, cancel := context.WithCancel(cmd.Context())
@@ -157,21 +113,30 @@ have no Helm releases. Runs continuously and prunes at configurable intervals.`,
 			}()
 
 			if runOnce {
-				return p.RunOnce(ctx)
+				err := finishOnce(report, runOnceAll(ctx, pruners))
+				var exit *exitCodeError
+				if errors.As(err, &exit) {
+					// The exit code carries the outcome; main prints the error.
+					cmd.SilenceErrors = true
+					cmd.SilenceUsage = true
+				}
+				return err
 			}
 
-			healthServer := startHealthServer(healthAddr, p)
-			err = p.RunDaemon(ctx)
+			servers, err := startServers(server, pruners)
+			if err != nil {
+				return err
+			}
+			err = runDaemons(ctx, pruners)
 
 			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
 			defer shutdownCancel()
-			if shutdownErr := healthServer.Shutdown(shutdownCtx); shutdownErr != nil {
-				fmt.Fprintf(os.Stderr, "health server shutdown error: %v\n", shutdownErr)
+			for _, srv := range servers {
+				if shutdownErr := srv.Shutdown(shutdownCtx); shutdownErr != nil {
+					fmt.Fprintf(os.Stderr, "health server shutdown error: %v\n", shutdownErr)
+				}
 			}
 
-			if errors.Is(err, context.Canceled) {
-				return nil // Normal shutdown
-			}
 			return err
 		},
 	}
@@ -179,58 +144,22 @@ have no Helm releases. Runs continuously and prunes at configurable intervals.`,
 	// Flags
 	flags := cmd.Flags()
 
-	// Daemon settings
-	flags.DurationVar(&interval, "interval", 1*time.Hour,
-		"How often to run the pruning cycle")
-	flags.StringVar(&healthAddr, "health-addr", ":8080",
-		"Address for health check and metrics endpoints")
-	flags.DurationVar(&deleteRateLimit, "delete-rate-limit", 100*time.Millisecond,
-		"Minimum duration between delete operations to avoid overwhelming the API server (0 to disable)")
-
-	// Release pruning filters
-	flags.IntVar(&opts.MaxReleasesToKeep, "max-releases-to-keep", 0,
-		"Maximum number of releases to keep globally after filtering (0 = no limit)")
-	flags.StringVar(&olderThan, "older-than", "",
-		"Delete releases older than this duration (e.g., '336h' for 2 weeks, '2w', '30d')")
-	flags.StringVar(&releaseFilter, "release-filter", "",
-		"Regex filter for release names (only matching releases are considered)")
-	flags.StringVar(&namespaceFilter, "namespace-filter", "",
-		"Regex filter for namespaces (only matching namespaces are considered)")
-	flags.StringVar(&releaseExcludeFilter, "release-exclude", "",
-		"Regex filter to exclude releases (matching releases are skipped)")
-	flags.StringVar(&namespaceExclude, "namespace-exclude", "",
-		"Regex filter to exclude namespaces (matching namespaces are skipped)")
-	flags.BoolVar(&opts.PreserveNamespace, "preserve-namespace", false,
-		"Do not delete namespaces even when empty after release deletion")
-
-	// Orphan namespace cleanup
-	flags.BoolVar(&opts.CleanupOrphanNamespaces, "cleanup-orphan-namespaces", false,
-		"Enable cleanup of namespaces that have no Helm releases (requires --orphan-namespace-filter)")
-	flags.StringVar(&orphanNamespaceFilter, "orphan-namespace-filter", "",
-		"Regex filter for namespaces to consider for orphan cleanup (REQUIRED when using --cleanup-orphan-namespaces)")
-	flags.StringVar(&orphanNamespaceExclude, "orphan-namespace-exclude", "",
-		"Regex filter to exclude namespaces from orphan cleanup (e.g., 'kube-system|default')")
-
-	// System namespace configuration
-	flags.StringVar(&additionalSystemNamespaces, "system-namespaces", "",
-		"Comma-separated list of additional namespaces to treat as system namespaces (never deleted)")
-
-	// General options
-	flags.BoolVar(&opts.DryRun, "dry-run", false,
-		"Show what would be deleted without actually deleting")
-	flags.BoolVar(&opts.Debug, "debug", false,
-		"Enable debug logging")
+	server.addFlags(flags)
+	tracing.addFlags(flags)
 	flags.BoolVar(&runOnce, "once", false,
 		"Run a single prune cycle and exit (for cron jobs or testing)")
+	report.addFlags(flags)
+	flags.StringVar(&clustersConfig, "clusters-config", "",
+		"YAML file listing kubeconfig contexts to prune, each with optional policy flag overrides")
+
+	policy.addFlags(flags)
 
 	return cmd
 }
 
-// startHealthServer starts an HTTP server for /healthz, /readyz, and /metrics.
-func startHealthServer(addr string, p *pruner.Pruner) *http.Server {
-	mux := http.NewServeMux()
-
-		mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+// registerProbes adds the unauthenticated liveness and readiness endpoints.
+func registerProbes(mux *http.ServeMux, pruners []*pruner.Pruner) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		if _, err := w.Write([]byte("ok")); err != nil {
 			// Log but don't fail - client may have disconnected
@@ -238,51 +167,66 @@ func startHealthServer(addr string, p *pruner.Pruner) *http.Server {
 		}
 	})
 
-		mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
-			if !p.Initialized() {
-			w.WriteHeader(http.StatusServiceUnavailable)
-			if _, err := w.Write([]byte("not ready: initializing")); err != nil {
-				fmt.Fprintf(os.Stderr, "health endpoint write error: %v\n", err)
-			}
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if len(pruners) == 1 && pruners[0].ClusterName() == "" {
+			writeReadiness(w, r, pruners[0])
 			return
-			}
+		}
 
-			if err := p.CheckConnectivity(r.Context()); err != nil {
-			w.WriteHeader(http.StatusServiceUnavailable)
-			if _, err := w.Write([]byte("not ready: " + err.Error())); err != nil {
-				fmt.Fprintf(os.Stderr, "health endpoint write error: %v\n", err)
+		code := http.StatusOK
+		var body strings.Builder
+		for _, p := range pruners {
+			status, ok := readiness(r.Context(), p)
+			if !ok {
+				code = http.StatusServiceUnavailable
 			}
-			return
-			}
-
-			w.WriteHeader(http.StatusOK)
-			status := "ok"
-		if !p.Ready() {
-			status = "ok (no successful cycle yet)"
+			fmt.Fprintf(&body, "%s: %s\n", p.ClusterName(), status)
 		}
-		if _, err := w.Write([]byte(status)); err != nil {
+
+		w.WriteHeader(code)
+		if _, err := w.Write([]byte(body.String())); err != nil {
 			fmt.Fprintf(os.Stderr, "health endpoint write error: %v\n", err)
 		}
 	})
 
-	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/readyz/{cluster}", func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range pruners {
+			if p.ClusterName() == r.PathValue("cluster") {
+				writeReadiness(w, r, p)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	})
+}
+
+// readiness reports whether a single pruner is ready, with a short status.
+func readiness(ctx context.Context, p *pruner.Pruner) (string, bool) {
+	if !p.Initialized() {
+		return "not ready: initializing", false
+	}
 
-	server := &http.Server{
-		Addr:              addr,
-		Handler:           mux,
-		ReadTimeout:       5 * time.Second,
-		ReadHeaderTimeout: 5 * time.Second,
-		WriteTimeout:      10 * time.Second,
-		IdleTimeout:       60 * time.Second,
+	if err := p.CheckConnectivity(ctx); err != nil {
+		return "not ready: " + err.Error(), false
 	}
 
-	go func() {
-		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			fmt.Fprintf(os.Stderr, "health server error: %v\n", err)
-		}
-	}()
+	if !p.Ready() {
+		return "ok (no successful cycle yet)", true
+	}
+	return "ok", true
+}
 
-	return server
+// writeReadiness writes the readiness of a single pruner as the response.
+func writeReadiness(w http.ResponseWriter, r *http.Request, p *pruner.Pruner) {
+	status, ok := readiness(r.Context(), p)
+	if ok {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if _, err := w.Write([]byte(status)); err != nil {
+		fmt.Fprintf(os.Stderr, "health endpoint write error: %v\n", err)
+	}
 }
 
 // parseDuration parses duration strings like "336h" or "2w" or "30d"
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/FairwindsOps/helm-release-pruner/pkg/pruner"
+)
+
+// policyFlags holds the raw values of the flags that make up a pruning
+// policy. The same flags can be overridden per cluster in --clusters-config.
+type policyFlags struct {
+	opts pruner.Options
+
+	interval                       time.Duration
+	olderThan                      string
+	maxLifetime                    string
+	releaseFilter                  string
+	namespaceFilter                string
+	releaseExcludeFilter           string
+	namespaceExclude               string
+	orphanNamespaceFilter          string
+	orphanNamespaceExclude         string
+	branchPattern                  string
+	namespaceSelector              string
+	namespaceExcludeSelector       string
+	namespaceExcludeAnnotation     string
+	orphanNamespaceSelector        string
+	orphanNamespaceExcludeSelector string
+	orphanMinAge                   string
+	orphanIdleTime                 string
+	deleteRateLimit                time.Duration
+	additionalSystemNamespaces     string
+	uninstallWait                  string
+	groupPattern                   string
+	filterExprs                    []string
+	deleteExprs                    []string
+}
+
+// addFlags registers the policy flags on flags.
+func (f *policyFlags) addFlags(flags *pflag.FlagSet) {
+	opts := &f.opts
+
+	// Daemon settings
+	flags.DurationVar(&f.interval, "interval", 1*time.Hour,
+		"How often to run the pruning cycle")
+	flags.DurationVar(&f.deleteRateLimit, "delete-rate-limit", 100*time.Millisecond,
+		"Minimum duration between delete operations to avoid overwhelming the API server (0 to disable)")
+	flags.IntVar(&opts.MaxDeletionsPerCycle, "max-deletions-per-cycle", 0,
+		"Abort a cycle, before deleting anything, that selects more releases or more orphan namespaces than this (0 = no limit)")
+	flags.Float64Var(&opts.MaxDeletionFailurePercent, "max-deletion-failure-percent", 50,
+		"Fail the cycle (backoff, failure metric, not ready) when more than this percentage of release and namespace deletions fail (0 = any failure, 100 = never)")
+
+	// Pruner state
+	flags.StringVar(&opts.StateConfigMap, "state-configmap", "",
+		"ConfigMap (namespace/name) persisting the pruner state (failure counts, quarantined releases, orphan idle marks, activity) across restarts (default: memory only)")
+	flags.StringVar(&opts.StateFile, "state-file", "",
+		"Local file persisting the pruner state instead of --state-configmap, e.g. on a persistent volume")
+
+	// Release pruning filters
+	flags.IntVar(&opts.MaxReleasesToKeep, "max-releases-to-keep", 0,
+		"Maximum number of releases to keep per --limit-scope after filtering (0 = no limit)")
+	flags.StringVar(&f.olderThan, "older-than", "",
+		"Delete releases older than this duration (e.g., '336h' for 2 weeks, '2w', '30d')")
+	flags.StringVar(&f.maxLifetime, "max-lifetime", "",
+		"Delete releases first deployed longer ago than this, however often they are redeployed (e.g., '30d')")
+	flags.BoolVar(&opts.DeleteFailedInstalls, "delete-failed-installs", false,
+		"Delete releases whose only revision failed")
+	flags.StringVar(&opts.LimitMode, "limit-mode", pruner.LimitModeAny,
+		"How --max-releases-to-keep and --older-than combine: any (delete releases beyond either) or all (only beyond both)")
+	flags.IntVar(&opts.MinReleasesToKeep, "min-releases-to-keep", 0,
+		"Always keep at least this many releases per --limit-scope, whatever the other rules select (0 = no minimum)")
+	flags.StringVar(&opts.LimitScope, "limit-scope", pruner.LimitScopeGlobal,
+		"What --max-releases-to-keep and --min-releases-to-keep count: global, namespace, or group (see --group-pattern)")
+	flags.StringVar(&f.groupPattern, "group-pattern", "",
+		"Regex extracting the group from release names for --limit-scope=group (capture group 'group', the first group, or the whole match)")
+	flags.StringSliceVar(&opts.ActivitySignals, "activity-signals", nil,
+		"Measure release age from the most recent activity signal as well as the last deployment: pods, annotation, ingress")
+	flags.StringVar(&opts.ActivityConfigMap, "activity-configmap", "",
+		"ConfigMap (namespace/name) of Ingress host access times, used by the ingress activity signal")
+	flags.StringVar(&f.releaseFilter, "release-filter", "",
+		"Regex filter for release names (only matching releases are considered)")
+	flags.StringVar(&f.namespaceFilter, "namespace-filter", "",
+		"Regex filter for namespaces (only matching namespaces are considered)")
+	flags.StringVar(&f.releaseExcludeFilter, "release-exclude", "",
+		"Regex filter to exclude releases (matching releases are skipped)")
+	flags.StringVar(&f.namespaceExclude, "namespace-exclude", "",
+		"Regex filter to exclude namespaces (matching namespaces are skipped)")
+	flags.StringVar(&f.namespaceSelector, "namespace-selector", "",
+		"Label selector for namespaces (only releases in matching namespaces are considered, e.g. 'env=preview')")
+	flags.StringVar(&f.namespaceExcludeSelector, "namespace-exclude-selector", "",
+		"Label selector to exclude namespaces (releases are skipped and the namespace is never deleted)")
+	flags.StringVar(&f.namespaceExcludeAnnotation, "namespace-exclude-annotations", "",
+		"Selector matched against namespace annotations; matching namespaces are never pruned or deleted (e.g. 'pruner.fairwinds.com/keep=true')")
+	flags.StringArrayVar(&f.filterExprs, "filter-expr", nil,
+		"CEL expression over release, ns and age; only releases it is true for are considered (repeatable, e.g. 'release.chart.name == \"preview\"')")
+	flags.StringArrayVar(&f.deleteExprs, "delete-expr", nil,
+		"CEL expression over release, ns and age; releases it is true for are deleted (repeatable, e.g. 'release.status == \"failed\" && age > duration(\"24h\")')")
+	flags.StringVar(&f.branchPattern, "branch-pattern", "",
+		"Regex extracting the source branch from release names (capture group 'branch' or the first group); releases whose branch is gone are deleted")
+	flags.StringVar(&opts.BranchGitRemote, "branch-git-remote", "",
+		"Git remote URL or path whose branch heads are checked for --branch-pattern")
+	flags.StringVar(&opts.BranchCheckURL, "branch-check-url", "",
+		"HTTP endpoint checked for --branch-pattern, with {branch} replaced by the branch name (2xx = alive, 404/410 = gone)")
+	flags.IntVar(&opts.HistoryMax, "history-max", 0,
+		"Trim each release's stored revision history to this many revisions, never removing the deployed one (0 = disabled)")
+	flags.BoolVar(&opts.PreserveNamespace, "preserve-namespace", false,
+		"Do not delete namespaces even when empty after release deletion")
+
+	// Uninstall behavior
+	flags.StringVar(&f.uninstallWait, "uninstall-wait", pruner.WaitStrategyLegacy,
+		"How to wait for release resources to be deleted: watcher, legacy, or none (hooks are still awaited)")
+	flags.DurationVar(&opts.Uninstall.Timeout, "uninstall-timeout", 10*time.Minute,
+		"Time to wait for each release uninstall, including hooks")
+	flags.BoolVar(&opts.Uninstall.DisableHooks, "no-hooks", false,
+		"Skip running chart pre-delete and post-delete hooks")
+	flags.BoolVar(&opts.Uninstall.KeepHistory, "keep-history", false,
+		"Mark releases as uninstalled but keep their release history (namespaces with history are not deleted)")
+	flags.StringVar(&opts.Uninstall.DeletionPropagation, "cascade", pruner.PropagationBackground,
+		"Deletion propagation for release resources: background, foreground, or orphan")
+	flags.BoolVar(&opts.Uninstall.IgnoreNotFound, "ignore-not-found", false,
+		"Treat releases that disappear before uninstall as deleted instead of failing")
+	flags.DurationVar(&opts.ReleaseRetryBackoff, "release-retry-backoff", 1*time.Hour,
+		"Base backoff before retrying a release whose uninstall failed more than once, doubling per failure up to 24h (0 = retry every cycle)")
+	flags.IntVar(&opts.QuarantineAfter, "quarantine-after", 0,
+		"Stop retrying a release after this many failed uninstalls, until it changes or its failures are cleared (0 = never)")
+	flags.IntVar(&opts.ForcePurgeAfter, "force-purge-after", 0,
+		"Force purge a release after this many failed uninstalls: delete the manifest resources the cluster still serves and remove its Helm records, without hooks (0 = never)")
+
+	// Deletion hooks
+	flags.StringVar(&opts.PreDeleteCommand, "pre-delete-command", "",
+		"Shell command run before each release or namespace deletion, with PRUNER_* env vars and JSON on stdin; a non-zero exit vetoes the deletion")
+	flags.StringVar(&opts.PostDeleteCommand, "post-delete-command", "",
+		"Shell command run after each successful release or namespace deletion (failures are logged)")
+	flags.StringVar(&opts.PreDeleteWebhook, "pre-delete-webhook", "",
+		"URL that receives a JSON POST before each release or namespace deletion; any response other than 2xx vetoes the deletion")
+	flags.StringVar(&opts.PostDeleteWebhook, "post-delete-webhook", "",
+		"URL that receives a JSON POST after each successful release or namespace deletion (failures are logged)")
+	flags.DurationVar(&opts.HookTimeout, "hook-timeout", time.Minute,
+		"Time limit for each deletion hook command or webhook; a pre-delete hook that times out vetoes the deletion")
+
+	// Orphan namespace cleanup
+	flags.BoolVar(&opts.CleanupOrphanNamespaces, "cleanup-orphan-namespaces", false,
+		"Enable cleanup of namespaces that have no Helm releases (requires --orphan-namespace-filter)")
+	flags.StringVar(&f.orphanNamespaceFilter, "orphan-namespace-filter", "",
+		"Regex filter for namespaces to consider for orphan cleanup (REQUIRED when using --cleanup-orphan-namespaces)")
+	flags.StringVar(&f.orphanNamespaceExclude, "orphan-namespace-exclude", "",
+		"Regex filter to exclude namespaces from orphan cleanup (e.g., 'kube-system|default')")
+	flags.StringVar(&f.orphanNamespaceSelector, "orphan-namespace-selector", "",
+		"Label selector for namespaces to consider for orphan cleanup (can replace or narrow --orphan-namespace-filter)")
+	flags.StringVar(&f.orphanNamespaceExcludeSelector, "orphan-namespace-exclude-selector", "",
+		"Label selector to exclude namespaces from orphan cleanup")
+	flags.StringVar(&f.orphanMinAge, "orphan-min-age", "1h",
+		"Minimum namespace age, from its creation time, before it can be deleted as an orphan (e.g., '30m', '1d'; 0 to disable)")
+	flags.StringVar(&f.orphanIdleTime, "orphan-idle-time", "0",
+		"How long a namespace must be seen without Helm releases, across cycles, before it is deleted as an orphan (e.g., '6h'; 0 to disable)")
+	flags.StringSliceVar(&opts.OrphanBlockingResources, "orphan-blocking-resources",
+		[]string{"persistentvolumeclaims", "statefulsets.apps"},
+		"Resources that keep an orphan namespace alive while it contains any, e.g. 'serviceaccounts' or 'widgets.example.com' (empty to disable)")
+	flags.BoolVar(&opts.OrphanBlockOnRunningPods, "orphan-block-running-pods", true,
+		"Keep orphan namespaces that still have Running or Pending pods")
+
+	// Cross-namespace references
+	flags.BoolVar(&opts.ProtectReferencedNamespaces, "protect-referenced", false,
+		"Keep releases and namespaces that other namespaces still reference (ExternalName services, ingress backends, network policies, --custom-references)")
+	flags.StringSliceVar(&opts.CustomReferences, "custom-references", nil,
+		"Additional references as resource:field.path, where the field names the referenced namespace (e.g. 'databaseclaims.example.com:spec.instanceNamespace')")
+
+	// Namespaces stuck in Terminating
+	flags.DurationVar(&opts.StuckNamespaceThreshold, "stuck-namespace-threshold", 15*time.Minute,
+		"Report namespaces deleted by the pruner that are still Terminating after this long (0 to disable)")
+	flags.StringSliceVar(&opts.StuckNamespaceRemoveFinalizers, "stuck-namespace-remove-finalizers", nil,
+		"Kinds (Kind.version.group or Kind.group, e.g. 'Widget.v1.example.com') whose finalizers are removed from objects keeping a namespace stuck in Terminating")
+
+	// System namespace configuration
+	flags.StringVar(&f.additionalSystemNamespaces, "system-namespaces", "",
+		"Comma-separated list of additional namespaces to treat as system namespaces (never deleted)")
+
+	// Helm storage
+	flags.StringVar(&opts.HelmDriver, "helm-driver", os.Getenv("HELM_DRIVER"),
+		"Helm storage driver for release records: secret, configmap, memory, or sql (defaults to $HELM_DRIVER, then secret)")
+	// The connection string may hold a password, so the environment
+	// variable is read in options rather than shown as the flag default.
+	flags.StringVar(&opts.HelmDriverSQLConnectionString, "helm-driver-sql-connection-string", "",
+		"PostgreSQL connection string for the sql driver (defaults to $HELM_DRIVER_SQL_CONNECTION_STRING)")
+
+	// Kubernetes connection
+	flags.StringVar(&opts.Kubeconfig, "kubeconfig", "",
+		"Path to a kubeconfig file (default: in-cluster config, then $KUBECONFIG or ~/.kube/config)")
+	flags.StringVar(&opts.KubeContext, "context", "",
+		"Kubeconfig context to use (overridden per cluster by --clusters-config)")
+	flags.StringVar(&opts.Impersonate, "as", "",
+		"User to impersonate for all Kubernetes and Helm requests")
+	flags.StringSliceVar(&opts.ImpersonateGroups, "as-group", nil,
+		"Group to impersonate; can be repeated or comma-separated")
+	flags.Float32Var(&opts.QPS, "kube-qps", 0,
+		"Maximum queries per second to the API server (0 = client-go default)")
+	flags.IntVar(&opts.Burst, "kube-burst", 0,
+		"Maximum request burst to the API server (0 = client-go default)")
+	flags.DurationVar(&opts.RequestTimeout, "request-timeout", 0,
+		"Timeout for individual API server requests (0 = no timeout)")
+
+	// General options
+	flags.BoolVar(&opts.DryRun, "dry-run", false,
+		"Show what would be deleted without actually deleting")
+	flags.BoolVar(&opts.Debug, "debug", false,
+		"Enable debug logging")
+}
+
+// options validates the flag values and converts them into pruner options.
+func (f *policyFlags) options() (pruner.Options, error) {
+	opts := f.opts
+	opts.Interval = f.interval
+	opts.DeleteRateLimit = f.deleteRateLimit
+	if opts.HelmDriverSQLConnectionString == "" {
+		opts.HelmDriverSQLConnectionString = os.Getenv("HELM_DRIVER_SQL_CONNECTION_STRING")
+	}
+
+	if f.additionalSystemNamespaces != "" {
+		opts.AdditionalSystemNamespaces = strings.Split(f.additionalSystemNamespaces, ",")
+		for i, ns := range opts.AdditionalSystemNamespaces {
+			opts.AdditionalSystemNamespaces[i] = strings.TrimSpace(ns)
+		}
+	}
+
+	waitStrategy, err := pruner.ParseWaitStrategy(f.uninstallWait)
+	if err != nil {
+		return opts, fmt.Errorf("invalid --uninstall-wait value: %w", err)
+	}
+	opts.Uninstall.WaitStrategy = waitStrategy
+
+	if err := pruner.ValidatePropagation(opts.Uninstall.DeletionPropagation); err != nil {
+		return opts, fmt.Errorf("invalid --cascade value: %w", err)
+	}
+
+	if f.olderThan != "" {
+		d, err := parseDuration(f.olderThan)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --older-than value: %w", err)
+		}
+		opts.OlderThan = d
+	}
+
+	if f.maxLifetime != "" {
+		d, err := parseDuration(f.maxLifetime)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --max-lifetime value: %w", err)
+		}
+		opts.MaxLifetime = d
+	}
+
+	if f.releaseFilter != "" {
+		re, err := regexp.Compile(f.releaseFilter)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --release-filter regex: %w", err)
+		}
+		opts.ReleaseFilter = re
+	}
+
+	if f.branchPattern != "" {
+		re, err := regexp.Compile(f.branchPattern)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --branch-pattern regex: %w", err)
+		}
+		if re.NumSubexp() == 0 {
+			return opts, fmt.Errorf("--branch-pattern must have a capture group for the branch name")
+		}
+		if (opts.BranchGitRemote == "") == (opts.BranchCheckURL == "") {
+			return opts, fmt.Errorf("--branch-pattern requires exactly one of --branch-git-remote or --branch-check-url")
+		}
+		opts.BranchPattern = re
+	}
+
+	switch opts.LimitMode {
+	case pruner.LimitModeAny, pruner.LimitModeAll:
+	default:
+		return opts, fmt.Errorf("invalid --limit-mode %q (valid: %s, %s)", opts.LimitMode, pruner.LimitModeAny, pruner.LimitModeAll)
+	}
+	if opts.MinReleasesToKeep < 0 {
+		return opts, fmt.Errorf("--min-releases-to-keep must not be negative")
+	}
+	if f.groupPattern != "" {
+		re, err := regexp.Compile(f.groupPattern)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --group-pattern regex: %w", err)
+		}
+		opts.GroupPattern = re
+	}
+	switch opts.LimitScope {
+	case pruner.LimitScopeGlobal, pruner.LimitScopeNamespace:
+	case pruner.LimitScopeGroup:
+		if opts.GroupPattern == nil {
+			return opts, fmt.Errorf("--limit-scope=group requires --group-pattern")
+		}
+	default:
+		return opts, fmt.Errorf("invalid --limit-scope %q (valid: %s, %s, %s)",
+			opts.LimitScope, pruner.LimitScopeGlobal, pruner.LimitScopeNamespace, pruner.LimitScopeGroup)
+	}
+
+	if f.namespaceFilter != "" {
+		re, err := regexp.Compile(f.namespaceFilter)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --namespace-filter regex: %w", err)
+		}
+		opts.NamespaceFilter = re
+	}
+
+	if f.releaseExcludeFilter != "" {
+		re, err := regexp.Compile(f.releaseExcludeFilter)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --release-exclude regex: %w", err)
+		}
+		opts.ReleaseExclude = re
+	}
+
+	if f.namespaceExclude != "" {
+		re, err := regexp.Compile(f.namespaceExclude)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --namespace-exclude regex: %w", err)
+		}
+		opts.NamespaceExclude = re
+	}
+
+	if f.orphanNamespaceFilter != "" {
+		re, err := regexp.Compile(f.orphanNamespaceFilter)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --orphan-namespace-filter regex: %w", err)
+		}
+		opts.OrphanNamespaceFilter = re
+	}
+
+	if f.orphanNamespaceExclude != "" {
+		re, err := regexp.Compile(f.orphanNamespaceExclude)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --orphan-namespace-exclude regex: %w", err)
+		}
+		opts.OrphanNamespaceExclude = re
+	}
+
+	selectors := []struct {
+		flag  string
+		value string
+		dest  *labels.Selector
+	}{
+		{"--namespace-selector", f.namespaceSelector, &opts.NamespaceSelector},
+		{"--namespace-exclude-selector", f.namespaceExcludeSelector, &opts.NamespaceExcludeSelector},
+		{"--namespace-exclude-annotations", f.namespaceExcludeAnnotation, &opts.NamespaceExcludeAnnotations},
+		{"--orphan-namespace-selector", f.orphanNamespaceSelector, &opts.OrphanNamespaceSelector},
+		{"--orphan-namespace-exclude-selector", f.orphanNamespaceExcludeSelector, &opts.OrphanNamespaceExcludeSelector},
+	}
+	for _, sel := range selectors {
+		if sel.value == "" {
+			continue
+		}
+		parsed, err := labels.Parse(sel.value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s selector: %w", sel.flag, err)
+		}
+		*sel.dest = parsed
+	}
+
+	for _, source := range f.filterExprs {
+		expr, err := pruner.CompileExpression(source)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --filter-expr: %w", err)
+		}
+		opts.Filters = append(opts.Filters, expr.FilterRule())
+	}
+	for _, source := range f.deleteExprs {
+		expr, err := pruner.CompileExpression(source)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --delete-expr: %w", err)
+		}
+		opts.Rules = append(opts.Rules, expr.DeleteRule())
+	}
+
+	if f.orphanMinAge != "" {
+		d, err := parseDuration(f.orphanMinAge)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --orphan-min-age value: %w", err)
+		}
+		opts.OrphanMinAge = d
+	}
+
+	if f.orphanIdleTime != "" {
+		d, err := parseDuration(f.orphanIdleTime)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --orphan-idle-time value: %w", err)
+		}
+		opts.OrphanIdleTime = d
+	}
+
+	if opts.CleanupOrphanNamespaces && opts.OrphanNamespaceFilter == nil && opts.OrphanNamespaceSelector == nil {
+		fmt.Fprintln(os.Stderr, "WARNING: --cleanup-orphan-namespaces requires --orphan-namespace-filter or --orphan-namespace-selector for safety; orphan cleanup disabled")
+		opts.CleanupOrphanNamespaces = false
+	}
+
+	hasReleasePruning := opts.OlderThan > 0 || opts.MaxReleasesToKeep > 0 ||
+		opts.MaxLifetime > 0 || opts.DeleteFailedInstalls ||
+		opts.ReleaseFilter != nil || opts.NamespaceFilter != nil ||
+		opts.ReleaseExclude != nil || opts.NamespaceExclude != nil ||
+		opts.NamespaceSelector != nil || opts.NamespaceExcludeSelector != nil ||
+		opts.NamespaceExcludeAnnotations != nil || opts.BranchPattern != nil ||
+		len(opts.Filters) > 0 || len(opts.Rules) > 0
+
+	if opts.QPS < 0 || opts.Burst < 0 || opts.RequestTimeout < 0 {
+		return opts, fmt.Errorf("--kube-qps, --kube-burst and --request-timeout must not be negative")
+	}
+
+	for _, ref := range opts.CustomReferences {
+		if resource, path, ok := strings.Cut(ref, ":"); !ok || resource == "" || path == "" {
+			return opts, fmt.Errorf("invalid --custom-references entry %q (expected resource:field.path)", ref)
+		}
+	}
+
+	if opts.StuckNamespaceThreshold < 0 {
+		return opts, fmt.Errorf("--stuck-namespace-threshold must not be negative")
+	}
+
+	if opts.HistoryMax < 0 {
+		return opts, fmt.Errorf("--history-max must not be negative")
+	}
+
+	if opts.ReleaseRetryBackoff < 0 || opts.QuarantineAfter < 0 || opts.ForcePurgeAfter < 0 {
+		return opts, fmt.Errorf("--release-retry-backoff, --quarantine-after and --force-purge-after must not be negative")
+	}
+	if opts.ForcePurgeAfter > 0 && opts.QuarantineAfter > 0 && opts.QuarantineAfter <= opts.ForcePurgeAfter {
+		return opts, fmt.Errorf("--quarantine-after must be greater than --force-purge-after, or releases are quarantined before they can be force purged")
+	}
+
+	if opts.HookTimeout < 0 {
+		return opts, fmt.Errorf("--hook-timeout must not be negative")
+	}
+
+	if opts.StateConfigMap != "" && opts.StateFile != "" {
+		return opts, fmt.Errorf("--state-configmap and --state-file cannot be used together")
+	}
+	if opts.StateConfigMap != "" {
+		if namespace, name, ok := strings.Cut(opts.StateConfigMap, "/"); !ok || namespace == "" || name == "" {
+			return opts, fmt.Errorf("invalid --state-configmap %q (expected namespace/name)", opts.StateConfigMap)
+		}
+	}
+
+	if opts.MaxDeletionsPerCycle < 0 {
+		return opts, fmt.Errorf("--max-deletions-per-cycle must not be negative")
+	}
+
+	if opts.MaxDeletionFailurePercent < 0 || opts.MaxDeletionFailurePercent > 100 {
+		return opts, fmt.Errorf("--max-deletion-failure-percent must be between 0 and 100")
+	}
+
+	if !hasReleasePruning && opts.HistoryMax == 0 && !opts.CleanupOrphanNamespaces {
+		return opts, fmt.Errorf("at least one of release pruning filters, --history-max, or --cleanup-orphan-namespaces (with --orphan-namespace-filter or --orphan-namespace-selector) must be specified")
+	}
+
+	return opts, nil
+}
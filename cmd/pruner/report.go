@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/FairwindsOps/helm-release-pruner/pkg/pruner"
+)
+
+// Exit codes used with --once --detailed-exit-codes. Any other error exits 1.
+const (
+	exitNothingToDo    = 0
+	exitError          = 1
+	exitPruned         = 2
+	exitPartialFailure = 3
+	exitSafetyGuard    = 4
+)
+
+// outcomes names the exit codes in reports.
+var outcomes = map[int]string{
+	exitNothingToDo:    "nothing-to-do",
+	exitError:          "error",
+	exitPruned:         "pruned",
+	exitPartialFailure: "partial-failure",
+	exitSafetyGuard:    "aborted",
+}
+
+// exitSeverity orders exit codes from least to most severe, to pick the exit
+// code of a run over several clusters.
+var exitSeverity = []int{exitNothingToDo, exitPruned, exitPartialFailure, exitSafetyGuard, exitError}
+
+// Report formats accepted by --report-format.
+const (
+	reportFormatJSON  = "json"
+	reportFormatJUnit = "junit"
+)
+
+// reportFlags holds the --once reporting flags.
+type reportFlags struct {
+	file              string
+	format            string
+	detailedExitCodes bool
+}
+
+// addFlags registers the report flags on flags.
+func (f *reportFlags) addFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&f.file, "report-file", "",
+		"With --once, write a summary of the cycle to this file")
+	flags.StringVar(&f.format, "report-format", reportFormatJSON,
+		"Format of --report-file: json or junit")
+	flags.BoolVar(&f.detailedExitCodes, "detailed-exit-codes", false,
+		"With --once, exit 0 when nothing was pruned, 2 when releases or namespaces were pruned, 3 when some deletions failed and 4 when a safety guard aborted the cycle (1 for other errors)")
+}
+
+// validate checks the report flags against the run mode.
+func (f *reportFlags) validate(runOnce bool) error {
+	if f.format != reportFormatJSON && f.format != reportFormatJUnit {
+		return fmt.Errorf("invalid --report-format %q (must be %s or %s)", f.format, reportFormatJSON, reportFormatJUnit)
+	}
+	if !runOnce && (f.file != "" || f.detailedExitCodes) {
+		return fmt.Errorf("--report-file and --detailed-exit-codes require --once")
+	}
+	return nil
+}
+
+// exitCodeError makes the process exit with code, after printing err if set.
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e *exitCodeError) Error() string {
+	if e.err == nil {
+		return "exit status " + strconv.Itoa(e.code)
+	}
+	return e.err.Error()
+}
+
+func (e *exitCodeError) Unwrap() error {
+	return e.err
+}
+
+// cycleReport is the outcome of a --once cycle on one cluster.
+type cycleReport struct {
+	Cluster         string              `json:"cluster,omitempty"`
+	Outcome         string              `json:"outcome"`
+	StartedAt       time.Time           `json:"startedAt"`
+	DurationSeconds float64             `json:"durationSeconds"`
+	Error           string              `json:"error,omitempty"`
+	Result          *pruner.CycleResult `json:"result,omitempty"`
+
+	err error
+}
+
+// exitCode returns the detailed exit code for the cycle.
+func (r *cycleReport) exitCode() int {
+	switch {
+	case errors.Is(r.err, pruner.ErrSafetyGuard):
+		return exitSafetyGuard
+	case errors.Is(r.err, pruner.ErrDeletionFailures):
+		return exitPartialFailure
+	case r.err != nil || r.Result == nil:
+		return exitError
+	case len(r.Result.Failed) > 0:
+		return exitPartialFailure
+	case len(r.Result.Deleted) > 0 || len(r.Result.NamespacesDeleted) > 0:
+		return exitPruned
+	default:
+		return exitNothingToDo
+	}
+}
+
+// runReport summarizes a --once run over every cluster.
+type runReport struct {
+	Outcome     string        `json:"outcome"`
+	GeneratedAt time.Time     `json:"generatedAt"`
+	Clusters    []cycleReport `json:"clusters"`
+}
+
+// newRunReport builds the report of a run and returns it with the run's
+// detailed exit code, the most severe of any cluster.
+func newRunReport(reports []cycleReport) (*runReport, int) {
+	code := exitNothingToDo
+	for i := range reports {
+		r := &reports[i]
+		c := r.exitCode()
+		r.Outcome = outcomes[c]
+		if r.err != nil {
+			r.Error = r.err.Error()
+		}
+		if severity(c) > severity(code) {
+			code = c
+		}
+	}
+	return &runReport{Outcome: outcomes[code], GeneratedAt: time.Now(), Clusters: reports}, code
+}
+
+func severity(code int) int {
+	for i, c := range exitSeverity {
+		if c == code {
+			return i
+		}
+	}
+	return len(exitSeverity)
+}
+
+// finishOnce writes the report of a --once run and returns the error that
+// sets the process exit code. Failed deletions fail the run even when they
+// stayed below --max-deletion-failure-percent.
+func finishOnce(f reportFlags, reports []cycleReport) error {
+	report, code := newRunReport(reports)
+
+	var (
+		errs   []error
+		failed int
+	)
+	for _, r := range reports {
+		errs = append(errs, r.err)
+		if r.Result != nil {
+			failed += len(r.Result.Failed)
+		}
+	}
+	err := errors.Join(errs...)
+	if err == nil && failed > 0 {
+		err = fmt.Errorf("%d deletions failed", failed)
+	}
+
+	if f.file != "" {
+		if writeErr := writeReport(f.file, f.format, report); writeErr != nil {
+			return errors.Join(err, writeErr)
+		}
+	}
+
+	if !f.detailedExitCodes || code == exitNothingToDo {
+		return err
+	}
+	return &exitCodeError{code: code, err: err}
+}
+
+// writeReport writes report to path in the given format.
+func writeReport(path, format string, report *runReport) error {
+	var (
+		data []byte
+		err  error
+	)
+	if format == reportFormatJUnit {
+		data, err = xml.MarshalIndent(newJUnitReport(report), "", "  ")
+		data = append([]byte(xml.Header), data...)
+	} else {
+		data, err = json.MarshalIndent(report, "", "  ")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// junitTestSuites is the root of a JUnit XML report.
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+// junitTestSuite holds the test cases of one cluster.
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+// newJUnitReport renders a run report as JUnit XML: a test suite per
+// cluster, with a test case for the cycle itself, one per deleted, failed
+// or skipped release and per deleted namespace, and a skipped one per object
+// a force purge left in place.
+func newJUnitReport(report *runReport) *junitTestSuites {
+	root := &junitTestSuites{Name: "helm-release-pruner"}
+	for _, r := range report.Clusters {
+		suite := junitTestSuite{
+			Name:      r.Cluster,
+			Time:      strconv.FormatFloat(r.DurationSeconds, 'f', 3, 64),
+			Timestamp: r.StartedAt.UTC().Format(time.RFC3339),
+		}
+		if suite.Name == "" {
+			suite.Name = root.Name
+		}
+
+		cycle := junitTestCase{ClassName: "cycle", Name: "prune cycle"}
+		if r.Error != "" {
+			cycle.Error = &junitMessage{Message: r.Error}
+			suite.Errors++
+		}
+		suite.Cases = append(suite.Cases, cycle)
+
+		if res := r.Result; res != nil {
+			for _, rel := range res.Deleted {
+				suite.Cases = append(suite.Cases, junitTestCase{ClassName: "releases", Name: rel.Namespace + "/" + rel.Name})
+			}
+			for _, ns := range res.NamespacesDeleted {
+				suite.Cases = append(suite.Cases, junitTestCase{ClassName: "namespaces", Name: ns})
+			}
+			for _, f := range res.Failed {
+				tc := junitTestCase{ClassName: "namespaces", Name: f.Namespace, Failure: &junitMessage{Message: f.Error}}
+				if f.Release != "" {
+					tc.ClassName, tc.Name = "releases", f.Namespace+"/"+f.Release
+				}
+				suite.Cases = append(suite.Cases, tc)
+				suite.Failures++
+			}
+			for _, s := range res.Skipped {
+				// Releases outside the filters would add a skipped case
+				// for most of the cluster; the JSON report lists them.
+				if s.Filtered {
+					continue
+				}
+				suite.Cases = append(suite.Cases, junitTestCase{
+					ClassName: "releases",
+					Name:      s.Namespace + "/" + s.Name,
+					Skipped:   &junitMessage{Message: s.Reason},
+				})
+				suite.Skipped++
+			}
+			for _, purged := range res.Purged {
+				for _, obj := range purged.Skipped {
+					suite.Cases = append(suite.Cases, junitTestCase{
+						ClassName: "purged-objects",
+						Name:      fmt.Sprintf("%s/%s: %s %s", purged.Namespace, purged.Name, obj.Kind, obj.Name),
+						Skipped:   &junitMessage{Message: obj.Reason},
+					})
+					suite.Skipped++
+				}
+			}
+		}
+
+		suite.Tests = len(suite.Cases)
+		root.Tests += suite.Tests
+		root.Failures += suite.Failures
+		root.Errors += suite.Errors
+		root.Skipped += suite.Skipped
+		root.Suites = append(root.Suites, suite)
+	}
+	return root
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/FairwindsOps/helm-release-pruner/pkg/pruner"
+)
+
+func TestCycleReportExitCode(t *testing.T) {
+	deleted := []pruner.ReleaseRef{{Name: "web", Namespace: "feature-a"}}
+	failed := []pruner.FailedDeletion{{Release: "api", Namespace: "feature-b", Error: "timed out"}}
+
+	tests := []struct {
+		name   string
+		report cycleReport
+		code   int
+	}{
+		{"nothing to do", cycleReport{Result: &pruner.CycleResult{Scanned: 4}}, exitNothingToDo},
+		{"pruned releases", cycleReport{Result: &pruner.CycleResult{Deleted: deleted}}, exitPruned},
+		{"pruned namespaces", cycleReport{Result: &pruner.CycleResult{NamespacesDeleted: []string{"feature-a"}}}, exitPruned},
+		{"tolerated failures", cycleReport{Result: &pruner.CycleResult{Deleted: deleted, Failed: failed}}, exitPartialFailure},
+		{"too many failures", cycleReport{
+			Result: &pruner.CycleResult{Failed: failed},
+			err:    fmt.Errorf("cluster a: %w", pruner.ErrDeletionFailures),
+		}, exitPartialFailure},
+		{"safety guard", cycleReport{
+			Result: &pruner.CycleResult{},
+			err:    fmt.Errorf("release pruning failed: %w", pruner.ErrSafetyGuard),
+		}, exitSafetyGuard},
+		{"error", cycleReport{Result: &pruner.CycleResult{}, err: errors.New("cluster unreachable")}, exitError},
+		{"panic", cycleReport{err: errors.New("panic: boom")}, exitError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := tt.report.exitCode(); code != tt.code {
+				t.Errorf("exitCode() = %d, want %d", code, tt.code)
+			}
+		})
+	}
+}
+
+func TestFinishOnce(t *testing.T) {
+	reports := func() []cycleReport {
+		return []cycleReport{
+			{Cluster: "staging", Result: &pruner.CycleResult{Deleted: []pruner.ReleaseRef{{Name: "web", Namespace: "feature-a"}}}},
+			{Cluster: "production", Result: &pruner.CycleResult{}},
+		}
+	}
+
+	if err := finishOnce(reportFlags{}, reports()); err != nil {
+		t.Errorf("expected success without --detailed-exit-codes, got %v", err)
+	}
+
+	err := finishOnce(reportFlags{detailedExitCodes: true}, reports())
+	var exit *exitCodeError
+	if !errors.As(err, &exit) || exit.code != exitPruned || exit.err != nil {
+		t.Errorf("expected exit code %d without an error, got %v", exitPruned, err)
+	}
+
+	failing := reports()
+	failing[1].err = fmt.Errorf("cluster production: %w", pruner.ErrSafetyGuard)
+	err = finishOnce(reportFlags{detailedExitCodes: true}, failing)
+	if !errors.As(err, &exit) || exit.code != exitSafetyGuard || !errors.Is(err, pruner.ErrSafetyGuard) {
+		t.Errorf("expected exit code %d wrapping the guard error, got %v", exitSafetyGuard, err)
+	}
+
+	if err := finishOnce(reportFlags{}, failing); err == nil || errors.As(err, &exit) {
+		t.Errorf("expected a plain error without --detailed-exit-codes, got %v", err)
+	}
+
+	partial := reports()
+	partial[0].Result.Failed = []pruner.FailedDeletion{{Release: "api", Namespace: "feature-a", Error: "boom"}}
+	if err := finishOnce(reportFlags{}, partial); err == nil || errors.As(err, &exit) {
+		t.Errorf("expected failed deletions to fail the run without --detailed-exit-codes, got %v", err)
+	}
+	err = finishOnce(reportFlags{detailedExitCodes: true}, partial)
+	if !errors.As(err, &exit) || exit.code != exitPartialFailure {
+		t.Errorf("expected exit code %d, got %v", exitPartialFailure, err)
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	reports := []cycleReport{{
+		Cluster:         "staging",
+		StartedAt:       time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		DurationSeconds: 1.5,
+		Result: &pruner.CycleResult{
+			Scanned: 3,
+			Deleted: []pruner.ReleaseRef{{Name: "web", Namespace: "feature-a"}},
+			Failed:  []pruner.FailedDeletion{{Release: "api", Namespace: "feature-b", Error: "timed out"}},
+			Skipped: []pruner.SkippedRelease{
+				{Name: "db", Namespace: "production", Reason: "namespace filter", Filtered: true},
+				{Name: "api", Namespace: "feature-c", Reason: "quarantined after 3 failed uninstalls"},
+			},
+			NamespacesDeleted: []string{"feature-a"},
+			Purged: []pruner.PurgedRelease{{
+				Name:      "web",
+				Namespace: "feature-a",
+				Skipped:   []pruner.SkippedObject{{APIVersion: "example.com/v1", Kind: "Gadget", Name: "web", Reason: "kind not served by the cluster"}},
+			}},
+		},
+	}}
+	report, code := newRunReport(reports)
+	if code != exitPartialFailure || report.Outcome != "partial-failure" {
+		t.Fatalf("newRunReport() = %q (%d), want partial-failure", report.Outcome, code)
+	}
+
+	dir := t.TempDir()
+
+	jsonFile := filepath.Join(dir, "report.json")
+	if err := writeReport(jsonFile, reportFormatJSON, report); err != nil {
+		t.Fatalf("writeReport(json): %v", err)
+	}
+	var decoded runReport
+	data, _ := os.ReadFile(jsonFile)
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("invalid JSON report: %v", err)
+	}
+	if len(decoded.Clusters) != 1 || decoded.Clusters[0].Outcome != "partial-failure" ||
+		decoded.Clusters[0].Result.Scanned != 3 {
+		t.Errorf("unexpected JSON report %s", data)
+	}
+
+	junitFile := filepath.Join(dir, "report.xml")
+	if err := writeReport(junitFile, reportFormatJUnit, report); err != nil {
+		t.Fatalf("writeReport(junit): %v", err)
+	}
+	var suites junitTestSuites
+	data, _ = os.ReadFile(junitFile)
+	if err := xml.Unmarshal(data, &suites); err != nil {
+		t.Fatalf("invalid JUnit report: %v", err)
+	}
+	// The cycle, one deleted release, one deleted namespace, one failure, the
+	// quarantined release and one object skipped by a force purge. Filtered
+	// releases are left out.
+	if suites.Tests != 6 || suites.Failures != 1 || suites.Skipped != 2 || suites.Errors != 0 {
+		t.Errorf("unexpected JUnit totals %+v", suites)
+	}
+	if len(suites.Suites) != 1 || suites.Suites[0].Name != "staging" {
+		t.Fatalf("unexpected JUnit suites %+v", suites.Suites)
+	}
+	for _, tc := range suites.Suites[0].Cases {
+		if tc.Failure != nil && (tc.Name != "feature-b/api" || tc.Failure.Message != "timed out") {
+			t.Errorf("unexpected failed test case %+v", tc)
+		}
+	}
+}
+
+func TestReportFlagsValidate(t *testing.T) {
+	tests := []struct {
+		flags   reportFlags
+		once    bool
+		wantErr bool
+	}{
+		{reportFlags{format: reportFormatJSON}, false, false},
+		{reportFlags{format: reportFormatJUnit, file: "report.xml", detailedExitCodes: true}, true, false},
+		{reportFlags{format: reportFormatJSON, file: "report.json"}, false, true},
+		{reportFlags{format: reportFormatJSON, detailedExitCodes: true}, false, true},
+		{reportFlags{format: "yaml"}, true, true},
+	}
+	for _, tt := range tests {
+		if err := tt.flags.validate(tt.once); (err != nil) != tt.wantErr {
+			t.Errorf("validate(%+v, once=%v) = %v, wantErr %v", tt.flags, tt.once, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/pflag"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/FairwindsOps/helm-release-pruner/pkg/pruner"
+)
+
+// serverFlags configures the health, metrics and control server.
+type serverFlags struct {
+	addr             string
+	probeAddr        string
+	controlToken     string
+	controlTokenFile string
+	tokenReview      bool
+	metricsAuth      bool
+	tlsCertFile      string
+	tlsKeyFile       string
+	tlsClientCAFile  string
+}
+
+// addFlags registers the server flags on flags.
+func (f *serverFlags) addFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&f.addr, "health-addr", ":8080",
+		"Address for health check, metrics and control endpoints")
+	flags.StringVar(&f.probeAddr, "probe-addr", "",
+		"Separate plain-HTTP address for unauthenticated /healthz and /readyz (default: serve them on --health-addr)")
+	// The token is a secret, so the environment variable is read in
+	// authorizer rather than shown as the flag default in --help.
+	flags.StringVar(&f.controlToken, "control-token", "",
+		"Bearer token for the control API (/trigger, /pause, /resume, /status, /plan) (defaults to $HELM_PRUNER_CONTROL_TOKEN)")
+	flags.StringVar(&f.controlTokenFile, "control-token-file", "",
+		"File containing the control API bearer token, re-read when it changes")
+	flags.BoolVar(&f.tokenReview, "token-review", false,
+		"Authorize control API (and --metrics-auth) requests with Kubernetes TokenReview and SubjectAccessReview instead of a shared token")
+	flags.BoolVar(&f.metricsAuth, "metrics-auth", false,
+		"Require the same authorization for /metrics as for the control API")
+	flags.StringVar(&f.tlsCertFile, "tls-cert-file", "",
+		"Serve --health-addr over HTTPS with this certificate, reloaded when it changes")
+	flags.StringVar(&f.tlsKeyFile, "tls-key-file", "",
+		"Private key for --tls-cert-file, reloaded when it changes")
+	flags.StringVar(&f.tlsClientCAFile, "tls-client-ca-file", "",
+		"Require client certificates signed by this CA on --health-addr (mutual TLS); requires --probe-addr")
+}
+
+// authorizer returns the authorizer for protected endpoints, or nil when no
+// authorization is configured, which disables the control API.
+func (f *serverFlags) authorizer() (authorizer, error) {
+	controlToken := f.controlToken
+	if controlToken == "" {
+		controlToken = os.Getenv("HELM_PRUNER_CONTROL_TOKEN")
+	}
+
+	configured := 0
+	for _, set := range []bool{controlToken != "", f.controlTokenFile != "", f.tokenReview} {
+		if set {
+			configured++
+		}
+	}
+	if configured > 1 {
+		return nil, fmt.Errorf("only one of --control-token, --control-token-file or --token-review can be used")
+	}
+
+	switch {
+	case controlToken != "":
+		return tokenAuthorizer{token: staticToken(controlToken)}, nil
+	case f.controlTokenFile != "":
+		tf, err := newTokenFile(f.controlTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		return tokenAuthorizer{token: tf.read}, nil
+	case f.tokenReview:
+		config, err := inClusterOrDefaultConfig()
+		if err != nil {
+			return nil, err
+		}
+		client, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create kubernetes client for token review: %w", err)
+		}
+		return tokenReviewAuthorizer{client: client}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// inClusterOrDefaultConfig returns the config of the cluster the pruner
+// runs in, falling back to the default kubeconfig.
+func inClusterOrDefaultConfig() (*rest.Config, error) {
+	if config, err := rest.InClusterConfig(); err == nil {
+		return config, nil
+	}
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get kubernetes config for token review: %w", err)
+	}
+	return config, nil
+}
+
+// startServers starts the HTTP server for /healthz, /readyz and /metrics,
+// plus the control API when authorization is configured. With --probe-addr,
+// /healthz and /readyz are served there instead, over plain HTTP and
+// without authorization; mutual TLS requires it. With several clusters,
+// /readyz reports each cluster on its own line and /readyz/{cluster} reports
+// a single cluster.
+func startServers(cfg serverFlags, pruners []*pruner.Pruner) ([]*http.Server, error) {
+	auth, err := cfg.authorizer()
+	if err != nil {
+		return nil, err
+	}
+
+	var tlsConfig *tls.Config
+	switch {
+	case cfg.tlsClientCAFile != "" && cfg.probeAddr == "":
+		// Kubelet probes cannot present client certificates.
+		return nil, fmt.Errorf("--tls-client-ca-file requires --probe-addr to serve /healthz and /readyz without client certificates")
+	case cfg.tlsCertFile != "" && cfg.tlsKeyFile != "":
+		tlsConfig, err = newTLSConfig(cfg.tlsCertFile, cfg.tlsKeyFile, cfg.tlsClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+	case cfg.tlsCertFile != "" || cfg.tlsKeyFile != "":
+		return nil, fmt.Errorf("--tls-cert-file and --tls-key-file must be set together")
+	case cfg.tlsClientCAFile != "":
+		return nil, fmt.Errorf("--tls-client-ca-file requires --tls-cert-file and --tls-key-file")
+	}
+
+	mux := http.NewServeMux()
+	probes := mux
+	if cfg.probeAddr != "" {
+		probes = http.NewServeMux()
+	}
+	registerProbes(probes, pruners)
+
+	var metrics http.Handler = promhttp.Handler()
+	if cfg.metricsAuth {
+		if auth == nil {
+			return nil, fmt.Errorf("--metrics-auth requires --control-token, --control-token-file or --token-review")
+		}
+		metrics = requireAuth(auth, metrics)
+	}
+	mux.Handle("/metrics", metrics)
+
+	if auth != nil {
+		registerControlAPI(mux, pruners, auth)
+	}
+
+	servers := []*http.Server{newServer(cfg.addr, mux, tlsConfig)}
+	if cfg.probeAddr != "" {
+		servers = append(servers, newServer(cfg.probeAddr, probes, nil))
+	}
+
+	for _, srv := range servers {
+		go func() {
+			var err error
+			if srv.TLSConfig != nil {
+				err = srv.ListenAndServeTLS("", "")
+			} else {
+				err = srv.ListenAndServe()
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fmt.Fprintf(os.Stderr, "health server error: %v\n", err)
+			}
+		}()
+	}
+
+	return servers, nil
+}
+
+func newServer(addr string, handler http.Handler, tlsConfig *tls.Config) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		ReadTimeout:       5 * time.Second,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      60 * time.Second, // GET /plan lists every release
+		IdleTimeout:       60 * time.Second,
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader serves a certificate and key from files, reloading them
+// when either file changes so that rotated certificates (e.g. from
+// cert-manager) are picked up without a restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+// newCertReloader loads the certificate once, so that bad files fail at
+// startup.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.getCertificate(nil); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// getCertificate implements tls.Config.GetCertificate. If a changed
+// certificate cannot be loaded, for example while only one of the two files
+// has been replaced, the previous certificate keeps being served.
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certInfo, certErr := os.Stat(r.certFile)
+	keyInfo, keyErr := os.Stat(r.keyFile)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if certErr == nil && keyErr == nil &&
+		(!certInfo.ModTime().Equal(r.certMod) || !keyInfo.ModTime().Equal(r.keyMod)) {
+		cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		switch {
+		case err == nil:
+			r.cert = &cert
+			r.certMod = certInfo.ModTime()
+			r.keyMod = keyInfo.ModTime()
+		case r.cert != nil:
+			fmt.Fprintf(os.Stderr, "failed to reload TLS certificate, serving the previous one: %v\n", err)
+		default:
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+	}
+
+	if r.cert == nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", firstError(certErr, keyErr))
+	}
+	return r.cert, nil
+}
+
+// newTLSConfig builds the server TLS configuration. With a client CA file,
+// clients must present a certificate signed by it (mutual TLS).
+func newTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+	}
+
+	if clientCAFile != "" {
+		data, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
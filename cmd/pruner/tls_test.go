@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate and key for commonName to dir
+// and returns their paths.
+func writeCert(t *testing.T, dir, commonName string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		DNSNames:     []string{commonName},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// servedName returns the common name of the certificate the reloader serves.
+func servedName(t *testing.T, r *certReloader) string {
+	t.Helper()
+	cert, err := r.getCertificate(nil)
+	if err != nil {
+		t.Fatalf("getCertificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, "first")
+
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("newCertReloader: %v", err)
+	}
+	if name := servedName(t, r); name != "first" {
+		t.Errorf("served %q, want first", name)
+	}
+
+	writeCert(t, dir, "second")
+	future := time.Now().Add(time.Minute)
+	for _, f := range []string{certFile, keyFile} {
+		if err := os.Chtimes(f, future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if name := servedName(t, r); name != "second" {
+		t.Errorf("served %q after rotation, want second", name)
+	}
+
+	// A half-written rotation keeps serving the previous certificate.
+	if err := os.WriteFile(keyFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := future.Add(time.Minute)
+	if err := os.Chtimes(keyFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if name := servedName(t, r); name != "second" {
+		t.Errorf("served %q with a broken key, want the previous certificate", name)
+	}
+
+	if _, err := newCertReloader(filepath.Join(dir, "missing.crt"), keyFile); err == nil {
+		t.Error("expected missing certificate files to fail")
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	certFile, keyFile := writeCert(t, t.TempDir(), "pruner")
+
+	config, err := newTLSConfig(certFile, keyFile, "")
+	if err != nil {
+		t.Fatalf("newTLSConfig: %v", err)
+	}
+	if config.ClientAuth != tls.NoClientCert {
+		t.Errorf("expected no client certificate requirement without a client CA")
+	}
+
+	caFile, _ := writeCert(t, t.TempDir(), "clients")
+	config, err = newTLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("newTLSConfig with client CA: %v", err)
+	}
+	if config.ClientAuth != tls.RequireAndVerifyClientCert || config.ClientCAs == nil {
+		t.Errorf("expected mutual TLS with a client CA")
+	}
+
+	if _, err := newTLSConfig(certFile, keyFile, keyFile); err == nil {
+		t.Error("expected a client CA file without certificates to fail")
+	}
+}
+
+func TestStartServers_MutualTLSRequiresProbeAddr(t *testing.T) {
+	certFile, keyFile := writeCert(t, t.TempDir(), "pruner")
+	caFile, _ := writeCert(t, t.TempDir(), "clients")
+	cfg := serverFlags{
+		addr:            "127.0.0.1:0",
+		tlsCertFile:     certFile,
+		tlsKeyFile:      keyFile,
+		tlsClientCAFile: caFile,
+	}
+
+	if _, err := startServers(cfg, nil); err == nil || !strings.Contains(err.Error(), "--probe-addr") {
+		t.Errorf("startServers with mutual TLS and no --probe-addr = %v, want an error", err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/pflag"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.39.0"
+)
+
+// Trace exporters accepted by --trace-exporter.
+const (
+	traceExporterNone   = "none"
+	traceExporterOTLP   = "otlp"
+	traceExporterStdout = "stdout"
+)
+
+// tracingFlags holds the process-level tracing flags.
+type tracingFlags struct {
+	exporter string
+	output   string
+}
+
+// addFlags registers the tracing flags on flags.
+func (f *tracingFlags) addFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&f.exporter, "trace-exporter", traceExporterNone,
+		"Export OpenTelemetry traces of prune cycles: none, otlp (configured with the standard OTEL_EXPORTER_OTLP_* variables) or stdout")
+	flags.StringVar(&f.output, "trace-output", "",
+		"File the stdout trace exporter appends spans to, one JSON object per span (default: standard output)")
+}
+
+// setup installs the global tracer provider for the configured exporter and
+// returns a function that flushes and stops it. With the none exporter, the
+// default no-op provider is left in place.
+func (f *tracingFlags) setup(ctx context.Context) (func(context.Context) error, error) {
+	if f.output != "" && f.exporter != traceExporterStdout {
+		return nil, fmt.Errorf("--trace-output requires --trace-exporter=%s", traceExporterStdout)
+	}
+
+	var (
+		exporter sdktrace.SpanExporter
+		closer   io.Closer
+		err      error
+	)
+	switch f.exporter {
+	case traceExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case traceExporterOTLP:
+		exporter, err = otlptracehttp.New(ctx)
+	case traceExporterStdout:
+		var w io.Writer = os.Stdout
+		if f.output != "" {
+			file, openErr := os.OpenFile(f.output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+			if openErr != nil {
+				return nil, fmt.Errorf("failed to open trace output: %w", openErr)
+			}
+			w, closer = file, file
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	default:
+		return nil, fmt.Errorf("invalid --trace-exporter %q (must be %s, %s or %s)",
+			f.exporter, traceExporterNone, traceExporterOTLP, traceExporterStdout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %w", f.exporter, err)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			semconv.ServiceName("helm-release-pruner"),
+			semconv.ServiceVersion(version)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			err = firstError(err, closer.Close())
+		}
+		return err
+	}, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+)
+
+func TestTracingFlags_Setup(t *testing.T) {
+	for _, f := range []tracingFlags{
+		{exporter: "jaeger"},
+		{exporter: traceExporterOTLP, output: "spans.json"},
+	} {
+		if _, err := f.setup(context.Background()); err == nil {
+			t.Errorf("expected %+v to be rejected", f)
+		}
+	}
+
+	shutdown, err := (&tracingFlags{exporter: traceExporterNone}).setup(context.Background())
+	if err != nil {
+		t.Fatalf("setup(none): %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown(none): %v", err)
+	}
+}
+
+func TestTracingFlags_StdoutFile(t *testing.T) {
+	original := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(original) })
+
+	output := filepath.Join(t.TempDir(), "spans.json")
+	f := tracingFlags{exporter: traceExporterStdout, output: output}
+	shutdown, err := f.setup(context.Background())
+	if err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+
+	_, span := otel.Tracer("test").Start(context.Background(), "prune-cycle")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exported struct {
+		Name     string
+		Resource []struct {
+			Key   string
+			Value struct{ Value any }
+		}
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(string(data))), &exported); err != nil {
+		t.Fatalf("expected one JSON span, got %q: %v", data, err)
+	}
+	if exported.Name != "prune-cycle" {
+		t.Errorf("exported span %q, want prune-cycle", exported.Name)
+	}
+	var service any
+	for _, kv := range exported.Resource {
+		if kv.Key == "service.name" {
+			service = kv.Value.Value
+		}
+	}
+	if service != "helm-release-pruner" {
+		t.Errorf("service.name = %v, want helm-release-pruner", service)
+	}
+}
@@ -0,0 +1,240 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Built-in activity signals accepted in Options.ActivitySignals.
+const (
+	ActivityPods       = "pods"
+	ActivityAnnotation = "annotation"
+	ActivityIngress    = "ingress"
+)
+
+// LastActivityAnnotation is the namespace annotation, in RFC 3339 format,
+// that CI pipelines bump to mark a namespace's releases as in use.
+const LastActivityAnnotation = "pruner.fairwinds.com/last-activity"
+
+// ActivitySignal reports when releases were last in use. Release age for
+// pruning is measured from the most recent of the release's last deployment
+// and every configured signal.
+type ActivitySignal interface {
+	// Name identifies the signal in logs.
+	Name() string
+
+	// LastActivity returns the last activity time of each release it knows
+	// about. Releases without activity can be left out. It is called once
+	// per prune cycle with all candidate releases.
+	LastActivity(ctx context.Context, releases []*releasev1.Release) (map[*releasev1.Release]time.Time, error)
+}
+
+// newActivitySignals builds the built-in signals named in the options,
+// followed by any custom signals.
+func newActivitySignals(opts Options, k8s kubernetes.Interface) ([]ActivitySignal, error) {
+	var signals []ActivitySignal
+	for _, name := range opts.ActivitySignals {
+		switch name {
+		case ActivityPods:
+			signals = append(signals, podStartSignal{k8s: k8s})
+		case ActivityAnnotation:
+			signals = append(signals, annotationSignal{k8s: k8s})
+		case ActivityIngress:
+			namespace, configMap, ok := strings.Cut(opts.ActivityConfigMap, "/")
+			if !ok || namespace == "" || configMap == "" {
+				return nil, fmt.Errorf("activity signal %q requires a ConfigMap as namespace/name", ActivityIngress)
+			}
+			signals = append(signals, ingressAccessSignal{k8s: k8s, namespace: namespace, name: configMap})
+		default:
+			return nil, fmt.Errorf("unknown activity signal %q (valid: %s, %s, %s)",
+				name, ActivityPods, ActivityAnnotation, ActivityIngress)
+		}
+	}
+	return append(signals, opts.CustomActivitySignals...), nil
+}
+
+// collectActivity queries every activity signal and records, for each
+// release, the most recent activity newer than its last deployment. A
+// signal that fails fails the collection, so that releases are never judged
+// stale on incomplete information.
+func (p *Pruner) collectActivity(ctx context.Context, releases []*releasev1.Release) (map[*releasev1.Release]time.Time, error) {
+	if len(p.activitySignals) == 0 || len(releases) == 0 {
+		return nil, nil
+	}
+
+	activity := make(map[*releasev1.Release]time.Time)
+	for _, signal := range p.activitySignals {
+		times, err := signal.LastActivity(ctx, releases)
+		if err != nil {
+			return nil, fmt.Errorf("activity signal %s: %w", signal.Name(), err)
+		}
+		for rel, t := range times {
+			if t.After(rel.Info.LastDeployed) && t.After(activity[rel]) {
+				p.logger.Debug("release activity",
+					"name", rel.Name,
+					"namespace", rel.Namespace,
+					"signal", signal.Name(),
+					"last_activity", t)
+				activity[rel] = t
+			}
+		}
+	}
+	return activity, nil
+}
+
+// rememberActivity merges the activity collected this cycle with the
+// activity observed in earlier cycles, so that a release stays active when
+// the evidence of its activity goes away, for example when its pods are
+// replaced. Observations older than the last deployment are ignored.
+func (p *Pruner) rememberActivity(releases []*releasev1.Release, activity map[*releasev1.Release]time.Time) map[*releasev1.Release]time.Time {
+	if len(p.activitySignals) == 0 {
+		return activity
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.lastActivity == nil {
+		p.lastActivity = make(map[string]time.Time)
+	}
+	merged := make(map[*releasev1.Release]time.Time, len(releases))
+	for _, rel := range releases {
+		key := releaseKey(rel.Namespace, rel.Name)
+		t := activity[rel]
+		if seen := p.lastActivity[key]; seen.After(t) {
+			t = seen
+		}
+		if !t.After(rel.Info.LastDeployed) {
+			if !p.previewing {
+				delete(p.lastActivity, key)
+			}
+			continue
+		}
+		merged[rel] = t
+		if !p.previewing {
+			p.lastActivity[key] = t
+		}
+	}
+	return merged
+}
+
+// lastActive returns when a release was last deployed or, if more recent,
+// last active according to the activity collected this cycle.
+func (p *Pruner) lastActive(rel *releasev1.Release) time.Time {
+	if t, ok := p.activity[rel]; ok {
+		return t
+	}
+	return rel.Info.LastDeployed
+}
+
+// byNamespace assigns each release the activity time of its namespace.
+func byNamespace(releases []*releasev1.Release, namespaces map[string]time.Time) map[*releasev1.Release]time.Time {
+	times := make(map[*releasev1.Release]time.Time)
+	for _, rel := range releases {
+		if t, ok := namespaces[rel.Namespace]; ok {
+			times[rel] = t
+		}
+	}
+	return times
+}
+
+// podStartSignal uses the latest pod start time in a release's namespace.
+type podStartSignal struct {
+	k8s kubernetes.Interface
+}
+
+func (podStartSignal) Name() string { return ActivityPods }
+
+func (s podStartSignal) LastActivity(ctx context.Context, releases []*releasev1.Release) (map[*releasev1.Release]time.Time, error) {
+	pods, err := s.k8s.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	latest := make(map[string]time.Time)
+	for _, pod := range pods.Items {
+		if pod.Status.StartTime == nil {
+			continue
+		}
+		if t := pod.Status.StartTime.Time; t.After(latest[pod.Namespace]) {
+			latest[pod.Namespace] = t
+		}
+	}
+	return byNamespace(releases, latest), nil
+}
+
+// annotationSignal reads LastActivityAnnotation from a release's namespace.
+type annotationSignal struct {
+	k8s kubernetes.Interface
+}
+
+func (annotationSignal) Name() string { return ActivityAnnotation }
+
+func (s annotationSignal) LastActivity(ctx context.Context, releases []*releasev1.Release) (map[*releasev1.Release]time.Time, error) {
+	namespaces, err := s.k8s.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+	}
+
+	latest := make(map[string]time.Time)
+	for _, ns := range namespaces.Items {
+		value, ok := ns.Annotations[LastActivityAnnotation]
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			// A malformed timestamp is ignored rather than failing every cycle.
+			continue
+		}
+		latest[ns.Name] = t
+	}
+	return byNamespace(releases, latest), nil
+}
+
+// ingressAccessSignal reads Ingress access times from a ConfigMap that an
+// ingress proxy maintains, keyed by host name with RFC 3339 values. A
+// namespace is as recent as the most recently accessed host of its Ingresses.
+type ingressAccessSignal struct {
+	k8s       kubernetes.Interface
+	namespace string
+	name      string
+}
+
+func (ingressAccessSignal) Name() string { return ActivityIngress }
+
+func (s ingressAccessSignal) LastActivity(ctx context.Context, releases []*releasev1.Release) (map[*releasev1.Release]time.Time, error) {
+	cm, err := s.k8s.CoreV1().ConfigMaps(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ingress access ConfigMap %s/%s: %w", s.namespace, s.name, err)
+	}
+
+	ingresses, err := s.k8s.NetworkingV1().Ingresses(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ingresses: %w", err)
+	}
+
+	latest := make(map[string]time.Time)
+	for _, ing := range ingresses.Items {
+		for _, rule := range ing.Spec.Rules {
+			value, ok := cm.Data[rule.Host]
+			if rule.Host == "" || !ok {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				continue
+			}
+			if t.After(latest[ing.Namespace]) {
+				latest[ing.Namespace] = t
+			}
+		}
+	}
+	return byNamespace(releases, latest), nil
+}
@@ -0,0 +1,126 @@
+package pruner
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+)
+
+// failingSignal is an activity signal that always fails.
+type failingSignal struct{}
+
+func (failingSignal) Name() string { return "failing" }
+
+func (failingSignal) LastActivity(context.Context, []*releasev1.Release) (map[*releasev1.Release]time.Time, error) {
+	return nil, errors.New("unavailable")
+}
+
+func TestActivitySignals(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	deployed := now.Add(-30 * 24 * time.Hour)
+
+	started := metav1.NewTime(now.Add(-2 * time.Hour))
+	runningPod := pod("feature-pods", "web-0", corev1.PodRunning)
+	runningPod.Status.StartTime = &started
+
+	annotated := namespace("feature-annotated", deployed)
+	annotated.Annotations = map[string]string{LastActivityAnnotation: now.Add(-time.Hour).Format(time.RFC3339)}
+	malformed := namespace("feature-malformed", deployed)
+	malformed.Annotations = map[string]string{LastActivityAnnotation: "yesterday"}
+
+	typed := []runtime.Object{
+		runningPod,
+		annotated,
+		malformed,
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ingress-nginx", Name: "access-times"},
+			Data:       map[string]string{"feature-ingress.example.com": now.Add(-3 * time.Hour).Format(time.RFC3339)},
+		},
+		&networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "feature-ingress", Name: "web"},
+			Spec: networkingv1.IngressSpec{Rules: []networkingv1.IngressRule{
+				{Host: "feature-ingress.example.com"},
+				{Host: "unknown.example.com"},
+			}},
+		},
+	}
+
+	releases := []*releasev1.Release{
+		mockRelease("pods", "feature-pods", deployed),
+		mockRelease("annotated", "feature-annotated", deployed),
+		mockRelease("malformed", "feature-malformed", deployed),
+		mockRelease("ingress", "feature-ingress", deployed),
+		mockRelease("idle", "feature-idle", deployed),
+	}
+
+	opts := Options{
+		ActivitySignals:   []string{ActivityPods, ActivityAnnotation, ActivityIngress},
+		ActivityConfigMap: "ingress-nginx/access-times",
+	}
+	p := newClusterPruner(opts, typed)
+	signals, err := newActivitySignals(opts, p.k8s)
+	if err != nil {
+		t.Fatalf("newActivitySignals: %v", err)
+	}
+	p.activitySignals = signals
+
+	p.activity, err = p.collectActivity(context.Background(), releases)
+	if err != nil {
+		t.Fatalf("collectActivity: %v", err)
+	}
+
+	expected := map[string]time.Time{
+		"pods":      now.Add(-2 * time.Hour),
+		"annotated": now.Add(-time.Hour),
+		"malformed": deployed,
+		"ingress":   now.Add(-3 * time.Hour),
+		"idle":      deployed,
+	}
+	for _, rel := range releases {
+		if got := p.lastActive(rel); !got.Equal(expected[rel.Name]) {
+			t.Errorf("lastActive(%s) = %v, want %v", rel.Name, got, expected[rel.Name])
+		}
+	}
+
+	p.opts.OlderThan = 14 * 24 * time.Hour
+	toDelete := p.selectReleasesToDelete(releases)
+	if len(toDelete) != 2 {
+		t.Errorf("expected only the idle and malformed releases to be stale, got %v", releaseNames(toDelete))
+	}
+}
+
+func TestCollectActivity_SignalError(t *testing.T) {
+	p := newTestPruner(Options{})
+	p.activitySignals = []ActivitySignal{failingSignal{}}
+
+	_, err := p.collectActivity(context.Background(), []*releasev1.Release{mockRelease("a", "ns", time.Now())})
+	if err == nil {
+		t.Fatal("expected a failing signal to fail activity collection")
+	}
+}
+
+func TestNewActivitySignals_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"unknown signal", Options{ActivitySignals: []string{"cpu"}}},
+		{"ingress without configmap", Options{ActivitySignals: []string{ActivityIngress}}},
+		{"ingress with bad configmap", Options{ActivitySignals: []string{ActivityIngress}, ActivityConfigMap: "access-times"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newActivitySignals(tt.opts, nil); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package pruner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+
+	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+)
+
+// branchCaptureGroup is the named capture group of Options.BranchPattern
+// that holds the branch name. Without it, the first capture group is used.
+const branchCaptureGroup = "branch"
+
+// branchCheckTimeout bounds each HTTP branch check.
+const branchCheckTimeout = 10 * time.Second
+
+// BranchChecker reports whether the source branch (or pull request) of a
+// preview release still exists.
+type BranchChecker interface {
+	// BranchAlive returns false once the branch is gone. An error means the
+	// branch state is unknown and the release is kept.
+	BranchAlive(ctx context.Context, branch string) (bool, error)
+}
+
+// newBranchChecker builds the branch checker configured in the options, or
+// returns nil when branch checking is disabled.
+func newBranchChecker(opts Options) (BranchChecker, error) {
+	if opts.BranchPattern == nil {
+		return nil, nil
+	}
+	if opts.BranchPattern.NumSubexp() == 0 {
+		return nil, fmt.Errorf("branch pattern %q has no capture group", opts.BranchPattern)
+	}
+
+	configured := 0
+	for _, set := range []bool{opts.BranchChecker != nil, opts.BranchGitRemote != "", opts.BranchCheckURL != ""} {
+		if set {
+			configured++
+		}
+	}
+	if configured != 1 {
+		return nil, fmt.Errorf("branch pattern requires exactly one of a git remote, a check URL or a custom branch checker")
+	}
+
+	switch {
+	case opts.BranchChecker != nil:
+		return opts.BranchChecker, nil
+	case opts.BranchGitRemote != "":
+		return &GitBranchChecker{Remote: opts.BranchGitRemote}, nil
+	default:
+		if !strings.Contains(opts.BranchCheckURL, "{branch}") {
+			return nil, fmt.Errorf("branch check URL %q must contain {branch}", opts.BranchCheckURL)
+		}
+		return &HTTPBranchChecker{URL: opts.BranchCheckURL}, nil
+	}
+}
+
+// GitBranchChecker checks branches against a git remote, which can be any
+// URL or path that git ls-remote accepts, including a local bare repository.
+type GitBranchChecker struct {
+	Remote string
+}
+
+// BranchAlive runs git ls-remote for the branch head.
+func (c *GitBranchChecker) BranchAlive(ctx context.Context, branch string) (bool, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", "ls-remote", "--heads", c.Remote, "refs/heads/"+branch)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return false, fmt.Errorf("git ls-remote: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()) != "", nil
+}
+
+// HTTPBranchChecker asks an HTTP endpoint whether a ref is alive. URL must
+// contain {branch}, which is replaced with the escaped branch name. A 2xx
+// response means alive; 404 and 410 mean gone, e.g. a deleted branch or a
+// closed pull request. Any other status is an error.
+type HTTPBranchChecker struct {
+	URL    string
+	Client *http.Client
+}
+
+// BranchAlive sends a GET request for the branch.
+func (c *HTTPBranchChecker) BranchAlive(ctx context.Context, branch string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, branchCheckTimeout)
+	defer cancel()
+
+	target := strings.ReplaceAll(c.URL, "{branch}", url.PathEscape(branch))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return false, err
+	}
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return true, nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected status %s from %s", resp.Status, target)
+	}
+}
+
+// releaseBranch extracts the branch name from a release name, or returns
+// "" when the release name does not match the branch pattern.
+func (p *Pruner) releaseBranch(name string) string {
+	m := p.opts.BranchPattern.FindStringSubmatch(name)
+	if m == nil {
+		return ""
+	}
+	if i := p.opts.BranchPattern.SubexpIndex(branchCaptureGroup); i > 0 {
+		return m[i]
+	}
+	return m[1]
+}
+
+// releasesWithGoneBranches returns the releases whose source branch no
+// longer exists. Each branch is checked once per call. Releases whose
+// branch cannot be checked are kept.
+func (p *Pruner) releasesWithGoneBranches(ctx context.Context, releases []*releasev1.Release) []*releasev1.Release {
+	if p.branchChecker == nil {
+		return nil
+	}
+
+	alive := make(map[string]bool)
+	var gone []*releasev1.Release
+	for _, rel := range releases {
+		if ctx.Err() != nil {
+			return gone
+		}
+
+		branch := p.releaseBranch(rel.Name)
+		if branch == "" {
+			continue
+		}
+
+		isAlive, checked := alive[branch]
+		if !checked {
+			var err error
+			isAlive, err = p.branchChecker.BranchAlive(ctx, branch)
+			if err != nil {
+				p.logger.Error("failed to check release branch",
+					"name", rel.Name,
+					"namespace", rel.Namespace,
+					"branch", branch,
+					"error", err)
+				isAlive = true
+			}
+			alive[branch] = isAlive
+		}
+
+		if !isAlive {
+			p.logger.Debug("release source branch is gone",
+				"name", rel.Name,
+				"namespace", rel.Namespace,
+				"branch", branch)
+			gone = append(gone, rel)
+		}
+	}
+	return gone
+}
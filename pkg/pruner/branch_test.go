@@ -0,0 +1,157 @@
+package pruner
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+)
+
+// fakeBranchChecker reports the branches in alive as existing and counts calls.
+type fakeBranchChecker struct {
+	alive map[string]bool
+	calls map[string]int
+}
+
+func (c *fakeBranchChecker) BranchAlive(_ context.Context, branch string) (bool, error) {
+	c.calls[branch]++
+	if branch == "broken" {
+		return false, errors.New("remote unavailable")
+	}
+	return c.alive[branch], nil
+}
+
+// git runs a git command in dir and fails the test on error.
+func git(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+}
+
+func TestGitBranchChecker(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	dir := t.TempDir()
+	remote := filepath.Join(dir, "remote.git")
+	work := filepath.Join(dir, "work")
+	git(t, dir, "init", "--bare", remote)
+	git(t, dir, "init", work)
+	git(t, work, "commit", "--allow-empty", "-m", "initial")
+	git(t, work, "push", remote, "HEAD:refs/heads/feature/login")
+
+	checker := &GitBranchChecker{Remote: remote}
+	ctx := context.Background()
+
+	if alive, err := checker.BranchAlive(ctx, "feature/login"); err != nil || !alive {
+		t.Errorf("BranchAlive(feature/login) = %v, %v; want true", alive, err)
+	}
+	if alive, err := checker.BranchAlive(ctx, "feature"); err != nil || alive {
+		t.Errorf("BranchAlive(feature) = %v, %v; want false for a prefix of a branch", alive, err)
+	}
+
+	git(t, work, "push", remote, ":refs/heads/feature/login")
+	if alive, err := checker.BranchAlive(ctx, "feature/login"); err != nil || alive {
+		t.Errorf("BranchAlive(feature/login) after delete = %v, %v; want false", alive, err)
+	}
+
+	missing := &GitBranchChecker{Remote: filepath.Join(dir, "missing.git")}
+	if _, err := missing.BranchAlive(ctx, "main"); err == nil {
+		t.Error("expected an error for a missing remote")
+	}
+}
+
+func TestHTTPBranchChecker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/refs/feature%2Flogin":
+			w.WriteHeader(http.StatusOK)
+		case "/refs/pr-42":
+			w.WriteHeader(http.StatusGone)
+		case "/refs/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	checker := &HTTPBranchChecker{URL: server.URL + "/refs/{branch}"}
+	tests := []struct {
+		branch  string
+		alive   bool
+		wantErr bool
+	}{
+		{"feature/login", true, false},
+		{"pr-42", false, false},
+		{"deleted", false, false},
+		{"broken", false, true},
+	}
+	for _, tt := range tests {
+		alive, err := checker.BranchAlive(context.Background(), tt.branch)
+		if alive != tt.alive || (err != nil) != tt.wantErr {
+			t.Errorf("BranchAlive(%q) = %v, %v; want %v, error %v", tt.branch, alive, err, tt.alive, tt.wantErr)
+		}
+	}
+}
+
+func TestReleasesWithGoneBranches(t *testing.T) {
+	checker := &fakeBranchChecker{
+		alive: map[string]bool{"login": true},
+		calls: make(map[string]int),
+	}
+	p := newTestPruner(Options{BranchPattern: regexp.MustCompile(`^preview-(?P<branch>[a-z0-9-]+)-(web|api)$`)})
+	p.branchChecker = checker
+
+	now := time.Now()
+	releases := []*releasev1.Release{
+		mockRelease("preview-login-web", "preview-login", now),
+		mockRelease("preview-login-api", "preview-login", now),
+		mockRelease("preview-signup-web", "preview-signup", now),
+		mockRelease("preview-broken-web", "preview-broken", now),
+		mockRelease("monitoring", "monitoring", now),
+	}
+
+	gone := p.releasesWithGoneBranches(context.Background(), releases)
+	if len(gone) != 1 || gone[0].Name != "preview-signup-web" {
+		t.Errorf("expected only preview-signup-web to be gone, got %v", releaseNames(gone))
+	}
+	if checker.calls["login"] != 1 {
+		t.Errorf("expected each branch to be checked once, login was checked %d times", checker.calls["login"])
+	}
+}
+
+func TestNewBranchChecker(t *testing.T) {
+	pattern := regexp.MustCompile(`^preview-(.+)$`)
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{"disabled", Options{}, false},
+		{"git remote", Options{BranchPattern: pattern, BranchGitRemote: "https://example.com/repo.git"}, false},
+		{"check URL", Options{BranchPattern: pattern, BranchCheckURL: "https://example.com/refs/{branch}"}, false},
+		{"no checker", Options{BranchPattern: pattern}, true},
+		{"two checkers", Options{BranchPattern: pattern, BranchGitRemote: "repo.git", BranchCheckURL: "https://example.com/{branch}"}, true},
+		{"no capture group", Options{BranchPattern: regexp.MustCompile(`^preview-`), BranchGitRemote: "repo.git"}, true},
+		{"URL without placeholder", Options{BranchPattern: pattern, BranchCheckURL: "https://example.com/refs"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newBranchChecker(tt.opts); (err != nil) != tt.wantErr {
+				t.Errorf("newBranchChecker() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
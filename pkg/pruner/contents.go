@@ -0,0 +1,118 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// runningPodsReason is the blocker reason reported for live pods.
+const runningPodsReason = "running-pods"
+
+// maxBlockersPerReason caps how many objects are listed per blocking
+// resource, to keep logs readable for namespaces with many objects.
+const maxBlockersPerReason = 5
+
+// autoCreatedObjects are objects Kubernetes creates in every namespace. They
+// never block orphan cleanup, even when their resource is configured as
+// blocking.
+var autoCreatedObjects = map[schema.GroupResource]map[string]bool{
+	{Resource: "serviceaccounts"}: {"default": true},
+	{Resource: "configmaps"}:      {"kube-root-ca.crt": true},
+}
+
+// namespaceBlocker is something found in a namespace that prevents it from
+// being deleted as an orphan.
+type namespaceBlocker struct {
+	// Reason is the blocking resource (e.g. "persistentvolumeclaims") or
+	// runningPodsReason. It is used as a metric label.
+	Reason string
+	// Object identifies the blocking object, e.g. "persistentvolumeclaims/data-0".
+	Object string
+}
+
+func (b namespaceBlocker) String() string {
+	return b.Object
+}
+
+// namespaceBlockers inspects a namespace that has no Helm releases and
+// returns what it still contains that should prevent its deletion. An error
+// means the contents could not be verified and the namespace must be kept.
+func (p *Pruner) namespaceBlockers(ctx context.Context, namespace string) ([]namespaceBlocker, error) {
+	var blockers []namespaceBlocker
+
+	if p.opts.OrphanBlockOnRunningPods {
+		pods, err := p.k8s.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods: %w", err)
+		}
+		found := 0
+		for _, pod := range pods.Items {
+			if pod.Status.Phase != corev1.PodRunning && pod.Status.Phase != corev1.PodPending {
+				continue
+			}
+			if found++; found > maxBlockersPerReason {
+				break
+			}
+			blockers = append(blockers, namespaceBlocker{
+				Reason: runningPodsReason,
+				Object: fmt.Sprintf("pods/%s (%s)", pod.Name, pod.Status.Phase),
+			})
+		}
+	}
+
+	for _, resource := range p.opts.OrphanBlockingResources {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		gvr, err := p.resolveResource(resource)
+		if meta.IsNoMatchError(err) {
+			p.logger.Debug("blocking resource not served by cluster, ignoring",
+				"resource", resource)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve resource %q: %w", resource, err)
+		}
+
+		list, err := p.dynamic.Resource(gvr).Namespace(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", gvr.GroupResource(), err)
+		}
+
+		ignored := autoCreatedObjects[gvr.GroupResource()]
+		found := 0
+		for _, item := range list.Items {
+			if ignored[item.GetName()] {
+				continue
+			}
+			if found++; found > maxBlockersPerReason {
+				break
+			}
+			blockers = append(blockers, namespaceBlocker{
+				Reason: resource,
+				Object: fmt.Sprintf("%s/%s", gvr.GroupResource(), item.GetName()),
+			})
+		}
+	}
+
+	return blockers, nil
+}
+
+// resolveResource maps a resource argument such as "persistentvolumeclaims",
+// "statefulsets.apps" or "widgets.v1.example.com" to the resource the
+// cluster serves.
+func (p *Pruner) resolveResource(resource string) (schema.GroupVersionResource, error) {
+	fullySpecified, groupResource := schema.ParseResourceArg(resource)
+	if fullySpecified != nil {
+		if gvr, err := p.mapper.ResourceFor(*fullySpecified); err == nil {
+			return gvr, nil
+		}
+	}
+	return p.mapper.ResourceFor(groupResource.WithVersion(""))
+}
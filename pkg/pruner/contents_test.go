@@ -0,0 +1,147 @@
+package pruner
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var (
+	pvcGVR         = schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumeclaims"}
+	saGVR          = schema.GroupVersionResource{Version: "v1", Resource: "serviceaccounts"}
+	statefulSetGVR = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"}
+	widgetGVR      = schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}
+)
+
+// newTestMapper returns a REST mapper that knows the resources used in tests.
+func newTestMapper() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	for _, gvk := range []schema.GroupVersionKind{
+		{Version: "v1", Kind: "PersistentVolumeClaim"},
+		{Version: "v1", Kind: "ServiceAccount"},
+		{Version: "v1", Kind: "ConfigMap"},
+		{Version: "v1", Kind: "Service"},
+		{Group: "apps", Version: "v1", Kind: "StatefulSet"},
+		{Group: "apps", Version: "v1", Kind: "Deployment"},
+		{Group: "example.com", Version: "v1", Kind: "Widget"},
+	} {
+		mapper.Add(gvk, meta.RESTScopeNamespace)
+	}
+	return mapper
+}
+
+// newObject creates an unstructured namespaced object for the dynamic fake client.
+func newObject(gvr schema.GroupVersionResource, kind, namespace, name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(gvr.GroupVersion().String())
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	return obj
+}
+
+// newClusterPruner creates a test Pruner backed by fake Kubernetes clients.
+func newClusterPruner(opts Options, typed []runtime.Object, objects ...runtime.Object) *Pruner {
+	p := newTestPruner(opts)
+	p.k8s = fake.NewClientset(typed...)
+	p.dynamic = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			pvcGVR:                                  "PersistentVolumeClaimList",
+			saGVR:                                   "ServiceAccountList",
+			statefulSetGVR:                          "StatefulSetList",
+			widgetGVR:                               "WidgetList",
+			{Version: "v1", Resource: "configmaps"}: "ConfigMapList",
+			{Version: "v1", Resource: "services"}:   "ServiceList",
+			{Group: "apps", Version: "v1", Resource: "deployments"}: "DeploymentList",
+		}, objects...)
+	p.mapper = newTestMapper()
+	return p
+}
+
+func pod(namespace, name string, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Status:     corev1.PodStatus{Phase: phase},
+	}
+}
+
+func TestNamespaceBlockers(t *testing.T) {
+	typed := []runtime.Object{
+		pod("running", "web-0", corev1.PodRunning),
+		pod("finished", "job-1", corev1.PodSucceeded),
+		pod("finished", "job-2", corev1.PodFailed),
+	}
+	objects := []runtime.Object{
+		newObject(pvcGVR, "PersistentVolumeClaim", "with-pvc", "data-0"),
+		newObject(saGVR, "ServiceAccount", "default-sa-only", "default"),
+		newObject(saGVR, "ServiceAccount", "custom-sa", "default"),
+		newObject(saGVR, "ServiceAccount", "custom-sa", "ci-bot"),
+		newObject(statefulSetGVR, "StatefulSet", "with-sts", "db"),
+		newObject(widgetGVR, "Widget", "with-cr", "gizmo"),
+	}
+
+	opts := Options{
+		OrphanBlockOnRunningPods: true,
+		OrphanBlockingResources: []string{
+			"persistentvolumeclaims",
+			"serviceaccounts",
+			"statefulsets.apps",
+			"widgets.v1.example.com",
+			"gadgets.example.com", // not served by the cluster
+		},
+	}
+
+	tests := []struct {
+		namespace string
+		expected  []string
+	}{
+		{namespace: "empty", expected: nil},
+		{namespace: "running", expected: []string{"pods/web-0 (Running)"}},
+		{namespace: "finished", expected: nil},
+		{namespace: "with-pvc", expected: []string{"persistentvolumeclaims/data-0"}},
+		{namespace: "default-sa-only", expected: nil},
+		{namespace: "custom-sa", expected: []string{"serviceaccounts/ci-bot"}},
+		{namespace: "with-sts", expected: []string{"statefulsets.apps/db"}},
+		{namespace: "with-cr", expected: []string{"widgets.example.com/gizmo"}},
+	}
+
+	p := newClusterPruner(opts, typed, objects...)
+	for _, tt := range tests {
+		t.Run(tt.namespace, func(t *testing.T) {
+			blockers, err := p.namespaceBlockers(context.Background(), tt.namespace)
+			if err != nil {
+				t.Fatalf("namespaceBlockers: %v", err)
+			}
+			var got []string
+			for _, b := range blockers {
+				got = append(got, b.Object)
+			}
+			if !slices.Equal(got, tt.expected) {
+				t.Errorf("namespaceBlockers(%q) = %v, want %v", tt.namespace, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNamespaceBlockers_Disabled(t *testing.T) {
+	typed := []runtime.Object{pod("busy", "web-0", corev1.PodRunning)}
+	objects := []runtime.Object{newObject(pvcGVR, "PersistentVolumeClaim", "busy", "data-0")}
+
+	p := newClusterPruner(Options{}, typed, objects...)
+	blockers, err := p.namespaceBlockers(context.Background(), "busy")
+	if err != nil {
+		t.Fatalf("namespaceBlockers: %v", err)
+	}
+	if len(blockers) != 0 {
+		t.Errorf("expected no blockers when checks are disabled, got %v", blockers)
+	}
+}
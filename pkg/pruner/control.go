@@ -0,0 +1,215 @@
+package pruner
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"helm.sh/helm/v4/pkg/release/common"
+)
+
+var (
+	// ErrPaused is returned by Trigger while pruning is paused, and stops
+	// the deletions of a running cycle.
+	ErrPaused = errors.New("pruning is paused")
+
+	// ErrCycleRunning is returned by Plan while a prune cycle is running.
+	ErrCycleRunning = errors.New("a prune cycle is running")
+)
+
+// Status describes the state of a pruner's daemon.
+type Status struct {
+	Cluster             string        `json:"cluster,omitempty"`
+	Paused              bool          `json:"paused"`
+	Ready               bool          `json:"ready"`
+	ConsecutiveFailures int           `json:"consecutiveFailures"`
+	LastCycle           *CycleSummary `json:"lastCycle,omitempty"`
+	NextRun             *time.Time    `json:"nextRun,omitempty"`
+}
+
+// CycleSummary describes a completed prune cycle.
+type CycleSummary struct {
+	StartedAt         time.Time `json:"startedAt"`
+	DurationSeconds   float64   `json:"durationSeconds"`
+	Error             string    `json:"error,omitempty"`
+	Scanned           int       `json:"scanned"`
+	ReleasesDeleted   int       `json:"releasesDeleted"`
+	NamespacesDeleted int       `json:"namespacesDeleted"`
+	Failed            int       `json:"failed"`
+}
+
+// Plan lists what a prune cycle would delete now.
+type Plan struct {
+	Cluster          string           `json:"cluster,omitempty"`
+	GeneratedAt      time.Time        `json:"generatedAt"`
+	Releases         []PlannedRelease `json:"releases"`
+	OrphanNamespaces []string         `json:"orphanNamespaces"`
+}
+
+// PlannedRelease is a release a prune cycle would delete.
+type PlannedRelease struct {
+	Name         string        `json:"name"`
+	Namespace    string        `json:"namespace"`
+	Status       common.Status `json:"status"`
+	LastDeployed time.Time     `json:"lastDeployed"`
+	LastActive   time.Time     `json:"lastActive"`
+}
+
+// Pause stops scheduled prune cycles until Resume is called. A cycle that
+// is already running stops before its next deletion.
+func (p *Pruner) Pause() {
+	if !p.paused.Swap(true) {
+		p.logger.Info("pruning paused")
+	}
+}
+
+// interrupted returns why a running cycle must stop before its next
+// deletion: ctx is done or pruning was paused. It returns nil otherwise.
+func (p *Pruner) interrupted(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if p.Paused() {
+		return ErrPaused
+	}
+	return nil
+}
+
+// Resume restarts scheduled prune cycles after Pause.
+func (p *Pruner) Resume() {
+	if p.paused.Swap(false) {
+		p.logger.Info("pruning resumed")
+	}
+}
+
+// Paused reports whether scheduled prune cycles are paused.
+func (p *Pruner) Paused() bool {
+	return p.paused.Load()
+}
+
+// Trigger asks the daemon to run a prune cycle now, without waiting for the
+// next interval. Triggers made while a cycle is pending are merged. It
+// returns ErrPaused while pruning is paused.
+func (p *Pruner) Trigger() error {
+	if p.Paused() {
+		return ErrPaused
+	}
+	select {
+	case p.trigger <- struct{}{}:
+	default: // A triggered cycle is already pending
+	}
+	return nil
+}
+
+// Status returns the current daemon status.
+func (p *Pruner) Status() Status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status := Status{
+		Cluster:             p.opts.ClusterName,
+		Paused:              p.Paused(),
+		Ready:               p.Ready(),
+		ConsecutiveFailures: p.consecutiveFailures,
+	}
+	if p.lastCycle != nil {
+		last := *p.lastCycle
+		status.LastCycle = &last
+	}
+	if !p.nextRun.IsZero() {
+		next := p.nextRun
+		status.NextRun = &next
+	}
+	return status
+}
+
+// Plan computes what a prune cycle would delete now, without deleting
+// anything. It returns ErrCycleRunning rather than waiting for a running
+// cycle, which can take minutes.
+//
+// Planning loads the pruner state like a cycle does, but does not change it:
+// failure records, activity observations and orphan idle marks are left for
+// the next cycle, and the blocked namespaces metric is not updated.
+func (p *Pruner) Plan(ctx context.Context) (*Plan, error) {
+	if !p.cycleMu.TryLock() {
+		return nil, ErrCycleRunning
+	}
+	defer p.cycleMu.Unlock()
+
+	if err := p.loadState(ctx); err != nil {
+		return nil, err
+	}
+	p.previewing = true
+	defer func() { p.previewing = false }()
+
+	plan := &Plan{
+		Cluster:          p.opts.ClusterName,
+		GeneratedAt:      time.Now(),
+		Releases:         []PlannedRelease{},
+		OrphanNamespaces: []string{},
+	}
+
+	if p.hasReleasePruningFilters() {
+		releases, err := p.planReleases(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, rel := range releases.releases {
+			plan.Releases = append(plan.Releases, PlannedRelease{
+				Name:         rel.Name,
+				Namespace:    rel.Namespace,
+				Status:       rel.Info.Status,
+				LastDeployed: rel.Info.LastDeployed,
+				LastActive:   p.lastActive(rel),
+			})
+		}
+		slices.SortFunc(plan.Releases, func(a, b PlannedRelease) int {
+			return cmp.Or(cmp.Compare(a.Namespace, b.Namespace), cmp.Compare(a.Name, b.Name))
+		})
+	}
+
+	if p.opts.CleanupOrphanNamespaces {
+		namespaces, err := p.planOrphanNamespaces(ctx)
+		if err != nil {
+			return nil, err
+		}
+		plan.OrphanNamespaces = append(plan.OrphanNamespaces, namespaces...)
+	}
+
+	return plan, nil
+}
+
+// recordCycle stores the outcome of a cycle for Status, counts consecutive
+// failed cycles and marks the pruner ready after a successful one.
+func (p *Pruner) recordCycle(start time.Time, duration time.Duration, result *CycleResult, err error) {
+	summary := &CycleSummary{
+		StartedAt:         start,
+		DurationSeconds:   duration.Seconds(),
+		Scanned:           result.Scanned,
+		ReleasesDeleted:   len(result.Deleted),
+		NamespacesDeleted: len(result.NamespacesDeleted),
+		Failed:            len(result.Failed),
+	}
+	if err != nil {
+		summary.Error = err.Error()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastCycle = summary
+	if err != nil {
+		p.consecutiveFailures++
+		return
+	}
+	p.consecutiveFailures = 0
+	p.ready.Store(true)
+}
+
+// setNextRun records when the next scheduled cycle is due.
+func (p *Pruner) setNextRun(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nextRun = t
+}
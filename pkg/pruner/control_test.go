@@ -0,0 +1,161 @@
+package pruner
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPauseResumeTrigger(t *testing.T) {
+	p := newTestPruner(Options{})
+	p.trigger = make(chan struct{}, 1)
+
+	if err := p.Trigger(); err != nil {
+		t.Fatalf("Trigger: %v", err)
+	}
+	if err := p.Trigger(); err != nil {
+		t.Fatalf("second Trigger: %v", err)
+	}
+	if len(p.trigger) != 1 {
+		t.Errorf("expected pending triggers to be merged, got %d", len(p.trigger))
+	}
+	<-p.trigger
+
+	p.Pause()
+	if !p.Paused() || !p.Status().Paused {
+		t.Error("expected pruner to be paused")
+	}
+	if err := p.Trigger(); !errors.Is(err, ErrPaused) {
+		t.Errorf("Trigger while paused = %v, want ErrPaused", err)
+	}
+
+	p.Resume()
+	if p.Paused() {
+		t.Error("expected pruner to be resumed")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	p := newTestPruner(Options{ClusterName: "staging"})
+
+	status := p.Status()
+	if status.LastCycle != nil || status.NextRun != nil {
+		t.Errorf("expected no cycle information before the first cycle, got %+v", status)
+	}
+
+	start := time.Now()
+	result := &CycleResult{Scanned: 3, Failed: []FailedDeletion{{Release: "web", Namespace: "feature-a", Error: "boom"}}}
+	p.recordCycle(start, 2*time.Second, result, errors.New("boom"))
+	p.setNextRun(start.Add(time.Hour))
+	p.consecutiveFailures = 1
+
+	status = p.Status()
+	if status.Cluster != "staging" || status.ConsecutiveFailures != 1 {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if status.LastCycle == nil || status.LastCycle.Error != "boom" || status.LastCycle.DurationSeconds != 2 ||
+		status.LastCycle.Scanned != 3 || status.LastCycle.Failed != 1 {
+		t.Errorf("unexpected last cycle %+v", status.LastCycle)
+	}
+	if status.NextRun == nil || !status.NextRun.Equal(start.Add(time.Hour)) {
+		t.Errorf("NextRun = %v, want %v", status.NextRun, start.Add(time.Hour))
+	}
+}
+
+func TestPlan_DoesNotDelete(t *testing.T) {
+	old := time.Now().Add(-24 * time.Hour)
+	p := newOrphanPruner(t, Options{CleanupOrphanNamespaces: true},
+		namespace("feature-a", old),
+		namespace("feature-b", old),
+		namespace("production", old),
+	)
+
+	plan, err := p.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+
+	if expected := []string{"feature-a", "feature-b"}; !slices.Equal(plan.OrphanNamespaces, expected) {
+		t.Errorf("planned orphan namespaces = %v, want %v", plan.OrphanNamespaces, expected)
+	}
+	if plan.Releases == nil || len(plan.Releases) != 0 {
+		t.Errorf("expected an empty, non-nil release list, got %v", plan.Releases)
+	}
+	if got := remainingNamespaces(t, p); len(got) != 3 {
+		t.Errorf("expected Plan to delete nothing, remaining %v", got)
+	}
+}
+
+func TestPlan_LeavesStateAlone(t *testing.T) {
+	ctx := context.Background()
+	old := time.Now().Add(-24 * time.Hour)
+	p := newOrphanPruner(t, Options{CleanupOrphanNamespaces: true, OrphanIdleTime: time.Hour},
+		namespace("feature-a", old),
+		namespace("feature-b", old),
+	)
+	path := filepath.Join(t.TempDir(), "state.json")
+	seed := &State{OrphanSince: map[string]time.Time{
+		"feature-a":    old,
+		"feature-gone": old,
+	}}
+	if err := (&FileStateStore{Path: path}).Save(ctx, seed); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	p.state = &FileStateStore{Path: path}
+
+	plan, err := p.Plan(ctx)
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+
+	// The loaded idle mark of feature-a makes it due; feature-b is seen
+	// for the first time, but Plan does not start its idle window.
+	if expected := []string{"feature-a"}; !slices.Equal(plan.OrphanNamespaces, expected) {
+		t.Errorf("planned orphan namespaces = %v, want %v", plan.OrphanNamespaces, expected)
+	}
+	if got := slices.Sorted(maps.Keys(p.orphanSince)); !slices.Equal(got, []string{"feature-a", "feature-gone"}) {
+		t.Errorf("orphan idle marks after Plan = %v, want the loaded ones", got)
+	}
+
+	rel := mockRelease("web", "feature-a", old)
+	rel.Version = 2
+	p.releaseFailures = map[string]*ReleaseFailure{
+		releaseKey("feature-a", "web"): {Revision: 1, Failures: 3, Quarantined: true},
+	}
+	p.previewing = true
+	if reason := p.retryBlocked(rel, time.Now()); reason != "" {
+		t.Errorf("retryBlocked for an upgraded release = %q, want none", reason)
+	}
+	if len(p.releaseFailures) != 1 {
+		t.Error("expected a preview to keep the failure record of an upgraded release")
+	}
+}
+
+func TestPause_StopsRunningCycle(t *testing.T) {
+	old := time.Now().Add(-24 * time.Hour)
+	p := newOrphanPruner(t, Options{CleanupOrphanNamespaces: true},
+		namespace("feature-a", old),
+		namespace("feature-b", old),
+	)
+
+	// RunOnce does not check for a pause before it starts, so the cycle
+	// plans its deletions and stops before the first one.
+	p.Pause()
+	result, err := p.RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("RunOnce while paused: %v", err)
+	}
+	if len(result.NamespacesDeleted) != 0 {
+		t.Errorf("expected a paused cycle to delete nothing, deleted %v", result.NamespacesDeleted)
+	}
+	if got := remainingNamespaces(t, p); len(got) != 2 {
+		t.Errorf("remaining namespaces = %v, want both", got)
+	}
+	if status := p.Status(); status.LastCycle == nil || status.LastCycle.Error != "" || status.ConsecutiveFailures != 0 {
+		t.Errorf("expected a paused cycle not to count as failed, got %+v", status)
+	}
+}
@@ -0,0 +1,196 @@
+package pruner
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"helm.sh/helm/v4/pkg/action"
+	"helm.sh/helm/v4/pkg/kube"
+	"helm.sh/helm/v4/pkg/release"
+	"helm.sh/helm/v4/pkg/storage"
+	"helm.sh/helm/v4/pkg/storage/driver"
+)
+
+// Helm storage drivers accepted in Options.HelmDriver.
+const (
+	DriverSecret    = "secret"
+	DriverConfigMap = "configmap"
+	DriverMemory    = "memory"
+	DriverSQL       = "sql"
+)
+
+// helmStorage is the Helm storage driver resolved once in New. Secret and
+// configmap drivers are built by Helm for each namespace; memory and SQL
+// drivers are shared so that every action sees the same release records.
+type helmStorage struct {
+	driver              string
+	sqlConnectionString string
+
+	mu     sync.Mutex
+	memory *driver.Memory
+	sql    map[string]driver.Driver
+}
+
+// openSQL connects a Helm SQL driver for namespace. Tests replace it, as
+// Helm's SQL driver only connects to PostgreSQL.
+var openSQL = func(connectionString, namespace string) (driver.Driver, error) {
+	return driver.NewSQL(connectionString, namespace)
+}
+
+// newHelmStorage validates the driver settings. For the SQL driver it also
+// connects to the database so that a bad connection string fails at startup
+// rather than on the first prune cycle.
+func newHelmStorage(name, sqlConnectionString string) (*helmStorage, error) {
+	s := &helmStorage{
+		driver:              normalizeDriver(name),
+		sqlConnectionString: sqlConnectionString,
+	}
+
+	switch s.driver {
+	case DriverSecret, DriverConfigMap:
+	case DriverMemory:
+		s.memory = driver.NewMemory()
+	case DriverSQL:
+		if sqlConnectionString == "" {
+			return nil, fmt.Errorf("helm driver %q requires a SQL connection string", DriverSQL)
+		}
+		s.sql = make(map[string]driver.Driver)
+		if _, err := s.sqlDriver(""); err != nil {
+			return nil, fmt.Errorf("unable to connect to SQL storage: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown helm driver %q (valid: %s, %s, %s, %s)",
+			name, DriverSecret, DriverConfigMap, DriverMemory, DriverSQL)
+	}
+
+	return s, nil
+}
+
+// normalizeDriver maps the aliases Helm accepts onto the canonical driver
+// names. Empty means the secret driver, matching Helm's default.
+func normalizeDriver(name string) string {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "secret", "secrets":
+		return DriverSecret
+	case "configmap", "configmaps":
+		return DriverConfigMap
+	default:
+		return strings.ToLower(strings.TrimSpace(name))
+	}
+}
+
+// releases returns the storage for namespace ("" for all namespaces) when
+// the driver is shared across actions, or nil when Helm should build it.
+func (s *helmStorage) releases(namespace string) (*storage.Storage, error) {
+	switch s.driver {
+	case DriverMemory:
+		return storage.Init(&memoryNamespace{storage: s, namespace: namespace}), nil
+	case DriverSQL:
+		d, err := s.sqlDriver(namespace)
+		if err != nil {
+			return nil, err
+		}
+		return storage.Init(d), nil
+	default:
+		return nil, nil
+	}
+}
+
+// sqlDriver returns the cached SQL driver for namespace, connecting on first use.
+func (s *helmStorage) sqlDriver(namespace string) (driver.Driver, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if d, ok := s.sql[namespace]; ok {
+		return d, nil
+	}
+	d, err := openSQL(s.sqlConnectionString, namespace)
+	if err != nil {
+		return nil, err
+	}
+	s.sql[namespace] = d
+	return d, nil
+}
+
+// memoryNamespace is the shared memory driver as seen from one namespace.
+// driver.Memory scopes its operations to the namespace last set on it, so
+// every operation sets the namespace and runs under the storage lock.
+type memoryNamespace struct {
+	storage   *helmStorage
+	namespace string
+}
+
+// use locks the shared memory driver for namespace and returns the unlock
+// function.
+func (m *memoryNamespace) use() func() {
+	m.storage.mu.Lock()
+	m.storage.memory.SetNamespace(m.namespace)
+	return m.storage.mu.Unlock
+}
+
+func (m *memoryNamespace) Name() string {
+	return driver.MemoryDriverName
+}
+
+func (m *memoryNamespace) Create(key string, rls release.Releaser) error {
+	defer m.use()()
+	return m.storage.memory.Create(key, rls)
+}
+
+func (m *memoryNamespace) Update(key string, rls release.Releaser) error {
+	defer m.use()()
+	return m.storage.memory.Update(key, rls)
+}
+
+func (m *memoryNamespace) Delete(key string) (release.Releaser, error) {
+	defer m.use()()
+	return m.storage.memory.Delete(key)
+}
+
+func (m *memoryNamespace) Get(key string) (release.Releaser, error) {
+	defer m.use()()
+	return m.storage.memory.Get(key)
+}
+
+func (m *memoryNamespace) List(filter func(release.Releaser) bool) ([]release.Releaser, error) {
+	defer m.use()()
+	return m.storage.memory.List(filter)
+}
+
+func (m *memoryNamespace) Query(labels map[string]string) ([]release.Releaser, error) {
+	defer m.use()()
+	return m.storage.memory.Query(labels)
+}
+
+// newActionConfig builds a Helm action configuration for namespace using the
+// pruner's storage driver. An empty namespace covers all namespaces.
+func (p *Pruner) newActionConfig(namespace string) (*action.Configuration, error) {
+	actionConfig := new(action.Configuration)
+
+	// Helm only knows how to build the Kubernetes-backed drivers itself; the
+	// shared drivers are swapped in afterwards.
+	initDriver := p.storage.driver
+	if initDriver != DriverConfigMap {
+		initDriver = DriverSecret
+	}
+	if err := actionConfig.Init(p.restGetter, namespace, initDriver); err != nil {
+		return nil, err
+	}
+
+	// Resources in release manifests without an explicit namespace belong to
+	// the release namespace, not the kubeconfig's default namespace.
+	if kc, ok := actionConfig.KubeClient.(*kube.Client); ok && namespace != "" {
+		kc.Namespace = namespace
+	}
+
+	store, err := p.storage.releases(namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s storage: %w", p.storage.driver, err)
+	}
+	if store != nil {
+		actionConfig.Releases = store
+	}
+
+	return actionConfig, nil
+}
@@ -0,0 +1,300 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"helm.sh/helm/v4/pkg/release/common"
+	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+	"helm.sh/helm/v4/pkg/storage/driver"
+	"k8s.io/client-go/rest"
+)
+
+func TestNewHelmStorage(t *testing.T) {
+	tests := []struct {
+		name     string
+		driver   string
+		conn     string
+		expected string
+		wantErr  bool
+	}{
+		{name: "empty defaults to secret", driver: "", expected: DriverSecret},
+		{name: "secret", driver: "secret", expected: DriverSecret},
+		{name: "secrets alias", driver: "secrets", expected: DriverSecret},
+		{name: "configmap", driver: "configmap", expected: DriverConfigMap},
+		{name: "configmaps alias", driver: "ConfigMaps", expected: DriverConfigMap},
+		{name: "memory", driver: "memory", expected: DriverMemory},
+		{name: "sql without connection string", driver: "sql", wantErr: true},
+		{name: "sql with unreachable database", driver: "sql", conn: "postgres://127.0.0.1:1/helm?sslmode=disable&connect_timeout=1", wantErr: true},
+		{name: "unknown driver", driver: "etcd", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newHelmStorage(tt.driver, tt.conn)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("newHelmStorage(%q) expected error, got nil", tt.driver)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newHelmStorage(%q) unexpected error: %v", tt.driver, err)
+			}
+			if s.driver != tt.expected {
+				t.Errorf("newHelmStorage(%q).driver = %q, want %q", tt.driver, s.driver, tt.expected)
+			}
+		})
+	}
+}
+
+// newStoragePruner creates a test Pruner backed by the given Helm storage.
+func newStoragePruner(t *testing.T, opts Options, s *helmStorage) *Pruner {
+	t.Helper()
+	p := newTestPruner(opts)
+	p.restGetter = &restClientGetter{config: &rest.Config{Host: "https://127.0.0.1:1"}}
+	p.storage = s
+	return p
+}
+
+// storeRevision writes a revision of name into the pruner's Helm storage.
+func storeRevision(t *testing.T, p *Pruner, name, namespace string, version int, status common.Status) {
+	t.Helper()
+	rel := mockRelease(name, namespace, time.Now().Add(-time.Duration(100-version)*time.Minute))
+	rel.Version = version
+	rel.Info.Status = status
+
+	cfg, err := p.newActionConfig(namespace)
+	if err != nil {
+		t.Fatalf("newActionConfig(%q): %v", namespace, err)
+	}
+	if err := cfg.Releases.Create(rel); err != nil {
+		t.Fatalf("failed to store %s/%s v%d: %v", namespace, name, version, err)
+	}
+}
+
+func TestHelmStorage_MemorySharedAcrossActions(t *testing.T) {
+	s, err := newHelmStorage(DriverMemory, "")
+	if err != nil {
+		t.Fatalf("newHelmStorage: %v", err)
+	}
+	p := newStoragePruner(t, Options{}, s)
+
+	storeRevision(t, p, "web", "feature-a", 1, common.StatusDeployed)
+	storeRevision(t, p, "web", "feature-b", 1, common.StatusDeployed)
+	storeRevision(t, p, "api", "feature-b", 1, common.StatusDeployed)
+
+	cfg, err := p.newActionConfig("")
+	if err != nil {
+		t.Fatalf("newActionConfig: %v", err)
+	}
+	all, err := cfg.Releases.ListReleases()
+	if err != nil {
+		t.Fatalf("ListReleases: %v", err)
+	}
+	if len(all) != 3 {
+		t.Errorf("expected 3 releases across namespaces, got %d", len(all))
+	}
+
+	cfg, err = p.newActionConfig("feature-b")
+	if err != nil {
+		t.Fatalf("newActionConfig: %v", err)
+	}
+	scoped, err := cfg.Releases.ListReleases()
+	if err != nil {
+		t.Fatalf("ListReleases: %v", err)
+	}
+	if len(scoped) != 2 {
+		t.Errorf("expected 2 releases in feature-b, got %d", len(scoped))
+	}
+}
+
+func TestHelmStorage_MemoryConcurrentNamespaces(t *testing.T) {
+	s, err := newHelmStorage(DriverMemory, "")
+	if err != nil {
+		t.Fatalf("newHelmStorage: %v", err)
+	}
+	p := newStoragePruner(t, Options{}, s)
+	storeRevision(t, p, "web", "feature-a", 1, common.StatusDeployed)
+	storeRevision(t, p, "web", "feature-b", 1, common.StatusDeployed)
+	storeRevision(t, p, "api", "feature-b", 1, common.StatusDeployed)
+
+	// Each namespace's storage must keep seeing only its own releases while
+	// the other one is in use.
+	var wg sync.WaitGroup
+	for ns, want := range map[string]int{"feature-a": 1, "feature-b": 2} {
+		store, err := s.releases(ns)
+		if err != nil {
+			t.Fatalf("releases(%q): %v", ns, err)
+		}
+		wg.Go(func() {
+			for range 200 {
+				list, err := store.ListReleases()
+				if err != nil || len(list) != want {
+					t.Errorf("ListReleases in %s = %d releases (%v), want %d", ns, len(list), err, want)
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func TestTrimHistoryForRelease_MemoryDriver(t *testing.T) {
+	s, err := newHelmStorage(DriverMemory, "")
+	if err != nil {
+		t.Fatalf("newHelmStorage: %v", err)
+	}
+	p := newStoragePruner(t, Options{HistoryMax: 2}, s)
+
+	for v := 1; v <= 4; v++ {
+		storeRevision(t, p, "web", "feature-a", v, common.StatusSuperseded)
+	}
+	storeRevision(t, p, "web", "feature-a", 5, common.StatusDeployed)
+
+	n, err := p.trimHistoryForRelease(context.Background(), "web", "feature-a")
+	if err != nil {
+		t.Fatalf("trimHistoryForRelease: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 revisions trimmed, got %d", n)
+	}
+
+	cfg, err := p.newActionConfig("feature-a")
+	if err != nil {
+		t.Fatalf("newActionConfig: %v", err)
+	}
+	history, err := cfg.Releases.History("web")
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	var versions []int
+	for _, r := range history {
+		versions = append(versions, r.(*releasev1.Release).Version)
+	}
+	if len(versions) != 2 {
+		t.Errorf("expected 2 remaining revisions, got %v", versions)
+	}
+}
+
+// TestHelmStorage_SQL runs against a real PostgreSQL database when
+// HELM_PRUNER_TEST_SQL_CONNECTION_STRING is set, as it is in CI, or locally
+// with a container started with:
+// docker run -e POSTGRES_PASSWORD=helm -p 5432:5432 postgres
+func TestHelmStorage_SQL(t *testing.T) {
+	conn := os.Getenv("HELM_PRUNER_TEST_SQL_CONNECTION_STRING")
+	if conn == "" {
+		t.Skip("HELM_PRUNER_TEST_SQL_CONNECTION_STRING not set")
+	}
+
+	s, err := newHelmStorage(DriverSQL, conn)
+	if err != nil {
+		t.Fatalf("newHelmStorage: %v", err)
+	}
+	p := newStoragePruner(t, Options{HistoryMax: 1}, s)
+
+	name := "pruner-sql-test-" + time.Now().Format("150405")
+	storeRevision(t, p, name, "pruner-test", 1, common.StatusSuperseded)
+	storeRevision(t, p, name, "pruner-test", 2, common.StatusDeployed)
+
+	n, err := p.trimHistoryForRelease(context.Background(), name, "pruner-test")
+	if err != nil {
+		t.Fatalf("trimHistoryForRelease: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 revision trimmed, got %d", n)
+	}
+
+	// Each namespace has its own driver; the one for all namespaces sees
+	// both releases.
+	storeRevision(t, p, name, "pruner-test-b", 1, common.StatusDeployed)
+	cfg, err := p.newActionConfig("")
+	if err != nil {
+		t.Fatalf("newActionConfig: %v", err)
+	}
+	all, err := cfg.Releases.ListReleases()
+	if err != nil {
+		t.Fatalf("ListReleases: %v", err)
+	}
+	var namespaces []string
+	for _, r := range all {
+		if rel := r.(*releasev1.Release); rel.Name == name {
+			namespaces = append(namespaces, rel.Namespace)
+		}
+	}
+	slices.Sort(namespaces)
+	if !slices.Equal(namespaces, []string{"pruner-test", "pruner-test-b"}) {
+		t.Errorf("releases named %s found in %v, want pruner-test and pruner-test-b", name, namespaces)
+	}
+
+	for namespace, version := range map[string]int{"pruner-test": 2, "pruner-test-b": 1} {
+		cfg, err := p.newActionConfig(namespace)
+		if err != nil {
+			t.Fatalf("newActionConfig: %v", err)
+		}
+		if _, err := cfg.Releases.Delete(name, version); err != nil {
+			t.Errorf("cleanup of %s/%s v%d failed: %v", namespace, name, version, err)
+		}
+	}
+}
+
+// TestHelmStorage_SQLDriverPerNamespace checks how SQL drivers are opened
+// and cached, without a database: the drivers are namespace views of a
+// shared memory driver. TestHelmStorage_SQL covers Helm's SQL driver.
+func TestHelmStorage_SQLDriverPerNamespace(t *testing.T) {
+	shared, err := newHelmStorage(DriverMemory, "")
+	if err != nil {
+		t.Fatalf("newHelmStorage: %v", err)
+	}
+	var opened []string
+	orig := openSQL
+	t.Cleanup(func() { openSQL = orig })
+	openSQL = func(connectionString, namespace string) (driver.Driver, error) {
+		if connectionString != "postgres://helm@db/helm" {
+			return nil, fmt.Errorf("unexpected connection string %q", connectionString)
+		}
+		opened = append(opened, namespace)
+		return &memoryNamespace{storage: shared, namespace: namespace}, nil
+	}
+
+	if _, err := newHelmStorage(DriverSQL, "postgres://other@db/helm"); err == nil {
+		t.Error("expected a failed connection to fail at startup")
+	}
+	opened = nil
+
+	s, err := newHelmStorage(DriverSQL, "postgres://helm@db/helm")
+	if err != nil {
+		t.Fatalf("newHelmStorage: %v", err)
+	}
+	p := newStoragePruner(t, Options{HistoryMax: 1}, s)
+	storeRevision(t, p, "web", "feature-a", 1, common.StatusSuperseded)
+	storeRevision(t, p, "web", "feature-a", 2, common.StatusDeployed)
+	storeRevision(t, p, "api", "feature-b", 1, common.StatusDeployed)
+
+	n, err := p.trimHistoryForRelease(context.Background(), "web", "feature-a")
+	if err != nil || n != 1 {
+		t.Fatalf("trimHistoryForRelease = %d, %v, want 1 revision trimmed", n, err)
+	}
+	cfg, err := p.newActionConfig("")
+	if err != nil {
+		t.Fatalf("newActionConfig: %v", err)
+	}
+	all, err := cfg.Releases.ListReleases()
+	if err != nil {
+		t.Fatalf("ListReleases: %v", err)
+	}
+	if len(all) != 2 {
+		t.Errorf("expected 2 revisions left across namespaces, got %d", len(all))
+	}
+
+	// The startup connection covers all namespaces; every namespace then
+	// connects once.
+	if !slices.Equal(opened, []string{"", "feature-a", "feature-b"}) {
+		t.Errorf("connections opened for %q, want one per namespace", opened)
+	}
+}
@@ -0,0 +1,54 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// eventComponent is the source component of events emitted by the pruner.
+const eventComponent = "helm-release-pruner"
+
+// recordNamespaceEvent emits a Kubernetes event about a namespace. Events
+// for cluster-scoped objects live in the default namespace, which also keeps
+// them readable after the namespace itself is gone.
+func (p *Pruner) recordNamespaceEvent(ctx context.Context, namespace, eventType, reason, message string) error {
+	return p.recordEvent(ctx, metav1.NamespaceDefault, namespace, eventType, reason, message)
+}
+
+// recordReleaseEvent emits a Kubernetes event about a release. Releases are
+// not Kubernetes objects, so the event is attached to the release namespace
+// and lives there, next to the release.
+func (p *Pruner) recordReleaseEvent(ctx context.Context, rel *releasev1.Release, eventType, reason, message string) error {
+	return p.recordEvent(ctx, rel.Namespace, rel.Namespace, eventType, reason, message)
+}
+
+// recordEvent creates an event in eventNamespace about the namespace named
+// involved.
+func (p *Pruner) recordEvent(ctx context.Context, eventNamespace, involved, eventType, reason, message string) error {
+	now := metav1.NewTime(time.Now())
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", involved, now.UnixNano()),
+			Namespace: eventNamespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Namespace",
+			Name:       involved,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: eventComponent},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	_, err := p.k8s.CoreV1().Events(eventNamespace).Create(ctx, event, metav1.CreateOptions{})
+	return err
+}
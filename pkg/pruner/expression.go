@@ -0,0 +1,174 @@
+package pruner
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/ext"
+	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+)
+
+// exprRelease is the release variable of selection expressions.
+type exprRelease struct {
+	Name          string            `cel:"name"`
+	Namespace     string            `cel:"namespace"`
+	Revision      int               `cel:"revision"`
+	Status        string            `cel:"status"`
+	Labels        map[string]string `cel:"labels"`
+	Chart         exprChart         `cel:"chart"`
+	FirstDeployed time.Time         `cel:"firstDeployed"`
+	LastDeployed  time.Time         `cel:"lastDeployed"`
+}
+
+// exprChart is the chart metadata of a release in selection expressions.
+type exprChart struct {
+	Name       string `cel:"name"`
+	Version    string `cel:"version"`
+	AppVersion string `cel:"appVersion"`
+}
+
+// exprNamespace is the ns variable of selection expressions.
+type exprNamespace struct {
+	Name        string            `cel:"name"`
+	Labels      map[string]string `cel:"labels"`
+	Annotations map[string]string `cel:"annotations"`
+}
+
+// exprEnv declares the variables of selection expressions.
+var exprEnv = sync.OnceValues(func() (*cel.Env, error) {
+	// Optional types register a type, so they go before the native type
+	// provider replaces the default one.
+	return cel.NewEnv(
+		cel.OptionalTypes(),
+		ext.NativeTypes(
+			reflect.TypeFor[exprRelease](),
+			reflect.TypeFor[exprNamespace](),
+			ext.ParseStructTags(true),
+		),
+		ext.Strings(),
+		cel.Variable("release", cel.ObjectType("pruner.exprRelease")),
+		cel.Variable("ns", cel.ObjectType("pruner.exprNamespace")),
+		cel.Variable("age", cel.DurationType),
+	)
+})
+
+// Expression is a compiled CEL expression over a release, evaluating to a
+// bool. It can use these variables:
+//
+//	release.name, release.namespace, release.revision, release.status
+//	release.labels                      Helm storage labels of the release
+//	release.chart.name, release.chart.version, release.chart.appVersion
+//	release.firstDeployed, release.lastDeployed
+//	ns.name, ns.labels, ns.annotations  the release namespace
+//	age                                 time since the release was last active
+//
+// namespace is a reserved word in CEL, hence ns. Indexing a map with a
+// missing key is an error; test for the key with in, or use optional
+// indexing: ns.labels[?"keep"].orValue("").
+type Expression struct {
+	source  string
+	program cel.Program
+}
+
+// CompileExpression parses and type-checks a selection expression.
+func CompileExpression(source string) (*Expression, error) {
+	env, err := exprEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create expression environment: %w", err)
+	}
+	ast, issues := env.Compile(source)
+	if issues.Err() != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", source, issues.Err())
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("expression %q evaluates to %s, not bool", source, ast.OutputType())
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", source, err)
+	}
+	return &Expression{source: source, program: program}, nil
+}
+
+// String returns the expression source.
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Matches evaluates the expression for a release.
+func (e *Expression) Matches(rc *RuleContext, rel *releasev1.Release) (bool, error) {
+	vars := map[string]any{
+		"release": exprReleaseOf(rel),
+		"ns": exprNamespace{
+			Name:        rel.Namespace,
+			Labels:      rc.Namespace.Labels,
+			Annotations: rc.Namespace.Annotations,
+		},
+		"age": rc.Now.Sub(rc.LastActive),
+	}
+	out, _, err := e.program.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+	matched, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("expression returned %v, not bool", out.Value())
+	}
+	return matched, nil
+}
+
+// FilterRule returns a filter rule that keeps the releases the expression
+// does not match. Releases it fails to evaluate for are kept too.
+func (e *Expression) FilterRule() Rule {
+	return RuleFunc(func(rc *RuleContext, rel *releasev1.Release) Verdict {
+		matched, err := e.Matches(rc, rel)
+		if err != nil {
+			return Verdict{Decision: Keep, Reason: fmt.Sprintf("filter expression %q failed: %v", e.source, err)}
+		}
+		if !matched {
+			return Verdict{Decision: Keep, Reason: fmt.Sprintf("filter expression %q", e.source)}
+		}
+		return Verdict{}
+	})
+}
+
+// DeleteRule returns a rule that deletes the releases the expression
+// matches. Releases it fails to evaluate for are kept.
+func (e *Expression) DeleteRule() Rule {
+	return RuleFunc(func(rc *RuleContext, rel *releasev1.Release) Verdict {
+		matched, err := e.Matches(rc, rel)
+		if err != nil {
+			return Verdict{Decision: Keep, Reason: fmt.Sprintf("delete expression %q failed: %v", e.source, err)}
+		}
+		if matched {
+			return Verdict{Decision: Delete, Reason: fmt.Sprintf("delete expression %q", e.source)}
+		}
+		return Verdict{}
+	})
+}
+
+// exprReleaseOf converts a release to the release variable of expressions.
+func exprReleaseOf(rel *releasev1.Release) exprRelease {
+	r := exprRelease{
+		Name:      rel.Name,
+		Namespace: rel.Namespace,
+		Revision:  rel.Version,
+		Labels:    rel.Labels,
+	}
+	if rel.Info != nil {
+		r.Status = rel.Info.Status.String()
+		r.FirstDeployed = rel.Info.FirstDeployed
+		r.LastDeployed = rel.Info.LastDeployed
+	}
+	if rel.Chart != nil && rel.Chart.Metadata != nil {
+		r.Chart = exprChart{
+			Name:       rel.Chart.Metadata.Name,
+			Version:    rel.Chart.Metadata.Version,
+			AppVersion: rel.Chart.Metadata.AppVersion,
+		}
+	}
+	return r
+}
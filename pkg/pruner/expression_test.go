@@ -0,0 +1,127 @@
+package pruner
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	chart "helm.sh/helm/v4/pkg/chart/v2"
+	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCompileExpression(t *testing.T) {
+	for _, source := range []string{
+		`release.chart.name == "preview" && age > duration("72h") && !(ns.labels[?"keep"].orValue("") == "true")`,
+		`release.status == "failed" && release.revision == 1`,
+		`"team" in ns.labels && release.name.startsWith("feature-")`,
+		`release.lastDeployed < timestamp("2024-01-01T00:00:00Z")`,
+	} {
+		if _, err := CompileExpression(source); err != nil {
+			t.Errorf("CompileExpression(%q): %v", source, err)
+		}
+	}
+
+	for _, source := range []string{
+		`release.name ==`,
+		`release.owner == "team-a"`,
+		`age > 3`,
+		`release.name`,
+	} {
+		if _, err := CompileExpression(source); err == nil {
+			t.Errorf("expected CompileExpression(%q) to fail", source)
+		}
+	}
+}
+
+func TestExpression_Matches(t *testing.T) {
+	now := time.Now()
+	rel := mockRelease("preview-web", "feature-a", now.Add(-96*time.Hour))
+	rel.Chart = &chart.Chart{Metadata: &chart.Metadata{Name: "preview", Version: "1.2.0"}}
+	rel.Labels = map[string]string{"owner": "helm"}
+	rc := &RuleContext{
+		Now:        now,
+		Namespace:  metav1.ObjectMeta{Name: "feature-a", Labels: map[string]string{"team": "web"}},
+		LastActive: rel.Info.LastDeployed,
+	}
+
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		{`release.chart.name == "preview" && age > duration("72h")`, true},
+		{`age > duration("120h")`, false},
+		{`ns.name == "feature-a" && ns.labels["team"] == "web"`, true},
+		{`release.labels["owner"] == "helm" && release.status == "deployed"`, true},
+		{`ns.labels[?"keep"].orValue("") == "true"`, false},
+		{`release.lastDeployed < timestamp("2000-01-01T00:00:00Z")`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			expr, err := CompileExpression(tt.source)
+			if err != nil {
+				t.Fatalf("CompileExpression: %v", err)
+			}
+			got, err := expr.Matches(rc, rel)
+			if err != nil || got != tt.want {
+				t.Errorf("Matches = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpressionRules(t *testing.T) {
+	now := time.Now()
+	releases := []*releasev1.Release{
+		mockRelease("web", "feature-a", now.Add(-96*time.Hour)),
+		mockRelease("web", "feature-keep", now.Add(-96*time.Hour)),
+		mockRelease("web", "feature-b", now.Add(-1*time.Hour)),
+		mockRelease("db", "feature-a", now.Add(-96*time.Hour)),
+	}
+	namespaces := map[string]metav1.ObjectMeta{
+		"feature-a":    {Name: "feature-a"},
+		"feature-keep": {Name: "feature-keep", Labels: map[string]string{"keep": "true"}},
+		"feature-b":    {Name: "feature-b"},
+	}
+
+	filter, err := CompileExpression(`release.name == "web"`)
+	if err != nil {
+		t.Fatalf("CompileExpression: %v", err)
+	}
+	del, err := CompileExpression(`age > duration("72h") && ns.labels[?"keep"].orValue("") != "true"`)
+	if err != nil {
+		t.Fatalf("CompileExpression: %v", err)
+	}
+	p := newTestPruner(Options{Filters: []Rule{filter.FilterRule()}, Rules: []Rule{del.DeleteRule()}})
+
+	var candidates []*releasev1.Release
+	for _, rel := range releases {
+		if reason := p.skipReason(rel, namespaces); reason == "" {
+			candidates = append(candidates, rel)
+		} else if !strings.HasPrefix(reason, "filter expression") {
+			t.Errorf("unexpected skip reason %q", reason)
+		}
+	}
+	if got := releaseNames(candidates); !slices.Equal(got, []string{"feature-a/web", "feature-b/web", "feature-keep/web"}) {
+		t.Fatalf("candidates = %v, want the web releases", got)
+	}
+
+	toDelete, _ := p.evaluateReleases(candidates, namespaces, nil)
+	if got := releaseNames(toDelete); !slices.Equal(got, []string{"feature-a/web"}) {
+		t.Errorf("deleted = %v, want [feature-a/web]", got)
+	}
+
+	// Indexing a missing label is an evaluation error, which keeps the
+	// release.
+	failing, err := CompileExpression(`ns.labels["keep"] != "true"`)
+	if err != nil {
+		t.Fatalf("CompileExpression: %v", err)
+	}
+	rc := &RuleContext{Now: now, Namespace: namespaces["feature-a"], LastActive: releases[0].Info.LastDeployed}
+	for _, rule := range []Rule{failing.FilterRule(), failing.DeleteRule()} {
+		if v := rule.Evaluate(rc, releases[0]); v.Decision != Keep || !strings.Contains(v.Reason, "failed") {
+			t.Errorf("Evaluate = %v (%q), want a keep for the evaluation error", v.Decision, v.Reason)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"helm.sh/helm/v4/pkg/release/common"
+	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+)
+
+// trimReleaseHistory deletes superseded revision records so that at most
+// HistoryMax revisions remain per release. Releases themselves are never
+// uninstalled here, and the deployed revision is always kept.
+func (p *Pruner) trimReleaseHistory(ctx context.Context) (err error) {
+	ctx, span := p.startSpan(ctx, "trim-release-history")
+	defer func() { endSpan(span, err) }()
+
+	p.logger.Info("starting release history trimming", "history_max", p.opts.HistoryMax)
+
+	releases, err := p.listAllReleases(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list releases: %w", err)
+	}
+
+	namespaces, err := p.listNamespaceMeta(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list namespaces: %w", err)
+	}
+
+	trimmed := 0
+	for _, rel := range p.filterReleases(releases, namespaces) {
+		if err := p.interrupted(ctx); err != nil {
+			return err
+		}
+
+		n, err := p.trimHistoryForRelease(ctx, rel.Name, rel.Namespace)
+		if err != nil {
+			p.logger.Error("failed to trim release history",
+				"name", rel.Name,
+				"namespace", rel.Namespace,
+				"error", err)
+			continue
+		}
+		trimmed += n
+	}
+
+	p.logger.Info("release history trimming complete", "revisions", trimmed)
+	return nil
+}
+
+// trimHistoryForRelease trims the revision history of a single release and
+// returns the number of revisions removed (or that would be, in dry-run).
+func (p *Pruner) trimHistoryForRelease(ctx context.Context, name, namespace string) (int, error) {
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+
+	actionConfig, err := p.newActionConfig(namespace)
+	if err != nil {
+		return 0, err
+	}
+
+	history, err := actionConfig.Releases.History(name)
+	if err != nil {
+		return 0, fmt.Errorf("history %s/%s: %w", namespace, name, err)
+	}
+
+	revisions := make([]*releasev1.Release, 0, len(history))
+	for _, r := range history {
+		if rel, ok := r.(*releasev1.Release); ok {
+			revisions = append(revisions, rel)
+		}
+	}
+
+	toTrim := selectRevisionsToTrim(revisions, p.opts.HistoryMax)
+	for _, rev := range toTrim {
+		if p.opts.DryRun {
+			p.logger.Info("would delete release revision",
+				"name", name,
+				"namespace", namespace,
+				"revision", rev.Version,
+				"status", rev.Info.Status)
+			continue
+		}
+
+		p.logger.Debug("deleting release revision",
+			"name", name,
+			"namespace", namespace,
+			"revision", rev.Version,
+			"status", rev.Info.Status)
+		if _, err := actionConfig.Releases.Delete(name, rev.Version); err != nil {
+			return 0, fmt.Errorf("delete revision %d of %s/%s: %w", rev.Version, namespace, name, err)
+		}
+		revisionsDeletedTotal.WithLabelValues(p.opts.ClusterName).Inc()
+	}
+
+	return len(toTrim), nil
+}
+
+// selectRevisionsToTrim returns the revisions beyond the newest keep that are
+// safe to remove. Deployed and pending revisions are never selected.
+func selectRevisionsToTrim(revisions []*releasev1.Release, keep int) []*releasev1.Release {
+	if keep <= 0 || len(revisions) <= keep {
+		return nil
+	}
+
+	sorted := make([]*releasev1.Release, len(revisions))
+	copy(sorted, revisions)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version > sorted[j].Version
+	})
+
+	var toTrim []*releasev1.Release
+	for _, rev := range sorted[keep:] {
+		if rev.Info == nil || rev.Info.Status == common.StatusDeployed || rev.Info.Status.IsPending() {
+			continue
+		}
+		toTrim = append(toTrim, rev)
+	}
+
+	return toTrim
+}
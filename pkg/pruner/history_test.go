@@ -0,0 +1,97 @@
+package pruner
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"helm.sh/helm/v4/pkg/release/common"
+	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+)
+
+// mockRevision creates a revision of the "app" release with the given status.
+func mockRevision(version int, status common.Status) *releasev1.Release {
+	rel := mockRelease("app", "default", time.Now().Add(-time.Duration(10-version)*time.Hour))
+	rel.Version = version
+	rel.Info.Status = status
+	return rel
+}
+
+func revisionNumbers(revs []*releasev1.Release) []int {
+	versions := make([]int, 0, len(revs))
+	for _, r := range revs {
+		versions = append(versions, r.Version)
+	}
+	slices.Sort(versions)
+	return versions
+}
+
+func TestSelectRevisionsToTrim(t *testing.T) {
+	tests := []struct {
+		name      string
+		revisions []*releasev1.Release
+		keep      int
+		expected  []int
+	}{
+		{
+			name: "disabled",
+			revisions: []*releasev1.Release{
+				mockRevision(1, common.StatusSuperseded),
+				mockRevision(2, common.StatusDeployed),
+			},
+			keep:     0,
+			expected: []int{},
+		},
+		{
+			name: "fewer revisions than limit",
+			revisions: []*releasev1.Release{
+				mockRevision(1, common.StatusSuperseded),
+				mockRevision(2, common.StatusDeployed),
+			},
+			keep:     5,
+			expected: []int{},
+		},
+		{
+			name: "keep newest 2",
+			revisions: []*releasev1.Release{
+				mockRevision(3, common.StatusSuperseded),
+				mockRevision(1, common.StatusSuperseded),
+				mockRevision(5, common.StatusDeployed),
+				mockRevision(2, common.StatusSuperseded),
+				mockRevision(4, common.StatusFailed),
+			},
+			keep:     2,
+			expected: []int{1, 2, 3},
+		},
+		{
+			name: "deployed revision older than failed upgrades is kept",
+			revisions: []*releasev1.Release{
+				mockRevision(1, common.StatusSuperseded),
+				mockRevision(2, common.StatusDeployed),
+				mockRevision(3, common.StatusFailed),
+				mockRevision(4, common.StatusFailed),
+			},
+			keep:     1,
+			expected: []int{1, 3},
+		},
+		{
+			name: "pending revisions are never trimmed",
+			revisions: []*releasev1.Release{
+				mockRevision(1, common.StatusPendingUpgrade),
+				mockRevision(2, common.StatusSuperseded),
+				mockRevision(3, common.StatusDeployed),
+			},
+			keep:     1,
+			expected: []int{2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := revisionNumbers(selectRevisionsToTrim(tt.revisions, tt.keep))
+			if !slices.Equal(got, tt.expected) {
+				t.Errorf("selectRevisionsToTrim() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
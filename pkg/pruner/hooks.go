@@ -0,0 +1,297 @@
+package pruner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+)
+
+// Deletion hook phases.
+const (
+	HookPreDelete  = "pre-delete"
+	HookPostDelete = "post-delete"
+)
+
+// Kinds of objects deletion hooks are run for.
+const (
+	HookKindRelease   = "release"
+	HookKindNamespace = "namespace"
+)
+
+// defaultHookTimeout is used when Options.HookTimeout is 0.
+const defaultHookTimeout = time.Minute
+
+// hookWaitDelay bounds how long a timed out hook command's output is
+// awaited after the command is killed.
+const hookWaitDelay = time.Second
+
+// hookOutputLimit bounds how much of a failed hook's output or response is
+// kept in its error.
+const hookOutputLimit = 512
+
+// ErrDeletionVetoed is wrapped by the error returned when a pre-delete hook
+// fails, which keeps the release or namespace.
+var ErrDeletionVetoed = errors.New("deletion vetoed by pre-delete hook")
+
+// HookEvent describes the release or namespace a deletion hook runs for.
+// Release fields are empty for namespaces.
+type HookEvent struct {
+	Phase     string `json:"phase"`
+	Kind      string `json:"kind"`
+	Cluster   string `json:"cluster,omitempty"`
+	Namespace string `json:"namespace"`
+
+	Release      string     `json:"release,omitempty"`
+	Revision     int        `json:"revision,omitempty"`
+	Status       string     `json:"status,omitempty"`
+	Chart        string     `json:"chart,omitempty"`
+	ChartVersion string     `json:"chartVersion,omitempty"`
+	AppVersion   string     `json:"appVersion,omitempty"`
+	LastDeployed *time.Time `json:"lastDeployed,omitempty"`
+	// ForcePurge is set when the release is force purged instead of
+	// uninstalled.
+	ForcePurge bool `json:"forcePurge,omitempty"`
+}
+
+// DeletionHook is a user-defined action run before or after the pruner
+// deletes a release or namespace. A pre-delete hook that returns an error
+// vetoes the deletion.
+type DeletionHook interface {
+	// Name identifies the hook in logs and errors.
+	Name() string
+	// Run runs the hook. ctx carries the hook timeout.
+	Run(ctx context.Context, event *HookEvent) error
+}
+
+// newDeletionHooks builds the pre-delete and post-delete hooks configured in
+// the options: commands first, then webhooks, then custom hooks.
+func newDeletionHooks(opts Options) (pre, post []DeletionHook, err error) {
+	build := func(command, webhook string, custom []DeletionHook) ([]DeletionHook, error) {
+		var hooks []DeletionHook
+		if command != "" {
+			hooks = append(hooks, &CommandHook{Command: command})
+		}
+		if webhook != "" {
+			u, err := url.Parse(webhook)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("invalid webhook URL %q (expected http or https)", webhook)
+			}
+			hooks = append(hooks, &WebhookHook{URL: webhook})
+		}
+		return append(hooks, custom...), nil
+	}
+
+	if opts.HookTimeout < 0 {
+		return nil, nil, fmt.Errorf("hook timeout must not be negative")
+	}
+	if pre, err = build(opts.PreDeleteCommand, opts.PreDeleteWebhook, opts.PreDeleteHooks); err != nil {
+		return nil, nil, err
+	}
+	if post, err = build(opts.PostDeleteCommand, opts.PostDeleteWebhook, opts.PostDeleteHooks); err != nil {
+		return nil, nil, err
+	}
+	return pre, post, nil
+}
+
+// CommandHook runs a shell command with sh -c. The event is written as JSON
+// to its standard input and exported in PRUNER_* environment variables. A
+// non-zero exit status is an error.
+type CommandHook struct {
+	Command string
+}
+
+// Name implements DeletionHook.
+func (h *CommandHook) Name() string { return "command" }
+
+// Run implements DeletionHook.
+func (h *CommandHook) Run(ctx context.Context, event *HookEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", h.Command)
+	cmd.Env = append(os.Environ(), hookEnv(event)...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	// Processes started by the command may outlive it and hold its output
+	// open; stop waiting for them shortly after the command is killed.
+	cmd.WaitDelay = hookWaitDelay
+	if err := cmd.Run(); err != nil {
+		if out := truncate(strings.TrimSpace(output.String()), hookOutputLimit); out != "" {
+			return fmt.Errorf("%w: %s", err, out)
+		}
+		return err
+	}
+	return nil
+}
+
+// hookEnv returns the environment variables describing event.
+func hookEnv(event *HookEvent) []string {
+	env := []string{
+		"PRUNER_HOOK_PHASE=" + event.Phase,
+		"PRUNER_HOOK_KIND=" + event.Kind,
+		"PRUNER_CLUSTER=" + event.Cluster,
+		"PRUNER_NAMESPACE=" + event.Namespace,
+	}
+	if event.Kind == HookKindRelease {
+		env = append(env,
+			"PRUNER_RELEASE="+event.Release,
+			"PRUNER_RELEASE_REVISION="+strconv.Itoa(event.Revision),
+			"PRUNER_RELEASE_STATUS="+event.Status,
+			"PRUNER_CHART="+event.Chart,
+			"PRUNER_CHART_VERSION="+event.ChartVersion,
+			"PRUNER_APP_VERSION="+event.AppVersion,
+			"PRUNER_FORCE_PURGE="+strconv.FormatBool(event.ForcePurge))
+		if event.LastDeployed != nil {
+			env = append(env, "PRUNER_LAST_DEPLOYED="+event.LastDeployed.Format(time.RFC3339))
+		}
+	}
+	return env
+}
+
+// WebhookHook POSTs the event as JSON to an HTTP endpoint. A 2xx response
+// allows the deletion; any other status is an error.
+type WebhookHook struct {
+	URL    string
+	Client *http.Client
+}
+
+// Name implements DeletionHook.
+func (h *WebhookHook) Name() string { return "webhook" }
+
+// Run implements DeletionHook.
+func (h *WebhookHook) Run(ctx context.Context, event *HookEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, hookOutputLimit))
+		if msg := strings.TrimSpace(string(body)); msg != "" {
+			return fmt.Errorf("unexpected status %s from %s: %s", resp.Status, h.URL, msg)
+		}
+		return fmt.Errorf("unexpected status %s from %s", resp.Status, h.URL)
+	}
+	return nil
+}
+
+// truncate shortens s to at most n bytes.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// releaseHookEvent describes a release to deletion hooks.
+func (p *Pruner) releaseHookEvent(rel *releasev1.Release, forcePurge bool) *HookEvent {
+	event := &HookEvent{
+		Kind:       HookKindRelease,
+		Cluster:    p.opts.ClusterName,
+		Namespace:  rel.Namespace,
+		Release:    rel.Name,
+		Revision:   rel.Version,
+		ForcePurge: forcePurge,
+	}
+	if rel.Info != nil {
+		event.Status = rel.Info.Status.String()
+		lastDeployed := rel.Info.LastDeployed
+		event.LastDeployed = &lastDeployed
+	}
+	if rel.Chart != nil && rel.Chart.Metadata != nil {
+		event.Chart = rel.Chart.Metadata.Name
+		event.ChartVersion = rel.Chart.Metadata.Version
+		event.AppVersion = rel.Chart.Metadata.AppVersion
+	}
+	return event
+}
+
+// namespaceHookEvent describes a namespace to deletion hooks.
+func (p *Pruner) namespaceHookEvent(namespace string) *HookEvent {
+	return &HookEvent{Kind: HookKindNamespace, Cluster: p.opts.ClusterName, Namespace: namespace}
+}
+
+// runPreDeleteHooks runs the pre-delete hooks in order. The first hook that
+// fails vetoes the deletion: the error it returns wraps ErrDeletionVetoed.
+func (p *Pruner) runPreDeleteHooks(ctx context.Context, event *HookEvent) error {
+	event.Phase = HookPreDelete
+	for _, hook := range p.preDeleteHooks {
+		if err := p.runHook(ctx, hook, event); err != nil {
+			return fmt.Errorf("%w: %s hook: %w", ErrDeletionVetoed, hook.Name(), err)
+		}
+	}
+	return nil
+}
+
+// runPostDeleteHooks runs every post-delete hook. The deletion already
+// happened, so failures are only logged and counted.
+func (p *Pruner) runPostDeleteHooks(ctx context.Context, event *HookEvent) {
+	event.Phase = HookPostDelete
+	for _, hook := range p.postDeleteHooks {
+		if err := p.runHook(ctx, hook, event); err != nil {
+			p.logger.Error("post-delete hook failed",
+				"hook", hook.Name(),
+				"kind", event.Kind,
+				"name", event.Release,
+				"namespace", event.Namespace,
+				"error", err)
+		}
+	}
+}
+
+// runHook runs one hook with the hook timeout, traced as a deletion-hook
+// span.
+func (p *Pruner) runHook(ctx context.Context, hook DeletionHook, event *HookEvent) (err error) {
+	ctx, span := p.startSpan(ctx, "deletion-hook",
+		attrHookName.String(hook.Name()),
+		attrHookPhase.String(event.Phase),
+		attrNamespace.String(event.Namespace))
+	defer func() { endSpan(span, err) }()
+
+	timeout := p.opts.HookTimeout
+	if timeout == 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := hook.Run(ctx, event); err != nil {
+		hookFailuresTotal.WithLabelValues(p.opts.ClusterName, event.Phase).Inc()
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("timed out after %v: %w", timeout, err)
+		}
+		return err
+	}
+	return nil
+}
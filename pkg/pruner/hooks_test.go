@@ -0,0 +1,193 @@
+package pruner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"helm.sh/helm/v4/pkg/release/common"
+)
+
+// recordingHook records the events it runs for and fails for the
+// namespaces in veto.
+type recordingHook struct {
+	events []HookEvent
+	veto   map[string]bool
+}
+
+func (h *recordingHook) Name() string { return "recording" }
+
+func (h *recordingHook) Run(_ context.Context, event *HookEvent) error {
+	h.events = append(h.events, *event)
+	if h.veto[event.Namespace] {
+		return errors.New("owner says no")
+	}
+	return nil
+}
+
+func TestNewDeletionHooks(t *testing.T) {
+	custom := &recordingHook{}
+	pre, post, err := newDeletionHooks(Options{
+		PreDeleteCommand:  "true",
+		PreDeleteWebhook:  "https://hooks.example.com/pre",
+		PostDeleteWebhook: "http://hooks.example.com/post",
+		PostDeleteHooks:   []DeletionHook{custom},
+	})
+	if err != nil {
+		t.Fatalf("newDeletionHooks: %v", err)
+	}
+	var names []string
+	for _, hook := range pre {
+		names = append(names, hook.Name())
+	}
+	if !slices.Equal(names, []string{"command", "webhook"}) {
+		t.Errorf("pre-delete hooks = %v, want [command webhook]", names)
+	}
+	if len(post) != 2 || post[1] != custom {
+		t.Errorf("expected the webhook then the custom post-delete hook, got %v", post)
+	}
+
+	for _, opts := range []Options{
+		{PreDeleteWebhook: "hooks.example.com/pre"},
+		{PostDeleteWebhook: "ftp://hooks.example.com/post"},
+		{HookTimeout: -time.Second},
+	} {
+		if _, _, err := newDeletionHooks(opts); err == nil {
+			t.Errorf("expected an error for %+v", opts)
+		}
+	}
+}
+
+func TestCommandHook(t *testing.T) {
+	ctx := context.Background()
+	out := filepath.Join(t.TempDir(), "out")
+	event := &HookEvent{Phase: HookPreDelete, Kind: HookKindRelease, Namespace: "feature-a", Release: "web", Revision: 3}
+
+	hook := &CommandHook{Command: `echo "$PRUNER_NAMESPACE/$PRUNER_RELEASE@$PRUNER_RELEASE_REVISION" > "$OUT"; cat >> "$OUT"`}
+	t.Setenv("OUT", out)
+	if err := hook.Run(ctx, event); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("failed to read hook output: %v", err)
+	}
+	env, payload, _ := strings.Cut(string(data), "\n")
+	if env != "feature-a/web@3" {
+		t.Errorf("hook environment = %q, want feature-a/web@3", env)
+	}
+	var got HookEvent
+	if err := json.Unmarshal([]byte(payload), &got); err != nil || got.Release != "web" || got.Phase != HookPreDelete {
+		t.Errorf("hook stdin = %q, %v", payload, err)
+	}
+
+	failing := &CommandHook{Command: "echo snapshot failed >&2; exit 3"}
+	if err := failing.Run(ctx, event); err == nil || !strings.Contains(err.Error(), "snapshot failed") {
+		t.Errorf("expected the exit status and output in the error, got %v", err)
+	}
+}
+
+func TestWebhookHook(t *testing.T) {
+	var received HookEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if received.Namespace == "feature-owned" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("still owned by team-a"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	hook := &WebhookHook{URL: server.URL}
+	ctx := context.Background()
+	if err := hook.Run(ctx, &HookEvent{Phase: HookPreDelete, Kind: HookKindNamespace, Namespace: "feature-a"}); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if received.Kind != HookKindNamespace || received.Namespace != "feature-a" {
+		t.Errorf("unexpected webhook payload %+v", received)
+	}
+	err := hook.Run(ctx, &HookEvent{Phase: HookPreDelete, Kind: HookKindNamespace, Namespace: "feature-owned"})
+	if err == nil || !strings.Contains(err.Error(), "still owned by team-a") {
+		t.Errorf("expected the status and response in the error, got %v", err)
+	}
+}
+
+func TestRunHook_Timeout(t *testing.T) {
+	p := newTestPruner(Options{HookTimeout: 50 * time.Millisecond})
+	p.preDeleteHooks = []DeletionHook{&CommandHook{Command: "sleep 5"}}
+
+	start := time.Now()
+	err := p.runPreDeleteHooks(context.Background(), p.namespaceHookEvent("feature-a"))
+	if !errors.Is(err, ErrDeletionVetoed) || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected a timed out hook to veto the deletion, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("hook ran for %v despite the timeout", elapsed)
+	}
+}
+
+func TestPruneRelease_PreDeleteHookVeto(t *testing.T) {
+	p := newOrphanPruner(t, Options{})
+	storeRevision(t, p, "web", "feature-a", 1, common.StatusDeployed)
+	hook := &recordingHook{veto: map[string]bool{"feature-a": true}}
+	p.preDeleteHooks = []DeletionHook{hook}
+
+	result := &CycleResult{}
+	if p.pruneRelease(context.Background(), mockRelease("web", "feature-a", time.Now().Add(-48*time.Hour)), result) {
+		t.Fatal("expected the vetoed release not to be deleted")
+	}
+	if len(result.Deleted) != 0 || len(result.Failed) != 0 {
+		t.Errorf("expected a vetoed release to be neither deleted nor failed, got %+v", result)
+	}
+	if len(result.Skipped) != 1 || !strings.Contains(result.Skipped[0].Reason, "owner says no") {
+		t.Errorf("expected the veto as the skip reason, got %+v", result.Skipped)
+	}
+	if len(hook.events) != 1 || hook.events[0].Kind != HookKindRelease || hook.events[0].Release != "web" {
+		t.Errorf("unexpected hook events %+v", hook.events)
+	}
+	if failures := p.ReleaseFailures(); len(failures) != 0 {
+		t.Errorf("expected a veto not to count as a failed uninstall, got %+v", failures)
+	}
+	if hasReleases, err := p.namespaceHasReleases(context.Background(), "feature-a"); err != nil || !hasReleases {
+		t.Errorf("expected the release to be kept, got %v, %v", hasReleases, err)
+	}
+}
+
+func TestCleanupOrphanNamespaces_Hooks(t *testing.T) {
+	old := time.Now().Add(-24 * time.Hour)
+	p := newOrphanPruner(t, Options{}, namespace("feature-a", old), namespace("feature-b", old))
+	pre := &recordingHook{veto: map[string]bool{"feature-b": true}}
+	post := &recordingHook{}
+	p.preDeleteHooks = []DeletionHook{pre}
+	p.postDeleteHooks = []DeletionHook{post}
+
+	result := &CycleResult{}
+	if err := p.cleanupOrphanNamespaces(context.Background(), result); err != nil {
+		t.Fatalf("cleanupOrphanNamespaces: %v", err)
+	}
+	if got := remainingNamespaces(t, p); !slices.Equal(got, []string{"feature-b"}) {
+		t.Errorf("remaining namespaces = %v, want [feature-b]", got)
+	}
+	if !slices.Equal(result.NamespacesDeleted, []string{"feature-a"}) || len(result.Failed) != 0 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if len(pre.events) != 2 {
+		t.Errorf("expected pre-delete hooks for both namespaces, got %+v", pre.events)
+	}
+	if len(post.events) != 1 || post.events[0].Namespace != "feature-a" || post.events[0].Phase != HookPostDelete {
+		t.Errorf("expected a post-delete hook for feature-a only, got %+v", post.events)
+	}
+}
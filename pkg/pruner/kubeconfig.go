@@ -0,0 +1,103 @@
+package pruner
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// restClientGetter hands the same rest.Config to Helm actions and to the
+// pruner's own Kubernetes clients, so that kubeconfig, context,
+// impersonation and rate limits apply to every request the pruner makes.
+// It implements genericclioptions.RESTClientGetter.
+type restClientGetter struct {
+	config     *rest.Config
+	kubeConfig clientcmd.ClientConfig
+
+	discoveryOnce sync.Once
+	discovery     discovery.CachedDiscoveryInterface
+	discoveryErr  error
+}
+
+// newRESTClientGetter resolves the Kubernetes client configuration from the
+// options. Without an explicit kubeconfig or context, the in-cluster config
+// is preferred and the default kubeconfig is the fallback.
+func newRESTClientGetter(opts Options) (*restClientGetter, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = opts.Kubeconfig
+	configOverrides := &clientcmd.ConfigOverrides{CurrentContext: opts.KubeContext}
+	kubeConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides)
+
+	var config *rest.Config
+	if opts.Kubeconfig == "" && opts.KubeContext == "" {
+		config, _ = rest.InClusterConfig()
+	}
+	if config == nil {
+		var err error
+		config, err = kubeConfig.ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get kubernetes config: %w", err)
+		}
+	}
+
+	if opts.Impersonate != "" || len(opts.ImpersonateGroups) > 0 {
+		config.Impersonate = rest.ImpersonationConfig{
+			UserName: opts.Impersonate,
+			Groups:   opts.ImpersonateGroups,
+		}
+	}
+	if opts.QPS > 0 {
+		config.QPS = opts.QPS
+	}
+	if opts.Burst > 0 {
+		config.Burst = opts.Burst
+	}
+	if opts.RequestTimeout > 0 {
+		config.Timeout = opts.RequestTimeout
+	}
+
+	return &restClientGetter{
+		config:     config,
+		kubeConfig: kubeConfig,
+	}, nil
+}
+
+// ToRESTConfig returns a copy of the shared config.
+func (g *restClientGetter) ToRESTConfig() (*rest.Config, error) {
+	return rest.CopyConfig(g.config), nil
+}
+
+// ToDiscoveryClient returns a discovery client that is built once and
+// cached in memory for the life of the pruner.
+func (g *restClientGetter) ToDiscoveryClient() (discovery.CachedDiscoveryInterface, error) {
+	g.discoveryOnce.Do(func() {
+		dc, err := discovery.NewDiscoveryClientForConfig(rest.CopyConfig(g.config))
+		if err != nil {
+			g.discoveryErr = err
+			return
+		}
+		g.discovery = memory.NewMemCacheClient(dc)
+	})
+	return g.discovery, g.discoveryErr
+}
+
+// ToRESTMapper returns a discovery-backed REST mapper.
+func (g *restClientGetter) ToRESTMapper() (meta.RESTMapper, error) {
+	dc, err := g.ToDiscoveryClient()
+	if err != nil {
+		return nil, err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(dc)
+	return restmapper.NewShortcutExpander(mapper, dc, nil), nil
+}
+
+// ToRawKubeConfigLoader returns the kubeconfig loader the config came from.
+func (g *restClientGetter) ToRawKubeConfigLoader() clientcmd.ClientConfig {
+	return g.kubeConfig
+}
@@ -0,0 +1,95 @@
+package pruner
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com
+- name: staging
+  cluster:
+    server: https://staging.example.com
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: pruner
+- name: staging
+  context:
+    cluster: staging
+    user: pruner
+users:
+- name: pruner
+  user:
+    token: abc
+`
+
+func TestNewRESTClientGetter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := os.WriteFile(path, []byte(testKubeconfig), 0o600); err != nil {
+		t.Fatalf("failed to write kubeconfig: %v", err)
+	}
+
+	t.Run("current context", func(t *testing.T) {
+		g, err := newRESTClientGetter(Options{Kubeconfig: path})
+		if err != nil {
+			t.Fatalf("newRESTClientGetter: %v", err)
+		}
+		if g.config.Host != "https://dev.example.com" {
+			t.Errorf("Host = %q, want dev cluster", g.config.Host)
+		}
+		if g.config.Impersonate.UserName != "" || g.config.Timeout != 0 {
+			t.Error("expected no impersonation or timeout by default")
+		}
+	})
+
+	t.Run("explicit context and client settings", func(t *testing.T) {
+		g, err := newRESTClientGetter(Options{
+			Kubeconfig:        path,
+			KubeContext:       "staging",
+			Impersonate:       "system:serviceaccount:ops:pruner",
+			ImpersonateGroups: []string{"pruners"},
+			QPS:               42,
+			Burst:             84,
+			RequestTimeout:    30 * time.Second,
+		})
+		if err != nil {
+			t.Fatalf("newRESTClientGetter: %v", err)
+		}
+
+		if g.config.Host != "https://staging.example.com" {
+			t.Errorf("Host = %q, want staging cluster", g.config.Host)
+		}
+		if g.config.Impersonate.UserName != "system:serviceaccount:ops:pruner" ||
+			!slices.Equal(g.config.Impersonate.Groups, []string{"pruners"}) {
+			t.Errorf("Impersonate = %+v, want user and group", g.config.Impersonate)
+		}
+		if g.config.QPS != 42 || g.config.Burst != 84 || g.config.Timeout != 30*time.Second {
+			t.Errorf("QPS/Burst/Timeout = %v/%v/%v, want 42/84/30s", g.config.QPS, g.config.Burst, g.config.Timeout)
+		}
+
+		// Helm receives copies of the same config.
+		c, err := g.ToRESTConfig()
+		if err != nil {
+			t.Fatalf("ToRESTConfig: %v", err)
+		}
+		if c == g.config || c.Host != g.config.Host || c.Impersonate.UserName != g.config.Impersonate.UserName {
+			t.Error("ToRESTConfig should return a copy of the shared config")
+		}
+	})
+
+	t.Run("unknown context", func(t *testing.T) {
+		if _, err := newRESTClientGetter(Options{Kubeconfig: path, KubeContext: "prod"}); err == nil {
+			t.Error("expected error for unknown context, got nil")
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package pruner
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// usesNamespaceSelectors reports whether release selection depends on
+// namespace labels or annotations.
+func (p *Pruner) usesNamespaceSelectors() bool {
+	return p.opts.NamespaceSelector != nil ||
+		p.opts.NamespaceExcludeSelector != nil ||
+		p.opts.NamespaceExcludeAnnotations != nil
+}
+
+// listNamespaceMeta returns the metadata of every namespace, keyed by name,
+// when release selection depends on it, through namespace selectors or
+// custom rules and filters. Otherwise it returns nil without calling the API server.
+func (p *Pruner) listNamespaceMeta(ctx context.Context) (map[string]metav1.ObjectMeta, error) {
+	if !p.usesNamespaceSelectors() && len(p.opts.Rules) == 0 && len(p.opts.Filters) == 0 {
+		return nil, nil
+	}
+
+	namespaces, err := p.k8s.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	meta := make(map[string]metav1.ObjectMeta, len(namespaces.Items))
+	for _, ns := range namespaces.Items {
+		meta[ns.Name] = ns.ObjectMeta
+	}
+	return meta, nil
+}
+
+// namespaceExcluded returns which selector excludes a namespace from release
+// pruning and from deletion once empty, or "" if none does.
+func (p *Pruner) namespaceExcluded(ns metav1.ObjectMeta) string {
+	switch {
+	case selectorMatches(p.opts.NamespaceExcludeSelector, ns.Labels):
+		return "namespace exclude selector"
+	case selectorMatches(p.opts.NamespaceExcludeAnnotations, ns.Annotations):
+		return "namespace annotation exclude"
+	default:
+		return ""
+	}
+}
+
+// selectorMatches reports whether sel is set and matches set. A nil selector
+// matches nothing.
+func selectorMatches(sel labels.Selector, set map[string]string) bool {
+	return sel != nil && sel.Matches(labels.Set(set))
+}
@@ -0,0 +1,123 @@
+package pruner
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+)
+
+// labelledNamespace creates a namespace created a day ago with the given
+// labels and annotations.
+func labelledNamespace(name string, lbls, annotations map[string]string) *corev1.Namespace {
+	ns := namespace(name, time.Now().Add(-24*time.Hour))
+	ns.Labels = lbls
+	ns.Annotations = annotations
+	return ns
+}
+
+func TestFilterReleases_NamespaceSelectors(t *testing.T) {
+	now := time.Now()
+	releases := []*releasev1.Release{
+		mockRelease("preview-a", "preview-a", now),
+		mockRelease("preview-b", "preview-b", now),
+		mockRelease("pinned", "preview-pinned", now),
+		mockRelease("prod", "production", now),
+	}
+	namespaces := map[string]metav1.ObjectMeta{
+		"preview-a":      {Labels: map[string]string{"env": "preview", "team": "x"}},
+		"preview-b":      {Labels: map[string]string{"env": "preview", "team": "y"}},
+		"preview-pinned": {Labels: map[string]string{"env": "preview"}, Annotations: map[string]string{"pruner.fairwinds.com/keep": "true"}},
+		"production":     {Labels: map[string]string{"env": "prod"}},
+	}
+
+	tests := []struct {
+		name     string
+		opts     Options
+		expected []string
+	}{
+		{
+			name:     "include selector",
+			opts:     Options{NamespaceSelector: labels.SelectorFromSet(labels.Set{"env": "preview"})},
+			expected: []string{"preview-a", "preview-b", "pinned"},
+		},
+		{
+			name: "include and exclude selectors",
+			opts: Options{
+				NamespaceSelector:        labels.SelectorFromSet(labels.Set{"env": "preview"}),
+				NamespaceExcludeSelector: labels.SelectorFromSet(labels.Set{"team": "y"}),
+			},
+			expected: []string{"preview-a", "pinned"},
+		},
+		{
+			name:     "annotation exclude",
+			opts:     Options{NamespaceExcludeAnnotations: labels.SelectorFromSet(labels.Set{"pruner.fairwinds.com/keep": "true"})},
+			expected: []string{"preview-a", "preview-b", "prod"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPruner(tt.opts)
+			var got []string
+			for _, rel := range p.filterReleases(releases, namespaces) {
+				got = append(got, rel.Name)
+			}
+			if !slices.Equal(got, tt.expected) {
+				t.Errorf("filtered releases = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCleanupOrphanNamespaces_Selectors(t *testing.T) {
+	keep := map[string]string{"pruner.fairwinds.com/keep": "true"}
+	p := newOrphanPruner(t, Options{
+		OrphanNamespaceSelector:        labels.SelectorFromSet(labels.Set{"env": "preview"}),
+		OrphanNamespaceExcludeSelector: labels.SelectorFromSet(labels.Set{"team": "platform"}),
+		NamespaceExcludeAnnotations:    labels.SelectorFromSet(labels.Set(keep)),
+	},
+		labelledNamespace("feature-preview", map[string]string{"env": "preview"}, nil),
+		labelledNamespace("feature-unlabelled", nil, nil),
+		labelledNamespace("feature-platform", map[string]string{"env": "preview", "team": "platform"}, nil),
+		labelledNamespace("feature-pinned", map[string]string{"env": "preview"}, keep),
+	)
+
+	if err := p.cleanupOrphanNamespaces(context.Background(), &CycleResult{}); err != nil {
+		t.Fatalf("cleanupOrphanNamespaces: %v", err)
+	}
+
+	expected := []string{"feature-pinned", "feature-platform", "feature-unlabelled"}
+	if got := remainingNamespaces(t, p); !slices.Equal(got, expected) {
+		t.Errorf("remaining namespaces = %v, want %v", got, expected)
+	}
+}
+
+func TestDeleteNamespaceIfEmpty_Protected(t *testing.T) {
+	p := newOrphanPruner(t, Options{
+		NamespaceExcludeSelector:    labels.SelectorFromSet(labels.Set{"protected": "true"}),
+		NamespaceExcludeAnnotations: labels.SelectorFromSet(labels.Set{"pruner.fairwinds.com/keep": "true"}),
+	},
+		labelledNamespace("by-label", map[string]string{"protected": "true"}, nil),
+		labelledNamespace("by-annotation", nil, map[string]string{"pruner.fairwinds.com/keep": "true"}),
+		labelledNamespace("unprotected", nil, nil),
+	)
+	ctx := context.Background()
+
+	for _, ns := range []string{"by-label", "by-annotation", "unprotected", "already-gone"} {
+		if _, err := p.deleteNamespaceIfEmpty(ctx, ns); err != nil {
+			t.Errorf("deleteNamespaceIfEmpty(%s): %v", ns, err)
+		}
+	}
+
+	expected := []string{"by-annotation", "by-label"}
+	if got := remainingNamespaces(t, p); !slices.Equal(got, expected) {
+		t.Errorf("remaining namespaces = %v, want %v", got, expected)
+	}
+}
@@ -1,19 +1,52 @@
 package pruner
 
 import (
+	"fmt"
 	"regexp"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"helm.sh/helm/v4/pkg/kube"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 // Options configures the pruner behavior.
 type Options struct {
+	// ClusterName identifies the cluster in logs and in the cluster label of
+	// metrics. Empty when a single cluster is managed.
+	ClusterName string
+
+	// Kubeconfig is the path to a kubeconfig file. Empty means the in-cluster
+	// config, or the default kubeconfig loading rules ($KUBECONFIG, ~/.kube/config).
+	Kubeconfig string
+
+	// KubeContext is the kubeconfig context to connect to. Empty means the
+	// in-cluster config, or the current context of the kubeconfig.
+	KubeContext string
+
+	// Impersonate is the user to impersonate for all Kubernetes and Helm
+	// requests. Empty means no impersonation.
+	Impersonate string
+
+	// ImpersonateGroups are the groups to impersonate, with or without Impersonate.
+	ImpersonateGroups []string
+
+	// QPS and Burst limit the client-side request rate to the API server.
+	// 0 means the client-go defaults.
+	QPS   float32
+	Burst int
+
+	// RequestTimeout bounds each individual API request. 0 means no timeout.
+	RequestTimeout time.Duration
+
 	// Interval is how often to run the pruning loop.
 	// Only used in daemon mode.
 	Interval time.Duration
 
-	// MaxReleasesToKeep is the maximum number of releases to keep globally.
-	// After applying all filters, releases beyond this count (sorted by date,
-	// newest first) will be deleted. 0 means no limit based on count.
+	// MaxReleasesToKeep is the maximum number of releases to keep in each
+	// limit scope. After applying all filters, releases beyond this count
+	// (sorted by date, newest first) will be deleted. 0 means no limit based
+	// on count.
 	MaxReleasesToKeep int
 
 	// OlderThan specifies the age threshold.
@@ -21,6 +54,65 @@ type Options struct {
 	// 0 means no age-based filtering.
 	OlderThan time.Duration
 
+	// MaxLifetime deletes releases first deployed longer than this ago,
+	// however often they were redeployed since. Unlike OlderThan, it ignores
+	// the last deployment and activity signals, and LimitMode. 0 means no
+	// lifetime limit.
+	MaxLifetime time.Duration
+
+	// DeleteFailedInstalls deletes releases whose only revision failed.
+	DeleteFailedInstalls bool
+
+	// LimitMode is how MaxReleasesToKeep and OlderThan combine when both are
+	// set: LimitModeAny deletes releases beyond either limit, LimitModeAll
+	// only releases beyond both. Empty means LimitModeAny.
+	LimitMode string
+
+	// MinReleasesToKeep is the number of candidate releases kept in each
+	// limit scope whatever the rules decide: the newest selected releases
+	// are spared until the scope keeps this many. 0 means no minimum.
+	MinReleasesToKeep int
+
+	// LimitScope is the set of releases MaxReleasesToKeep and
+	// MinReleasesToKeep count: LimitScopeGlobal, LimitScopeNamespace, or
+	// LimitScopeGroup for the groups of GroupPattern. Empty means
+	// LimitScopeGlobal.
+	LimitScope string
+
+	// GroupPattern extracts the group of a release from its name for
+	// LimitScopeGroup: the capture group named "group", else the first
+	// capture group, else the whole match. Releases it does not match form
+	// one group.
+	GroupPattern *regexp.Regexp
+
+	// ActivitySignals names the built-in activity signals used to measure
+	// release age: pods, annotation and ingress. Age is measured from the
+	// most recent of the last deployment and every signal, for both
+	// OlderThan and MaxReleasesToKeep. Empty means the last deployment only.
+	ActivitySignals []string
+
+	// ActivityConfigMap is the namespace/name of the ConfigMap read by the
+	// ingress activity signal. Its keys are Ingress hosts and its values
+	// RFC 3339 access times.
+	ActivityConfigMap string
+
+	// CustomActivitySignals are additional activity signals, consulted after
+	// the built-in ones.
+	CustomActivitySignals []ActivitySignal
+
+	// Rules are custom release selection rules, evaluated for every
+	// candidate release together with the built-in MaxReleasesToKeep,
+	// OlderThan, MaxLifetime, DeleteFailedInstalls and branch rules. A
+	// release is deleted when a rule decides Delete and none decides Keep.
+	// See Rule.
+	Rules []Rule
+
+	// Filters are custom filter rules, evaluated after the built-in name and
+	// namespace filters. A release they decide to Keep is not a candidate
+	// for pruning; other decisions are ignored. Filters run before activity
+	// is collected, so RuleContext.LastActive is the last deployment time.
+	Filters []Rule
+
 	// ReleaseFilter is a regex that release names must match to be considered.
 	// nil means all releases are considered.
 	ReleaseFilter *regexp.Regexp
@@ -29,6 +121,25 @@ type Options struct {
 	// nil means all namespaces are considered.
 	NamespaceFilter *regexp.Regexp
 
+	// BranchPattern extracts the source branch from release names, using
+	// the capture group named "branch" or else the first capture group.
+	// Releases whose branch no longer exists are deleted regardless of age.
+	// Releases that do not match are not branch checked. nil disables
+	// branch checking.
+	BranchPattern *regexp.Regexp
+
+	// BranchGitRemote is the git remote (URL or path, e.g. a bare
+	// repository) whose branch heads are checked.
+	BranchGitRemote string
+
+	// BranchCheckURL is an HTTP endpoint that reports whether a ref is
+	// alive, with {branch} in place of the branch name. See HTTPBranchChecker.
+	BranchCheckURL string
+
+	// BranchChecker is a custom branch checker, used instead of
+	// BranchGitRemote or BranchCheckURL.
+	BranchChecker BranchChecker
+
 	// ReleaseExclude is a regex that excludes matching releases.
 	// nil means no releases are excluded by name.
 	ReleaseExclude *regexp.Regexp
@@ -37,6 +148,26 @@ type Options struct {
 	// nil means no namespaces are excluded.
 	NamespaceExclude *regexp.Regexp
 
+	// NamespaceSelector is a label selector that a release's namespace must
+	// match to be considered. nil means namespace labels are not checked.
+	NamespaceSelector labels.Selector
+
+	// NamespaceExcludeSelector excludes releases whose namespace labels
+	// match. Matching namespaces are also never deleted after pruning.
+	NamespaceExcludeSelector labels.Selector
+
+	// NamespaceExcludeAnnotations is a selector matched against namespace
+	// annotations instead of labels. Matching namespaces are skipped by
+	// release pruning and orphan cleanup, and are never deleted.
+	NamespaceExcludeAnnotations labels.Selector
+
+	// HistoryMax is the number of revisions to keep per release when trimming
+	// release history. Older superseded revision records are deleted from
+	// Helm storage; the deployed revision is never removed. Releases are
+	// scoped by the same name and namespace filters as pruning.
+	// 0 disables history trimming.
+	HistoryMax int
+
 	// PreserveNamespace prevents deletion of empty namespaces after release deletion.
 	PreserveNamespace bool
 
@@ -52,6 +183,64 @@ type Options struct {
 	// from orphan cleanup (e.g., system namespaces).
 	OrphanNamespaceExclude *regexp.Regexp
 
+	// OrphanNamespaceSelector is a label selector that namespaces must match
+	// to be considered for orphan cleanup. It can be used instead of, or
+	// together with, OrphanNamespaceFilter.
+	OrphanNamespaceSelector labels.Selector
+
+	// OrphanNamespaceExcludeSelector excludes namespaces whose labels match
+	// from orphan cleanup.
+	OrphanNamespaceExcludeSelector labels.Selector
+
+	// OrphanMinAge is the minimum age, from its creation timestamp, before a
+	// namespace can be deleted as an orphan. This protects namespaces created
+	// just before their first Helm install. 0 means no minimum.
+	OrphanMinAge time.Duration
+
+	// OrphanIdleTime is how long a namespace must have been observed without
+	// Helm releases, across prune cycles, before it is deleted as an orphan.
+	// Observations are part of the pruner state, so they survive restarts
+	// when a state store is configured; otherwise they restart with the
+	// pruner. 0 means a namespace can be deleted the first time it is seen
+	// empty.
+	OrphanIdleTime time.Duration
+
+	// OrphanBlockingResources lists resources that keep an orphan namespace
+	// alive while it contains any of them, e.g. "persistentvolumeclaims",
+	// "statefulsets.apps" or a custom resource like "widgets.example.com".
+	// The default ServiceAccount and kube-root-ca.crt ConfigMap are ignored.
+	OrphanBlockingResources []string
+
+	// OrphanBlockOnRunningPods keeps an orphan namespace alive while it has
+	// Running or Pending pods.
+	OrphanBlockOnRunningPods bool
+
+	// ProtectReferencedNamespaces keeps releases and namespaces that other
+	// namespaces still depend on, through ExternalName Services, Ingress
+	// backends, NetworkPolicy namespace selectors or CustomReferences.
+	// Dependencies from namespaces that are being deleted in the same cycle
+	// do not count.
+	ProtectReferencedNamespaces bool
+
+	// CustomReferences lists additional references as "resource:field.path",
+	// e.g. "databaseclaims.example.com:spec.instanceNamespace". Each object
+	// of the resource depends on the namespace (or list of namespaces) named
+	// at the field path. Only used with ProtectReferencedNamespaces.
+	CustomReferences []string
+
+	// StuckNamespaceThreshold is how long a namespace deleted by the pruner
+	// may stay Terminating before it is reported as stuck, through a metric
+	// and a warning event. Deleted namespaces are tracked in the pruner
+	// state, so they survive restarts when a state store is configured.
+	// 0 disables tracking.
+	StuckNamespaceThreshold time.Duration
+
+	// StuckNamespaceRemoveFinalizers lists kinds, as Kind.version.group or
+	// Kind.group (e.g. "Widget.v1.example.com"), whose objects have their
+	// finalizers removed when they keep a namespace stuck in Terminating.
+	// Empty disables finalizer removal.
+	StuckNamespaceRemoveFinalizers []string
+
 	// DeleteRateLimit is the minimum duration to wait between delete operations.
 	// This prevents overwhelming the Kubernetes API server.
 	// 0 means no rate limiting.
@@ -62,9 +251,167 @@ type Options struct {
 	// default list (default, kube-system, kube-public, kube-node-lease).
 	AdditionalSystemNamespaces []string
 
+	// HelmDriver is the Helm storage backend for release records: secret,
+	// configmap, memory or sql. Empty means secret.
+	HelmDriver string
+
+	// HelmDriverSQLConnectionString is the PostgreSQL connection string used
+	// when HelmDriver is sql.
+	HelmDriverSQLConnectionString string
+
+	// Uninstall controls how Helm uninstalls each selected release.
+	Uninstall UninstallOptions
+
+	// ReleaseRetryBackoff is the base of the per-release backoff after failed
+	// uninstalls: a release is retried on the next cycle after its first
+	// failure, then after ReleaseRetryBackoff, doubling with every further
+	// failure up to a day. 0 retries failed releases every cycle.
+	ReleaseRetryBackoff time.Duration
+
+	// QuarantineAfter stops retrying a release after this many failed
+	// uninstalls, until the release changes or its failures are cleared.
+	// 0 disables quarantine.
+	QuarantineAfter int
+
+	// ForcePurgeAfter force purges a release after this many failed
+	// uninstalls: the resources of its manifest are deleted, skipping kinds
+	// the cluster no longer serves, and its Helm storage records are removed
+	// without running hooks. It must be lower than QuarantineAfter to have
+	// any effect. 0 disables force purge.
+	ForcePurgeAfter int
+
+	// PreDeleteCommand is a shell command run before each release or
+	// namespace deletion, with the object described in PRUNER_* environment
+	// variables and as JSON on standard input. A non-zero exit status vetoes
+	// the deletion. Hooks are not run in dry-run mode.
+	PreDeleteCommand string
+
+	// PostDeleteCommand is a shell command run after each successful
+	// release or namespace deletion. Failures are logged only.
+	PostDeleteCommand string
+
+	// PreDeleteWebhook is an HTTP endpoint that receives each release or
+	// namespace as JSON in a POST before it is deleted. Any response other
+	// than 2xx, or no response, vetoes the deletion.
+	PreDeleteWebhook string
+
+	// PostDeleteWebhook is an HTTP endpoint notified the same way after each
+	// successful deletion. Failures are logged only.
+	PostDeleteWebhook string
+
+	// PreDeleteHooks and PostDeleteHooks are custom deletion hooks, run
+	// after the commands and webhooks.
+	PreDeleteHooks  []DeletionHook
+	PostDeleteHooks []DeletionHook
+
+	// HookTimeout bounds each deletion hook. 0 means 1 minute.
+	HookTimeout time.Duration
+
+	// StateConfigMap is the ConfigMap (namespace/name) that persists the
+	// pruner state (last cycle, failure counts, quarantined releases, orphan
+	// idle marks and activity observations) across restarts and leader
+	// handoffs. Empty keeps the state in memory only.
+	StateConfigMap string
+
+	// StateFile persists the pruner state in a local file instead, for
+	// example on a persistent volume.
+	StateFile string
+
+	// StateStore is a custom state store, used instead of StateConfigMap or
+	// StateFile.
+	//
+	// With DryRun, the state is loaded but never saved.
+	StateStore StateStore
+
+	// MaxDeletionsPerCycle aborts a cycle, before anything is deleted, that
+	// selects more releases, or more orphan namespaces, than this. 0 means no
+	// limit.
+	MaxDeletionsPerCycle int
+
+	// MaxDeletionFailurePercent is the largest percentage of failed release
+	// and namespace deletions a cycle tolerates before it counts as failed.
+	// 0 fails the cycle on any failed deletion; 100 never does.
+	MaxDeletionFailurePercent float64
+
+	// TracerProvider creates the tracer for prune cycle spans. nil means the
+	// global OpenTelemetry tracer provider.
+	TracerProvider trace.TracerProvider
+
 	// DryRun shows what would be deleted without actually deleting.
 	DryRun bool
 
 	// Debug enables verbose logging.
 	Debug bool
 }
+
+// Wait strategies accepted by ParseWaitStrategy.
+const (
+	WaitStrategyWatcher = "watcher"
+	WaitStrategyLegacy  = "legacy"
+	WaitStrategyNone    = "none"
+)
+
+// Deletion propagation policies accepted in UninstallOptions.DeletionPropagation.
+const (
+	PropagationBackground = "background"
+	PropagationForeground = "foreground"
+	PropagationOrphan     = "orphan"
+)
+
+// defaultUninstallTimeout is used when UninstallOptions.Timeout is 0.
+const defaultUninstallTimeout = 10 * time.Minute
+
+// UninstallOptions configures the Helm uninstall action used to delete releases.
+type UninstallOptions struct {
+	// WaitStrategy is how Helm waits for deleted resources to disappear.
+	// Empty means kube.LegacyStrategy.
+	WaitStrategy kube.WaitStrategy
+
+	// Timeout bounds each uninstall, including hooks and waiting.
+	// 0 means 10 minutes.
+	Timeout time.Duration
+
+	// DisableHooks skips pre-delete and post-delete chart hooks.
+	DisableHooks bool
+
+	// KeepHistory marks releases as uninstalled instead of purging their
+	// release records.
+	KeepHistory bool
+
+	// DeletionPropagation is the cascade policy for deleted resources:
+	// background, foreground or orphan. Empty means background.
+	DeletionPropagation string
+
+	// IgnoreNotFound treats a release that disappeared before it could be
+	// uninstalled as successfully deleted.
+	IgnoreNotFound bool
+}
+
+// ParseWaitStrategy converts a user-facing wait strategy name into the
+// matching Helm strategy. "none" maps to kube.HookOnlyStrategy, which waits
+// for hooks to finish but not for the release's resources to be removed.
+func ParseWaitStrategy(s string) (kube.WaitStrategy, error) {
+	switch s {
+	case WaitStrategyWatcher:
+		return kube.StatusWatcherStrategy, nil
+	case WaitStrategyLegacy, "":
+		return kube.LegacyStrategy, nil
+	case WaitStrategyNone:
+		return kube.HookOnlyStrategy, nil
+	default:
+		return "", fmt.Errorf("unknown wait strategy %q (valid: %s, %s, %s)",
+			s, WaitStrategyWatcher, WaitStrategyLegacy, WaitStrategyNone)
+	}
+}
+
+// ValidatePropagation returns an error if s is not a supported deletion
+// propagation policy. Empty is valid and means background.
+func ValidatePropagation(s string) error {
+	switch s {
+	case "", PropagationBackground, PropagationForeground, PropagationOrphan:
+		return nil
+	default:
+		return fmt.Errorf("unknown deletion propagation %q (valid: %s, %s, %s)",
+			s, PropagationBackground, PropagationForeground, PropagationOrphan)
+	}
+}
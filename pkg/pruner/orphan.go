@@ -0,0 +1,52 @@
+package pruner
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// orphanIdle records when a namespace was first seen without Helm releases
+// and reports whether it has stayed that way for OrphanIdleTime. While
+// previewing, a namespace seen for the first time is not recorded.
+func (p *Pruner) orphanIdle(namespace string, now time.Time) (time.Time, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.orphanSince == nil {
+		p.orphanSince = make(map[string]time.Time)
+	}
+	since, ok := p.orphanSince[namespace]
+	if !ok {
+		since = now
+		if !p.previewing {
+			p.orphanSince[namespace] = since
+		}
+	}
+
+	return since, now.Sub(since) >= p.opts.OrphanIdleTime
+}
+
+// markOrphanActive resets the idle window of a namespace that has Helm
+// releases again.
+func (p *Pruner) markOrphanActive(namespace string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.orphanSince, namespace)
+}
+
+// forgetMissingOrphans drops idle tracking for namespaces that no longer exist.
+func (p *Pruner) forgetMissingOrphans(namespaces []corev1.Namespace) {
+	existing := make(map[string]bool, len(namespaces))
+	for _, ns := range namespaces {
+		existing[ns.Name] = true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name := range p.orphanSince {
+		if !existing[name] {
+			delete(p.orphanSince, name)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package pruner
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+
+	"helm.sh/helm/v4/pkg/release/common"
+)
+
+func namespace(name string, created time.Time) *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(created)},
+	}
+}
+
+// newOrphanPruner creates a test Pruner with fake Kubernetes clients and an
+// in-memory Helm storage driver.
+func newOrphanPruner(t *testing.T, opts Options, typed ...runtime.Object) *Pruner {
+	t.Helper()
+	if opts.OrphanNamespaceFilter == nil {
+		opts.OrphanNamespaceFilter = regexp.MustCompile(`^feature-`)
+	}
+	p := newClusterPruner(opts, typed)
+	p.restGetter = &restClientGetter{config: &rest.Config{Host: "https://127.0.0.1:1"}}
+	s, err := newHelmStorage(DriverMemory, "")
+	if err != nil {
+		t.Fatalf("newHelmStorage: %v", err)
+	}
+	p.storage = s
+	return p
+}
+
+// remainingNamespaces returns the names of namespaces left in the fake cluster.
+func remainingNamespaces(t *testing.T, p *Pruner) []string {
+	t.Helper()
+	list, err := p.k8s.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list namespaces: %v", err)
+	}
+	var names []string
+	for _, ns := range list.Items {
+		names = append(names, ns.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestCleanupOrphanNamespaces_MinAge(t *testing.T) {
+	now := time.Now()
+	p := newOrphanPruner(t, Options{OrphanMinAge: time.Hour},
+		namespace("feature-old", now.Add(-2*time.Hour)),
+		namespace("feature-new", now.Add(-time.Minute)),
+		namespace("feature-used", now.Add(-2*time.Hour)),
+	)
+	storeRevision(t, p, "web", "feature-used", 1, common.StatusDeployed)
+
+	if err := p.cleanupOrphanNamespaces(context.Background(), &CycleResult{}); err != nil {
+		t.Fatalf("cleanupOrphanNamespaces: %v", err)
+	}
+
+	expected := []string{"feature-new", "feature-used"}
+	if got := remainingNamespaces(t, p); !slices.Equal(got, expected) {
+		t.Errorf("remaining namespaces = %v, want %v", got, expected)
+	}
+}
+
+func TestCleanupOrphanNamespaces_IdleTime(t *testing.T) {
+	old := time.Now().Add(-24 * time.Hour)
+	p := newOrphanPruner(t, Options{OrphanIdleTime: time.Hour},
+		namespace("feature-a", old),
+		namespace("feature-b", old),
+	)
+	ctx := context.Background()
+
+	// First observation starts the idle window; nothing is deleted.
+	if err := p.cleanupOrphanNamespaces(ctx, &CycleResult{}); err != nil {
+		t.Fatalf("cleanupOrphanNamespaces: %v", err)
+	}
+	if got := remainingNamespaces(t, p); len(got) != 2 {
+		t.Fatalf("expected both namespaces to survive the first cycle, got %v", got)
+	}
+
+	// feature-a was seen empty over an hour ago; feature-b got a release.
+	p.orphanSince["feature-a"] = time.Now().Add(-2 * time.Hour)
+	p.orphanSince["feature-b"] = time.Now().Add(-2 * time.Hour)
+	storeRevision(t, p, "web", "feature-b", 1, common.StatusDeployed)
+
+	if err := p.cleanupOrphanNamespaces(ctx, &CycleResult{}); err != nil {
+		t.Fatalf("cleanupOrphanNamespaces: %v", err)
+	}
+	if got := remainingNamespaces(t, p); !slices.Equal(got, []string{"feature-b"}) {
+		t.Errorf("remaining namespaces = %v, want [feature-b]", got)
+	}
+	if _, tracked := p.orphanSince["feature-b"]; tracked {
+		t.Error("expected release activity to reset the idle window of feature-b")
+	}
+}
+
+func TestForgetMissingOrphans(t *testing.T) {
+	p := newTestPruner(Options{})
+	p.orphanSince = map[string]time.Time{"gone": time.Now(), "kept": time.Now()}
+
+	p.forgetMissingOrphans([]corev1.Namespace{*namespace("kept", time.Now())})
+
+	if _, ok := p.orphanSince["gone"]; ok {
+		t.Error("expected deleted namespace to be forgotten")
+	}
+	if _, ok := p.orphanSince["kept"]; !ok {
+		t.Error("expected existing namespace to stay tracked")
+	}
+}
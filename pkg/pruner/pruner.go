@@ -2,10 +2,11 @@ package pruner
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
-	"sort"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -13,38 +14,67 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"helm.sh/helm/v4/pkg/action"
-	"helm.sh/helm/v4/pkg/cli"
 	"helm.sh/helm/v4/pkg/kube"
 	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 )
 
+// clusterLabel is the metric label that identifies which cluster a pruner
+// manages. It is empty when the pruner runs against a single cluster.
+const clusterLabel = "cluster"
+
 // Prometheus metrics
 var (
-	releasesDeletedTotal = promauto.NewCounter(prometheus.CounterOpts{
+	releasesDeletedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "helm_pruner_releases_deleted_total",
 		Help: "Total number of Helm releases deleted",
-	})
-	namespacesDeletedTotal = promauto.NewCounter(prometheus.CounterOpts{
+	}, []string{clusterLabel})
+	namespacesDeletedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "helm_pruner_namespaces_deleted_total",
 		Help: "Total number of namespaces deleted",
-	})
-	pruneCycleDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	}, []string{clusterLabel})
+	pruneCycleDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
 		Name:    "helm_pruner_cycle_duration_seconds",
 		Help:    "Duration of prune cycles in seconds",
 		Buckets: prometheus.ExponentialBuckets(1, 2, 10), // 1s to ~17min
-	})
-	pruneCycleFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+	}, []string{clusterLabel})
+	pruneCycleFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "helm_pruner_cycle_failures_total",
 		Help: "Total number of failed prune cycles",
-	})
-	releasesScannedTotal = promauto.NewCounter(prometheus.CounterOpts{
+	}, []string{clusterLabel})
+	releasesScannedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "helm_pruner_releases_scanned_total",
 		Help: "Total number of releases scanned across all cycles",
-	})
+	}, []string{clusterLabel})
+	orphanNamespacesBlocked = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "helm_pruner_orphan_namespaces_blocked",
+		Help: "Orphan namespaces whose deletion was blocked by their contents in the last cycle, by blocking resource",
+	}, []string{clusterLabel, "reason"})
+	revisionsDeletedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "helm_pruner_revisions_deleted_total",
+		Help: "Total number of superseded Helm release revisions deleted by history trimming",
+	}, []string{clusterLabel})
+	namespacesStuckTerminating = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "helm_pruner_namespaces_stuck_terminating",
+		Help: "Namespaces deleted by the pruner that are still Terminating past the stuck threshold",
+	}, []string{clusterLabel})
+	releasesForcePurged = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "helm_pruner_releases_force_purged_total",
+		Help: "Total number of Helm releases force purged after repeated uninstall failures",
+	}, []string{clusterLabel})
+	releasesQuarantined = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "helm_pruner_releases_quarantined",
+		Help: "Releases no longer retried after repeated uninstall failures",
+	}, []string{clusterLabel})
+	hookFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "helm_pruner_hook_failures_total",
+		Help: "Total number of failed deletion hooks, by phase; failed pre-delete hooks veto the deletion",
+	}, []string{clusterLabel, "phase"})
 )
 
 // defaultSystemNamespaces are namespaces that should never be deleted.
@@ -58,21 +88,46 @@ var defaultSystemNamespaces = []string{
 // Pruner handles the deletion of old Helm releases.
 type Pruner struct {
 	opts             Options
-	settings         *cli.EnvSettings
+	restGetter       *restClientGetter
+	storage          *helmStorage
 	k8s              kubernetes.Interface
+	dynamic          dynamic.Interface
+	mapper           meta.RESTMapper
 	logger           *slog.Logger
 	systemNamespaces map[string]bool
+	preDeleteHooks   []DeletionHook
+	postDeleteHooks  []DeletionHook
 
 	ready               atomic.Bool
 	initialized         atomic.Bool
+	paused              atomic.Bool
+	trigger             chan struct{}
 	consecutiveFailures int
+	lastCycle           *CycleSummary
+	nextRun             time.Time
+	releaseFailures     map[string]*ReleaseFailure
+	lastActivity        map[string]time.Time
 	mu                  sync.Mutex
+
+	// cycleMu serializes prune cycles and plans, which share the state below.
+	cycleMu           sync.Mutex
+	orphanSince       map[string]time.Time
+	deletedNamespaces map[string]*DeletedNamespace
+	state             StateStore
+	stateLoaded       bool
+	// stateBase is the state as last loaded or saved, from which local
+	// changes are merged after a save conflict.
+	stateBase *State
+	// previewing is set while Plan runs; planning then leaves the failure
+	// records, activity observations, orphan idle marks and metrics alone.
+	previewing      bool
+	activitySignals []ActivitySignal
+	branchChecker   BranchChecker
+	activity        map[*releasev1.Release]time.Time
 }
 
 // New creates a new Pruner instance.
 func New(opts Options) (*Pruner, error) {
-	settings := cli.New()
-
 	// Set up logger
 	logLevel := slog.LevelInfo
 	if opts.Debug {
@@ -81,12 +136,56 @@ func New(opts Options) (*Pruner, error) {
 	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 		Level: logLevel,
 	}))
+	if opts.ClusterName != "" {
+		logger = logger.With(clusterLabel, opts.ClusterName)
+	}
+
+	storage, err := newHelmStorage(opts.HelmDriver, opts.HelmDriverSQLConnectionString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid helm storage driver configuration: %w", err)
+	}
 
 	// Initialize Kubernetes client
-	k8sClient, err := newKubernetesClient()
+	restGetter, err := newRESTClientGetter(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+	k8sClient, err := kubernetes.NewForConfig(restGetter.config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
 	}
+	dynamicClient, err := dynamic.NewForConfig(restGetter.config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	mapper, err := restGetter.ToRESTMapper()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create REST mapper: %w", err)
+	}
+
+	signals, err := newActivitySignals(opts, k8sClient)
+	if err != nil {
+		return nil, fmt.Errorf("invalid activity signal configuration: %w", err)
+	}
+
+	branchChecker, err := newBranchChecker(opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid branch check configuration: %w", err)
+	}
+
+	state, err := newStateStore(opts, k8sClient)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state store configuration: %w", err)
+	}
+
+	if err := validateLimits(opts); err != nil {
+		return nil, fmt.Errorf("invalid release limit configuration: %w", err)
+	}
+
+	preDeleteHooks, postDeleteHooks, err := newDeletionHooks(opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deletion hook configuration: %w", err)
+	}
 
 	// Build system namespaces map
 	systemNS := make(map[string]bool)
@@ -100,13 +199,27 @@ func New(opts Options) (*Pruner, error) {
 
 	return &Pruner{
 		opts:             opts,
-		settings:         settings,
+		restGetter:       restGetter,
+		storage:          storage,
 		k8s:              k8sClient,
+		dynamic:          dynamicClient,
+		mapper:           mapper,
 		logger:           logger,
 		systemNamespaces: systemNS,
+		preDeleteHooks:   preDeleteHooks,
+		postDeleteHooks:  postDeleteHooks,
+		activitySignals:  signals,
+		branchChecker:    branchChecker,
+		state:            state,
+		trigger:          make(chan struct{}, 1),
 	}, nil
 }
 
+// ClusterName returns the name of the cluster this pruner manages.
+func (p *Pruner) ClusterName() string {
+	return p.opts.ClusterName
+}
+
 // Ready returns true after at least one successful prune cycle.
 func (p *Pruner) Ready() bool {
 	return p.ready.Load()
@@ -123,60 +236,134 @@ func (p *Pruner) CheckConnectivity(ctx context.Context) error {
 	return err
 }
 
-// RunOnce runs a single prune cycle (releases and optionally orphan namespaces).
-func (p *Pruner) RunOnce(ctx context.Context) error {
+// RunOnce runs a single prune cycle (releases, and optionally release history
+// and orphan namespaces), then follows up on namespaces it deleted earlier.
+// The cycle is traced as a prune-cycle span with a child span per step.
+//
+// The returned result is never nil; when a step fails, it holds what the
+// cycle did up to that point. A cycle whose share of failed deletions exceeds
+// MaxDeletionFailurePercent fails with ErrDeletionFailures. A cycle stopped
+// by Pause ends early without an error.
+//
+// With a state store, the pruner state is loaded before the first cycle and
+// saved after every cycle.
+func (p *Pruner) RunOnce(ctx context.Context) (_ *CycleResult, err error) {
+	p.cycleMu.Lock()
+	defer p.cycleMu.Unlock()
+
+	ctx, span := p.startSpan(ctx, "prune-cycle", attrDryRun.Bool(p.opts.DryRun))
+	defer func() { endSpan(span, err) }()
+
 	if p.opts.DryRun {
 		p.logger.Info("running in dry-run mode - nothing will be deleted")
 	}
 
+	start := time.Now()
+	result := &CycleResult{DryRun: p.opts.DryRun}
+	defer func() { p.finishCycle(ctx, start, result, err) }()
+	// A cycle stopped by Pause did not fail; it keeps what it did so far.
+	defer func() {
+		if errors.Is(err, ErrPaused) {
+			p.logger.Info("pruning paused, stopping cycle")
+			err = nil
+		}
+	}()
+
+	if err := p.loadState(ctx); err != nil {
+		return result, err
+	}
+
 	if p.hasReleasePruningFilters() {
-		if err := p.pruneReleases(ctx); err != nil {
-			return fmt.Errorf("release pruning failed: %w", err)
+		if err := p.pruneReleases(ctx, result); err != nil {
+			return result, fmt.Errorf("release pruning failed: %w", err)
+		}
+	}
+
+	if p.opts.HistoryMax > 0 {
+		if err := p.trimReleaseHistory(ctx); err != nil {
+			return result, fmt.Errorf("release history trimming failed: %w", err)
 		}
 	}
 
 	if p.opts.CleanupOrphanNamespaces {
-		if err := p.cleanupOrphanNamespaces(ctx); err != nil {
-			return fmt.Errorf("orphan namespace cleanup failed: %w", err)
+		if err := p.cleanupOrphanNamespaces(ctx, result); err != nil {
+			return result, fmt.Errorf("orphan namespace cleanup failed: %w", err)
 		}
 	}
 
-	return nil
+	if p.opts.StuckNamespaceThreshold > 0 {
+		if err := p.checkTerminatingNamespaces(ctx); err != nil {
+			return result, fmt.Errorf("terminating namespace check failed: %w", err)
+		}
+	}
+
+	return result, p.checkDeletionFailures(result)
+}
+
+// finishCycle records the outcome of a cycle and saves the pruner state,
+// even when the cycle was cancelled.
+func (p *Pruner) finishCycle(ctx context.Context, start time.Time, result *CycleResult, err error) {
+	p.recordCycle(start, time.Since(start), result, err)
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), stateSaveTimeout)
+	defer cancel()
+	if err := p.saveState(ctx); err != nil {
+		p.logger.Error("failed to save pruner state", "error", err)
+	}
 }
 
 func (p *Pruner) hasReleasePruningFilters() bool {
 	return p.opts.OlderThan > 0 ||
 		p.opts.MaxReleasesToKeep > 0 ||
+		p.opts.MaxLifetime > 0 ||
+		p.opts.DeleteFailedInstalls ||
 		p.opts.ReleaseFilter != nil ||
 		p.opts.NamespaceFilter != nil ||
 		p.opts.ReleaseExclude != nil ||
-		p.opts.NamespaceExclude != nil
+		p.opts.NamespaceExclude != nil ||
+		p.usesNamespaceSelectors() ||
+		p.opts.BranchPattern != nil ||
+		len(p.opts.Rules) > 0 ||
+		len(p.opts.Filters) > 0
 }
 
-func (p *Pruner) pruneReleases(ctx context.Context) error {
-	releases, err := p.listAllReleases(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to list releases: %w", err)
-	}
+// releasePlan is the outcome of release selection for one cycle.
+type releasePlan struct {
+	scanned    int
+	candidates int
+	skipped    []SkippedRelease
+	releases   []*releasev1.Release
+	// graph and leaving decide which emptied namespaces may be deleted.
+	graph   *referenceGraph
+	leaving map[string]bool
+}
 
-	p.logger.Info("found releases", "count", len(releases))
-	releasesScannedTotal.Add(float64(len(releases)))
+func (p *Pruner) pruneReleases(ctx context.Context, result *CycleResult) error {
+	defer p.updateQuarantineMetric()
 
-	candidates := p.filterReleases(releases)
-	p.logger.Debug("releases after filtering", "count", len(candidates))
-	toDelete := p.selectReleasesToDelete(candidates)
+	plan, err := p.planReleases(ctx)
+	if err != nil {
+		return err
+	}
+	releasesScannedTotal.WithLabelValues(p.opts.ClusterName).Add(float64(plan.scanned))
+	result.Scanned = plan.scanned
+	result.Candidates = plan.candidates
+	result.Skipped = append(result.Skipped, plan.skipped...)
 
+	toDelete := plan.releases
 	if len(toDelete) == 0 {
-		p.logger.Info("no stale Helm releases found")
 		return nil
 	}
+	if err := p.checkDeletionLimit("releases", len(toDelete)); err != nil {
+		return err
+	}
 
 	p.logger.Info("releases to delete", "count", len(toDelete))
 	affectedNamespaces := make(map[string]bool)
 
 	for i, rel := range toDelete {
-		if ctx.Err() != nil {
-			return ctx.Err()
+		if err := p.interrupted(ctx); err != nil {
+			return err
 		}
 
 		affectedNamespaces[rel.Namespace] = true
@@ -187,19 +374,11 @@ func (p *Pruner) pruneReleases(ctx context.Context) error {
 				"namespace", rel.Namespace,
 				"last_deployed", rel.Info.LastDeployed,
 				"status", rel.Info.Status)
+			result.deleted(rel)
 		} else {
-			p.logger.Info("deleting release",
-				"name", rel.Name,
-				"namespace", rel.Namespace)
-
-			if err := p.deleteRelease(ctx, rel.Name, rel.Namespace); err != nil {
-				p.logger.Error("failed to delete release",
-					"name", rel.Name,
-					"namespace", rel.Namespace,
-					"error", err)
+			if !p.pruneRelease(ctx, rel, result) {
 				continue
 			}
-			releasesDeletedTotal.Inc()
 
 			if p.opts.DeleteRateLimit > 0 && i < len(toDelete)-1 {
 				select {
@@ -213,13 +392,25 @@ func (p *Pruner) pruneReleases(ctx context.Context) error {
 
 	if !p.opts.PreserveNamespace {
 		for ns := range affectedNamespaces {
-			if ctx.Err() != nil {
-				return ctx.Err()
+			if err := p.interrupted(ctx); err != nil {
+				return err
 			}
-			if err := p.deleteNamespaceIfEmpty(ctx, ns); err != nil {
+			if refs := plan.graph.referencesTo(ns, plan.leaving); len(refs) > 0 {
+				p.logger.Info("not deleting namespace referenced from another namespace",
+					"namespace", ns,
+					"referenced_by", refs)
+				continue
+			}
+			deleted, err := p.deleteNamespaceIfEmpty(ctx, ns)
+			if err != nil {
 				p.logger.Error("failed to check/delete namespace",
 					"namespace", ns,
 					"error", err)
+				result.failedNamespace(ns, err)
+				continue
+			}
+			if deleted {
+				result.NamespacesDeleted = append(result.NamespacesDeleted, ns)
 			}
 		}
 	}
@@ -227,19 +418,213 @@ func (p *Pruner) pruneReleases(ctx context.Context) error {
 	return nil
 }
 
-func (p *Pruner) cleanupOrphanNamespaces(ctx context.Context) error {
+// pruneRelease deletes one selected release between its deletion hooks,
+// uninstalling it or, after enough failed uninstalls, force purging it. It
+// records the outcome in result and reports whether the release was deleted.
+func (p *Pruner) pruneRelease(ctx context.Context, rel *releasev1.Release, result *CycleResult) bool {
+	forcePurge := p.forcePurgeDue(rel)
+	event := p.releaseHookEvent(rel, forcePurge)
+	if err := p.runPreDeleteHooks(ctx, event); err != nil {
+		p.logger.Warn("skipping release",
+			"name", rel.Name,
+			"namespace", rel.Namespace,
+			"reason", err)
+		result.skipped(rel, err.Error())
+		return false
+	}
+
+	var purged *PurgedRelease
+	var err error
+	if forcePurge {
+		purged, err = p.forcePurgeRelease(ctx, rel)
+	} else {
+		p.logger.Info("deleting release",
+			"name", rel.Name,
+			"namespace", rel.Namespace)
+		err = p.deleteRelease(ctx, rel)
+	}
+	if err != nil {
+		p.logger.Error("failed to delete release",
+			"name", rel.Name,
+			"namespace", rel.Namespace,
+			"error", err)
+		result.failed(rel, err)
+		if f, quarantined := p.recordUninstallFailure(rel, err, time.Now()); quarantined {
+			p.quarantineRelease(ctx, rel, f)
+		}
+		return false
+	}
+
+	releasesDeletedTotal.WithLabelValues(p.opts.ClusterName).Inc()
+	result.deleted(rel)
+	if purged != nil {
+		result.Purged = append(result.Purged, *purged)
+	}
+	p.recordUninstallSuccess(rel)
+	p.runPostDeleteHooks(ctx, event)
+	return true
+}
+
+// planReleases lists and filters releases and selects the ones to delete
+// this cycle, without deleting anything.
+func (p *Pruner) planReleases(ctx context.Context) (_ *releasePlan, err error) {
+	releases, err := p.listAllReleases(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list releases: %w", err)
+	}
+
+	p.logger.Info("found releases", "count", len(releases))
+	if !p.previewing {
+		p.forgetGoneReleases(releases)
+	}
+
+	ctx, span := p.startSpan(ctx, "filter-releases", attrReleaseCount.Int(len(releases)))
+	defer func() { endSpan(span, err) }()
+
+	namespaces, err := p.listNamespaceMeta(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+	}
+
+	candidates, skipped := p.partitionReleases(releases, namespaces)
+	p.logger.Debug("releases after filtering", "count", len(candidates))
+	span.SetAttributes(attrCandidateCount.Int(len(candidates)))
+
+	activity, err := p.collectActivity(ctx, candidates)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect release activity: %w", err)
+	}
+	p.activity = p.rememberActivity(candidates, activity)
+
+	gone := make(map[*releasev1.Release]bool)
+	for _, rel := range p.releasesWithGoneBranches(ctx, candidates) {
+		gone[rel] = true
+	}
+	toDelete, kept := p.evaluateReleases(candidates, namespaces, gone)
+	skipped = append(skipped, kept...)
+
+	// Releases in quarantine or retry backoff are not deleted this cycle, so
+	// they count toward neither MaxDeletionsPerCycle nor the plan.
+	toDelete, blocked := p.dropRetryBlocked(toDelete, time.Now())
+	skipped = append(skipped, blocked...)
+
+	if len(toDelete) == 0 {
+		p.logger.Info("no stale Helm releases found")
+		return &releasePlan{scanned: len(releases), candidates: len(candidates), skipped: skipped}, nil
+	}
+
+	graph, err := p.buildReferenceGraph(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build namespace reference graph: %w", err)
+	}
+	stale := toDelete
+	toDelete, leaving := p.keepReferencedReleases(graph, toDelete)
+	span.SetAttributes(attrSelectedCount.Int(len(toDelete)))
+	for _, rel := range stale {
+		if !slices.Contains(toDelete, rel) {
+			skipped = append(skipped, SkippedRelease{Name: rel.Name, Namespace: rel.Namespace, Reason: "referenced from another namespace"})
+		}
+	}
+
+	if len(toDelete) == 0 {
+		p.logger.Info("all stale Helm releases are referenced from other namespaces")
+	}
+
+	return &releasePlan{
+		scanned:    len(releases),
+		candidates: len(candidates),
+		skipped:    skipped,
+		releases:   toDelete,
+		graph:      graph,
+		leaving:    leaving,
+	}, nil
+}
+
+func (p *Pruner) cleanupOrphanNamespaces(ctx context.Context, result *CycleResult) (err error) {
+	ctx, span := p.startSpan(ctx, "cleanup-orphan-namespaces")
+	defer func() { endSpan(span, err) }()
+
 	p.logger.Info("starting orphan namespace cleanup")
+	orphanNamespaces, err := p.planOrphanNamespaces(ctx)
+	if err != nil {
+		return err
+	}
+	span.SetAttributes(attrNamespaceCount.Int(len(orphanNamespaces)))
+
+	if len(orphanNamespaces) == 0 {
+		p.logger.Info("no orphan namespaces found")
+		return nil
+	}
+	if err := p.checkDeletionLimit("orphan namespaces", len(orphanNamespaces)); err != nil {
+		return err
+	}
+
+	p.logger.Info("orphan namespaces to delete", "count", len(orphanNamespaces))
+
+	for i, nsName := range orphanNamespaces {
+		if err := p.interrupted(ctx); err != nil {
+			return err
+		}
+
+		if p.opts.DryRun {
+			p.logger.Info("would delete orphan namespace",
+				"namespace", nsName)
+			result.NamespacesDeleted = append(result.NamespacesDeleted, nsName)
+		} else {
+			p.logger.Info("deleting orphan namespace",
+				"namespace", nsName)
+
+			err := p.deleteNamespace(ctx, nsName)
+			if errors.Is(err, ErrDeletionVetoed) {
+				p.logger.Warn("not deleting orphan namespace",
+					"namespace", nsName,
+					"reason", err)
+				continue
+			}
+			if err != nil {
+				p.logger.Error("failed to delete orphan namespace",
+					"namespace", nsName,
+					"error", err)
+				result.failedNamespace(nsName, err)
+				continue
+			}
+			result.NamespacesDeleted = append(result.NamespacesDeleted, nsName)
+
+			if p.opts.DeleteRateLimit > 0 && i < len(orphanNamespaces)-1 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(p.opts.DeleteRateLimit):
+				}
+			}
+		}
+	}
+
+	p.logger.Info("orphan namespace cleanup complete", "count", len(orphanNamespaces))
+	return nil
+}
+
+// planOrphanNamespaces returns the orphan namespaces to delete this cycle,
+// without deleting anything. Unless previewing, it updates the idle tracking
+// and the blocked namespaces metric.
+func (p *Pruner) planOrphanNamespaces(ctx context.Context) ([]string, error) {
 	namespaces, err := p.k8s.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to list namespaces: %w", err)
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
 	}
 
 	p.logger.Debug("found namespaces", "count", len(namespaces.Items))
+	if !p.previewing {
+		orphanNamespacesBlocked.DeletePartialMatch(prometheus.Labels{clusterLabel: p.opts.ClusterName})
+		p.forgetMissingOrphans(namespaces.Items)
+	}
+
+	now := time.Now()
 
 	var orphanNamespaces []string
 	for _, ns := range namespaces.Items {
 		if ctx.Err() != nil {
-			return ctx.Err()
+			return nil, ctx.Err()
 		}
 
 		nsName := ns.Name
@@ -266,6 +651,32 @@ func (p *Pruner) cleanupOrphanNamespaces(ctx context.Context) error {
 			}
 		}
 
+		if p.opts.OrphanNamespaceSelector != nil && !p.opts.OrphanNamespaceSelector.Matches(labels.Set(ns.Labels)) {
+			p.logger.Debug("skipping namespace (doesn't match orphan selector)",
+				"namespace", nsName)
+			continue
+		}
+
+		if selectorMatches(p.opts.OrphanNamespaceExcludeSelector, ns.Labels) {
+			p.logger.Debug("skipping namespace (matches orphan exclude selector)",
+				"namespace", nsName)
+			continue
+		}
+
+		if selectorMatches(p.opts.NamespaceExcludeAnnotations, ns.Annotations) {
+			p.logger.Debug("skipping namespace (matches namespace annotation exclude)",
+				"namespace", nsName)
+			continue
+		}
+
+		if age := now.Sub(ns.CreationTimestamp.Time); p.opts.OrphanMinAge > 0 && age < p.opts.OrphanMinAge {
+			p.logger.Debug("skipping namespace (younger than orphan minimum age)",
+				"namespace", nsName,
+				"age", age,
+				"min_age", p.opts.OrphanMinAge)
+			continue
+		}
+
 		hasReleases, err := p.namespaceHasReleases(ctx, nsName)
 		if err != nil {
 			p.logger.Error("failed to check releases in namespace",
@@ -277,51 +688,56 @@ func (p *Pruner) cleanupOrphanNamespaces(ctx context.Context) error {
 		if hasReleases {
 			p.logger.Debug("namespace has releases, not orphaned",
 				"namespace", nsName)
+			if !p.previewing {
+				p.markOrphanActive(nsName)
+			}
 			continue
 		}
 
-		orphanNamespaces = append(orphanNamespaces, nsName)
-	}
-
-	if len(orphanNamespaces) == 0 {
-		p.logger.Info("no orphan namespaces found")
-		return nil
-	}
-
-	p.logger.Info("orphan namespaces to delete", "count", len(orphanNamespaces))
-
-	for i, nsName := range orphanNamespaces {
-		if ctx.Err() != nil {
-			return ctx.Err()
+		if since, idle := p.orphanIdle(nsName, now); !idle {
+			p.logger.Debug("skipping namespace (orphan idle window not elapsed)",
+				"namespace", nsName,
+				"orphan_since", since,
+				"idle_time", p.opts.OrphanIdleTime)
+			continue
 		}
 
-		if p.opts.DryRun {
-			p.logger.Info("would delete orphan namespace",
-				"namespace", nsName)
-		} else {
-			p.logger.Info("deleting orphan namespace",
-				"namespace", nsName)
-
-			if err := p.k8s.CoreV1().Namespaces().Delete(ctx, nsName, metav1.DeleteOptions{}); err != nil {
-				p.logger.Error("failed to delete orphan namespace",
-					"namespace", nsName,
-					"error", err)
-				continue
-			}
-			namespacesDeletedTotal.Inc()
+		blockers, err := p.namespaceBlockers(ctx, nsName)
+		if err != nil {
+			p.logger.Error("failed to check contents of namespace",
+				"namespace", nsName,
+				"error", err)
+			continue
+		}
 
-			if p.opts.DeleteRateLimit > 0 && i < len(orphanNamespaces)-1 {
-				select {
-				case <-ctx.Done():
-					return ctx.Err()
-				case <-time.After(p.opts.DeleteRateLimit):
+		if len(blockers) > 0 {
+			p.logger.Info("orphan namespace blocked by its contents",
+				"namespace", nsName,
+				"blocked_by", blockers)
+			if !p.previewing {
+				reasons := make(map[string]bool)
+				for _, b := range blockers {
+					if !reasons[b.Reason] {
+						reasons[b.Reason] = true
+						orphanNamespacesBlocked.WithLabelValues(p.opts.ClusterName, b.Reason).Inc()
+					}
 				}
 			}
+			continue
 		}
+
+		orphanNamespaces = append(orphanNamespaces, nsName)
 	}
 
-	p.logger.Info("orphan namespace cleanup complete", "count", len(orphanNamespaces))
-	return nil
+	if len(orphanNamespaces) > 0 {
+		graph, err := p.buildReferenceGraph(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build namespace reference graph: %w", err)
+		}
+		orphanNamespaces = p.keepReferencedNamespaces(graph, orphanNamespaces)
+	}
+
+	return orphanNamespaces, nil
 }
 
 func (p *Pruner) namespaceHasReleases(ctx context.Context, namespace string) (bool, error) {
@@ -329,14 +745,14 @@ func (p *Pruner) namespaceHasReleases(ctx context.Context, namespace string) (bo
 		return false, ctx.Err()
 	}
 
-	actionConfig := new(action.Configuration)
-	if err := actionConfig.Init(p.settings.RESTClientGetter(), namespace, os.Getenv("HELM_DRIVER")); err != nil {
+	actionConfig, err := p.newActionConfig(namespace)
+	if err != nil {
 		return false, err
 	}
 
-	listAction := action.NewList(actionConfig)
-	listAction.All = true
-	releases, err := listAction.Run()
+	// Read the release records directly: any record, in any state, means
+	// the namespace is still in use by Helm.
+	releases, err := actionConfig.Releases.ListReleases()
 	if err != nil {
 		return false, err
 	}
@@ -376,6 +792,7 @@ func (p *Pruner) RunDaemon(ctx context.Context) error {
 
 	ticker := time.NewTicker(p.opts.Interval)
 	defer ticker.Stop()
+	p.setNextRun(time.Now().Add(p.opts.Interval))
 
 	for {
 		select {
@@ -383,6 +800,14 @@ func (p *Pruner) RunDaemon(ctx context.Context) error {
 			p.logger.Info("shutting down daemon")
 			return ctx.Err()
 		case <-ticker.C:
+			p.setNextRun(time.Now().Add(p.opts.Interval))
+			if p.Paused() {
+				p.logger.Info("pruning paused, skipping cycle")
+				continue
+			}
+			p.runCycleWithBackoff(ctx)
+		case <-p.trigger:
+			p.logger.Info("prune cycle triggered")
 			p.runCycleWithBackoff(ctx)
 		}
 	}
@@ -393,17 +818,16 @@ func (p *Pruner) runCycleWithBackoff(ctx context.Context) {
 	defer p.initialized.Store(true)
 
 	start := time.Now()
-	err := p.RunOnce(ctx)
+	result, err := p.RunOnce(ctx)
 	duration := time.Since(start)
-	pruneCycleDuration.Observe(duration.Seconds())
+	pruneCycleDuration.WithLabelValues(p.opts.ClusterName).Observe(duration.Seconds())
 
 	if err != nil {
 		p.mu.Lock()
-		p.consecutiveFailures++
 		failures := p.consecutiveFailures
 		p.mu.Unlock()
 
-		pruneCycleFailuresTotal.Inc()
+		pruneCycleFailuresTotal.WithLabelValues(p.opts.ClusterName).Inc()
 		p.logger.Error("prune cycle failed",
 			"error", err,
 			"duration", duration,
@@ -422,23 +846,24 @@ func (p *Pruner) runCycleWithBackoff(ctx context.Context) {
 		return
 	}
 
-	p.mu.Lock()
-	p.consecutiveFailures = 0
-	p.mu.Unlock()
-
-	p.ready.Store(true)
 	p.logger.Info("prune cycle complete",
 		"duration", duration,
+		"releases_deleted", len(result.Deleted),
+		"namespaces_deleted", len(result.NamespacesDeleted),
+		"failed", len(result.Failed),
 		"next_run", time.Now().Add(p.opts.Interval))
 }
 
-func (p *Pruner) listAllReleases(ctx context.Context) ([]*releasev1.Release, error) {
+func (p *Pruner) listAllReleases(ctx context.Context) (_ []*releasev1.Release, err error) {
 	if ctx.Err() != nil {
 		return nil, ctx.Err()
 	}
 
-	actionConfig := new(action.Configuration)
-	if err := actionConfig.Init(p.settings.RESTClientGetter(), "", os.Getenv("HELM_DRIVER")); err != nil {
+	_, span := p.startSpan(ctx, "list-releases")
+	defer func() { endSpan(span, err) }()
+
+	actionConfig, err := p.newActionConfig("")
+	if err != nil {
 		return nil, err
 	}
 
@@ -457,171 +882,157 @@ func (p *Pruner) listAllReleases(ctx context.Context) ([]*releasev1.Release, err
 			releases = append(releases, rel)
 		}
 	}
+	span.SetAttributes(attrReleaseCount.Int(len(releases)))
 
 	return releases, nil
 }
 
-func (p *Pruner) filterReleases(releases []*releasev1.Release) []*releasev1.Release {
-	var filtered []*releasev1.Release
-
-	for _, rel := range releases {
-		if p.opts.NamespaceFilter != nil {
-			if !p.opts.NamespaceFilter.MatchString(rel.Namespace) {
-				p.logger.Debug("skipping release (namespace filter)",
-					"name", rel.Name,
-					"namespace", rel.Namespace)
-				continue
-			}
-		}
-
-		if p.opts.NamespaceExclude != nil {
-			if p.opts.NamespaceExclude.MatchString(rel.Namespace) {
-				p.logger.Debug("skipping release (namespace exclude)",
-					"name", rel.Name,
-					"namespace", rel.Namespace)
-				continue
-			}
-		}
-
-		if p.opts.ReleaseFilter != nil {
-			if !p.opts.ReleaseFilter.MatchString(rel.Name) {
-				p.logger.Debug("skipping release (release filter)",
-					"name", rel.Name,
-					"namespace", rel.Namespace)
-				continue
-			}
-		}
-
-		if p.opts.ReleaseExclude != nil {
-			if p.opts.ReleaseExclude.MatchString(rel.Name) {
-				p.logger.Debug("skipping release (release exclude)",
-					"name", rel.Name,
-					"namespace", rel.Namespace)
-				continue
-			}
-		}
-
-		filtered = append(filtered, rel)
-	}
-
+// filterReleases returns the releases that pass the name, namespace and
+// namespace selector filters. namespaces holds namespace metadata by name and
+// is only consulted when namespace selectors are configured.
+func (p *Pruner) filterReleases(releases []*releasev1.Release, namespaces map[string]metav1.ObjectMeta) []*releasev1.Release {
+	filtered, _ := p.partitionReleases(releases, namespaces)
 	return filtered
 }
 
-func (p *Pruner) selectReleasesToDelete(releases []*releasev1.Release) []*releasev1.Release {
-	if len(releases) == 0 {
-		return nil
-	}
+// partitionReleases splits releases into those that pass the filters of
+// filterReleases and those that are skipped, with the reason for each.
+func (p *Pruner) partitionReleases(releases []*releasev1.Release, namespaces map[string]metav1.ObjectMeta) ([]*releasev1.Release, []SkippedRelease) {
+	var (
+		filtered []*releasev1.Release
+		skipped  []SkippedRelease
+	)
 
-	sorted := make([]*releasev1.Release, len(releases))
-	copy(sorted, releases)
-	sort.Slice(sorted, func(i, j int) bool {
-		return sorted[i].Info.LastDeployed.After(sorted[j].Info.LastDeployed)
-	})
-
-	toDeleteMap := make(map[*releasev1.Release]bool)
-	now := time.Now()
-
-	if p.opts.MaxReleasesToKeep > 0 && len(sorted) > p.opts.MaxReleasesToKeep {
-		for i := p.opts.MaxReleasesToKeep; i < len(sorted); i++ {
-			rel := sorted[i]
-			p.logger.Debug("release exceeds global max count",
+	for _, rel := range releases {
+		if reason := p.skipReason(rel, namespaces); reason != "" {
+			p.logger.Debug("skipping release ("+reason+")",
 				"name", rel.Name,
-				"namespace", rel.Namespace,
-				"position", i,
-				"max", p.opts.MaxReleasesToKeep)
-			toDeleteMap[rel] = true
+				"namespace", rel.Namespace)
+			skipped = append(skipped, SkippedRelease{Name: rel.Name, Namespace: rel.Namespace, Reason: reason, Filtered: true})
+			continue
 		}
+		filtered = append(filtered, rel)
 	}
 
-	if p.opts.OlderThan > 0 {
-		for _, rel := range releases {
-			if toDeleteMap[rel] {
-				continue // Already marked for deletion
-			}
-			age := now.Sub(rel.Info.LastDeployed)
-			if age > p.opts.OlderThan {
-				p.logger.Debug("release exceeds age limit",
-					"name", rel.Name,
-					"namespace", rel.Namespace,
-					"age", age,
-					"limit", p.opts.OlderThan)
-				toDeleteMap[rel] = true
-			}
-		}
-	}
+	return filtered, skipped
+}
 
-	toDelete := make([]*releasev1.Release, 0, len(toDeleteMap))
-	for rel := range toDeleteMap {
-		toDelete = append(toDelete, rel)
+// skipReason returns which filter rule keeps a release out of the pruning
+// scope, or "" if none does.
+func (p *Pruner) skipReason(rel *releasev1.Release, namespaces map[string]metav1.ObjectMeta) string {
+	rc := &RuleContext{Now: time.Now(), Namespace: namespaces[rel.Namespace], LastActive: rel.Info.LastDeployed}
+	for _, rule := range p.filterRules() {
+		if v := rule.Evaluate(rc, rel); v.Decision == Keep {
+			return v.Reason
+		}
 	}
+	return ""
+}
 
+// selectReleasesToDelete returns the candidate releases that the selection
+// rules delete.
+func (p *Pruner) selectReleasesToDelete(releases []*releasev1.Release) []*releasev1.Release {
+	toDelete, _ := p.evaluateReleases(releases, nil, nil)
 	return toDelete
 }
 
-func (p *Pruner) deleteRelease(ctx context.Context, name, namespace string) error {
+// deleteRelease uninstalls a release, traced as a delete-release span that
+// covers the uninstall hooks and, with a wait strategy, the wait.
+func (p *Pruner) deleteRelease(ctx context.Context, rel *releasev1.Release) (err error) {
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
 
-	actionConfig := new(action.Configuration)
+	_, span := p.startSpan(ctx, "delete-release", releaseAttributes(rel)...)
+	defer func() { endSpan(span, err) }()
 
-	if err := actionConfig.Init(p.settings.RESTClientGetter(), namespace, os.Getenv("HELM_DRIVER")); err != nil {
+	actionConfig, err := p.newActionConfig(rel.Namespace)
+	if err != nil {
 		return err
 	}
 
 	uninstall := action.NewUninstall(actionConfig)
-	uninstall.WaitStrategy = kube.LegacyStrategy
-	uninstall.Timeout = 10 * time.Minute
-	_, err := uninstall.Run(name)
-	if err != nil {
-		return fmt.Errorf("uninstall %s/%s: %w", namespace, name, err)
+	p.opts.Uninstall.apply(uninstall)
+	span.SetAttributes(
+		attrWaitStrategy.String(string(uninstall.WaitStrategy)),
+		attrTimeout.String(uninstall.Timeout.String()))
+	if _, err := uninstall.Run(rel.Name); err != nil {
+		return fmt.Errorf("uninstall %s/%s: %w", rel.Namespace, rel.Name, err)
 	}
 	return nil
 }
 
-func (p *Pruner) deleteNamespaceIfEmpty(ctx context.Context, namespace string) error {
+// apply copies the uninstall settings onto a Helm uninstall action,
+// filling in the pruner's defaults for unset fields.
+func (o UninstallOptions) apply(u *action.Uninstall) {
+	u.WaitStrategy = o.WaitStrategy
+	if u.WaitStrategy == "" {
+		u.WaitStrategy = kube.LegacyStrategy
+	}
+	u.Timeout = o.Timeout
+	if u.Timeout == 0 {
+		u.Timeout = defaultUninstallTimeout
+	}
+	u.DisableHooks = o.DisableHooks
+	u.KeepHistory = o.KeepHistory
+	u.DeletionPropagation = o.DeletionPropagation
+	u.IgnoreNotFound = o.IgnoreNotFound
+}
+
+// deleteNamespaceIfEmpty deletes a namespace that has no releases left and
+// reports whether it was deleted (or would be, in dry-run mode).
+func (p *Pruner) deleteNamespaceIfEmpty(ctx context.Context, namespace string) (_ bool, err error) {
+	ctx, span := p.startSpan(ctx, "delete-namespace-if-empty", attrNamespace.String(namespace))
+	defer func() { endSpan(span, err) }()
+
 	if p.systemNamespaces[namespace] {
 		p.logger.Debug("not deleting system namespace",
 			"namespace", namespace)
-		return nil
+		return false, nil
+	}
+
+	if p.opts.NamespaceExcludeSelector != nil || p.opts.NamespaceExcludeAnnotations != nil {
+		ns, err := p.k8s.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to get namespace: %w", err)
+		}
+		if reason := p.namespaceExcluded(ns.ObjectMeta); reason != "" {
+			p.logger.Debug("not deleting protected namespace",
+				"namespace", namespace,
+				"protected_by", reason)
+			return false, nil
+		}
 	}
 
 	hasReleases, err := p.namespaceHasReleases(ctx, namespace)
 	if err != nil {
-		return fmt.Errorf("failed to check releases in namespace: %w", err)
+		return false, fmt.Errorf("failed to check releases in namespace: %w", err)
 	}
 
 	if hasReleases {
 		p.logger.Debug("namespace still has releases, not deleting",
 			"namespace", namespace)
-		return nil
+		return false, nil
 	}
 
 	if p.opts.DryRun {
 		p.logger.Info("would delete empty namespace", "namespace", namespace)
-		return nil
+		return true, nil
 	}
 
 	p.logger.Info("deleting empty namespace", "namespace", namespace)
-	if err := p.k8s.CoreV1().Namespaces().Delete(ctx, namespace, metav1.DeleteOptions{}); err != nil {
-		return err
+	err = p.deleteNamespace(ctx, namespace)
+	if errors.Is(err, ErrDeletionVetoed) {
+		p.logger.Warn("not deleting empty namespace",
+			"namespace", namespace,
+			"reason", err)
+		return false, nil
 	}
-	namespacesDeletedTotal.Inc()
-	return nil
-}
-
-func newKubernetesClient() (kubernetes.Interface, error) {
-	config, err := rest.InClusterConfig()
 	if err != nil {
-		loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
-		configOverrides := &clientcmd.ConfigOverrides{}
-		kubeConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides)
-
-		config, err = kubeConfig.ClientConfig()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get kubernetes config: %w", err)
-		}
+		return false, err
 	}
-
-	return kubernetes.NewForConfig(config)
+	return true, nil
 }
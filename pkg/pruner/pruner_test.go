@@ -8,6 +8,8 @@ import (
 	"testing"
 	"time"
 
+	"helm.sh/helm/v4/pkg/action"
+	"helm.sh/helm/v4/pkg/kube"
 	"helm.sh/helm/v4/pkg/release/common"
 	releasev1 "helm.sh/helm/v4/pkg/release/v1"
 )
@@ -101,7 +103,7 @@ func TestFilterReleases(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			p := newTestPruner(tt.opts)
-			filtered := p.filterReleases(releases)
+			filtered := p.filterReleases(releases, nil)
 
 			if len(filtered) != tt.expectedCount {
 				t.Errorf("expected %d releases, got %d", tt.expectedCount, len(filtered))
@@ -541,3 +543,110 @@ func TestHasReleasePruningFilters(t *testing.T) {
 		})
 	}
 }
+
+func TestParseWaitStrategy(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected kube.WaitStrategy
+		wantErr  bool
+	}{
+		{"", kube.LegacyStrategy, false},
+		{"legacy", kube.LegacyStrategy, false},
+		{"watcher", kube.StatusWatcherStrategy, false},
+		{"none", kube.HookOnlyStrategy, false},
+		{"hookOnly", "", true},
+		{"bogus", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseWaitStrategy(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseWaitStrategy(%q) expected error, got nil", tt.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseWaitStrategy(%q) unexpected error: %v", tt.input, err)
+			}
+			if got != tt.expected {
+				t.Errorf("ParseWaitStrategy(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestValidatePropagation(t *testing.T) {
+	for _, valid := range []string{"", "background", "foreground", "orphan"} {
+		if err := ValidatePropagation(valid); err != nil {
+			t.Errorf("ValidatePropagation(%q) unexpected error: %v", valid, err)
+		}
+	}
+	if err := ValidatePropagation("cascade"); err == nil {
+		t.Error("ValidatePropagation(\"cascade\") expected error, got nil")
+	}
+}
+
+func TestUninstallOptionsApply(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		u := &action.Uninstall{}
+		UninstallOptions{}.apply(u)
+
+		if u.WaitStrategy != kube.LegacyStrategy {
+			t.Errorf("WaitStrategy = %q, want %q", u.WaitStrategy, kube.LegacyStrategy)
+		}
+		if u.Timeout != 10*time.Minute {
+			t.Errorf("Timeout = %v, want 10m", u.Timeout)
+		}
+		if u.DisableHooks || u.KeepHistory || u.IgnoreNotFound {
+			t.Error("expected boolean settings to default to false")
+		}
+	})
+
+	t.Run("overrides", func(t *testing.T) {
+		u := &action.Uninstall{}
+		UninstallOptions{
+			WaitStrategy:        kube.HookOnlyStrategy,
+			Timeout:             time.Minute,
+			DisableHooks:        true,
+			KeepHistory:         true,
+			DeletionPropagation: PropagationForeground,
+			IgnoreNotFound:      true,
+		}.apply(u)
+
+		if u.WaitStrategy != kube.HookOnlyStrategy {
+			t.Errorf("WaitStrategy = %q, want %q", u.WaitStrategy, kube.HookOnlyStrategy)
+		}
+		if u.Timeout != time.Minute {
+			t.Errorf("Timeout = %v, want 1m", u.Timeout)
+		}
+		if !u.DisableHooks || !u.KeepHistory || !u.IgnoreNotFound {
+			t.Error("expected boolean settings to be copied")
+		}
+		if u.DeletionPropagation != PropagationForeground {
+			t.Errorf("DeletionPropagation = %q, want %q", u.DeletionPropagation, PropagationForeground)
+		}
+	})
+}
+
+func TestFilterReleases_KeepHistorySkipsUninstalled(t *testing.T) {
+	now := time.Now()
+	uninstalled := mockRelease("gone", "feature-gone", now)
+	uninstalled.Info.Status = common.StatusUninstalled
+	releases := []*releasev1.Release{
+		mockRelease("live", "feature-live", now),
+		uninstalled,
+	}
+
+	p := newTestPruner(Options{})
+	if got := len(p.filterReleases(releases, nil)); got != 2 {
+		t.Errorf("without KeepHistory expected 2 releases, got %d", got)
+	}
+
+	p = newTestPruner(Options{Uninstall: UninstallOptions{KeepHistory: true}})
+	filtered := p.filterReleases(releases, nil)
+	if len(filtered) != 1 || filtered[0].Name != "live" {
+		t.Errorf("with KeepHistory expected only 'live', got %v", filtered)
+	}
+}
@@ -0,0 +1,275 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"helm.sh/helm/v4/pkg/kube"
+	"helm.sh/helm/v4/pkg/release/common"
+	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+	releaseutil "helm.sh/helm/v4/pkg/release/v1/util"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+)
+
+// releaseForcePurgedReason is the reason of the event emitted when a
+// release is force purged.
+const releaseForcePurgedReason = "ReleaseForcePurged"
+
+// PurgedRelease describes a release that was force purged after its
+// uninstall kept failing.
+type PurgedRelease struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// Deleted is the number of release resources deleted, and Revisions the
+	// number of Helm storage records removed.
+	Deleted   int             `json:"deleted"`
+	Revisions int             `json:"revisions"`
+	Skipped   []SkippedObject `json:"skipped,omitempty"`
+}
+
+// SkippedObject is a release resource that a force purge left in place.
+type SkippedObject struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name,omitempty"`
+	Reason     string `json:"reason"`
+}
+
+// forcePurgeDue reports whether the uninstall of rel failed often enough
+// to force purge it instead.
+func (p *Pruner) forcePurgeDue(rel *releasev1.Release) bool {
+	if p.opts.ForcePurgeAfter <= 0 {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f := p.releaseFailures[releaseKey(rel.Namespace, rel.Name)]
+	return f != nil && f.Revision == rel.Version && f.Failures >= p.opts.ForcePurgeAfter
+}
+
+// forcePurgeRelease removes a release without Helm's uninstall: it deletes
+// the resources of the release manifest that the cluster still serves,
+// skipping unknown kinds and resources Helm is told to keep, then deletes
+// the release's storage records or, with Uninstall.KeepHistory, marks the
+// release uninstalled like helm uninstall --keep-history. Hooks are not
+// run. Resources that fail to delete for another reason fail the purge,
+// leaving the records in place for the next attempt.
+func (p *Pruner) forcePurgeRelease(ctx context.Context, rel *releasev1.Release) (_ *PurgedRelease, err error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	ctx, span := p.startSpan(ctx, "force-purge-release", releaseAttributes(rel)...)
+	defer func() { endSpan(span, err) }()
+
+	p.logger.Warn("force purging release after repeated uninstall failures",
+		"name", rel.Name,
+		"namespace", rel.Namespace)
+
+	purged := &PurgedRelease{Name: rel.Name, Namespace: rel.Namespace}
+	objects, invalid := parseManifest(rel.Manifest)
+	purged.Skipped = append(purged.Skipped, invalid...)
+
+	propagation := deletionPropagation(p.opts.Uninstall.DeletionPropagation)
+	// Delete in reverse manifest order, dependents before what they use.
+	for i := len(objects) - 1; i >= 0; i-- {
+		obj := objects[i]
+		deleted, skipped, err := p.purgeObject(ctx, rel, obj, propagation)
+		if err != nil {
+			return nil, fmt.Errorf("force purge %s/%s: %w", rel.Namespace, rel.Name, err)
+		}
+		if skipped != nil {
+			p.logger.Warn("force purge skipped object",
+				"name", rel.Name,
+				"namespace", rel.Namespace,
+				"object", fmt.Sprintf("%s/%s", skipped.Kind, skipped.Name),
+				"object_namespace", skipped.Namespace,
+				"api_version", skipped.APIVersion,
+				"reason", skipped.Reason)
+			purged.Skipped = append(purged.Skipped, *skipped)
+		}
+		if deleted {
+			purged.Deleted++
+		}
+	}
+
+	if err := p.purgeReleaseRecords(rel, purged); err != nil {
+		return nil, err
+	}
+
+	releasesForcePurged.WithLabelValues(p.opts.ClusterName).Inc()
+	p.logger.Warn("release force purged",
+		"name", rel.Name,
+		"namespace", rel.Namespace,
+		"deleted_objects", purged.Deleted,
+		"skipped_objects", len(purged.Skipped),
+		"revisions", purged.Revisions)
+
+	message := fmt.Sprintf("Helm release %s was force purged after repeated uninstall failures: %d resources deleted, %d skipped, %d revisions removed",
+		rel.Name, purged.Deleted, len(purged.Skipped), purged.Revisions)
+	if p.opts.Uninstall.KeepHistory {
+		message = fmt.Sprintf("Helm release %s was force purged after repeated uninstall failures: %d resources deleted, %d skipped, history kept",
+			rel.Name, purged.Deleted, len(purged.Skipped))
+	}
+	if err := p.recordReleaseEvent(ctx, rel, corev1.EventTypeWarning, releaseForcePurgedReason, message); err != nil {
+		p.logger.Error("failed to record force purge event",
+			"name", rel.Name,
+			"namespace", rel.Namespace,
+			"error", err)
+	}
+	return purged, nil
+}
+
+// purgeReleaseRecords removes the storage records of a force purged release
+// and counts them in purged. With Uninstall.KeepHistory, it keeps them and
+// marks the purged revision uninstalled instead.
+func (p *Pruner) purgeReleaseRecords(rel *releasev1.Release, purged *PurgedRelease) error {
+	actionConfig, err := p.newActionConfig(rel.Namespace)
+	if err != nil {
+		return err
+	}
+
+	if p.opts.Uninstall.KeepHistory {
+		r, err := actionConfig.Releases.Get(rel.Name, rel.Version)
+		if err != nil {
+			return fmt.Errorf("get %s/%s: %w", rel.Namespace, rel.Name, err)
+		}
+		rev, ok := r.(*releasev1.Release)
+		if !ok {
+			return fmt.Errorf("get %s/%s: unexpected release type %T", rel.Namespace, rel.Name, r)
+		}
+		rev.Info.Status = common.StatusUninstalled
+		rev.Info.Deleted = time.Now()
+		rev.Info.Description = "Force purged after repeated uninstall failures"
+		if err := actionConfig.Releases.Update(rev); err != nil {
+			return fmt.Errorf("mark %s/%s uninstalled: %w", rel.Namespace, rel.Name, err)
+		}
+		return nil
+	}
+
+	history, err := actionConfig.Releases.History(rel.Name)
+	if err != nil {
+		return fmt.Errorf("history %s/%s: %w", rel.Namespace, rel.Name, err)
+	}
+	for _, r := range history {
+		rev, ok := r.(*releasev1.Release)
+		if !ok {
+			continue
+		}
+		if _, err := actionConfig.Releases.Delete(rel.Name, rev.Version); err != nil {
+			return fmt.Errorf("delete revision %d of %s/%s: %w", rev.Version, rel.Namespace, rel.Name, err)
+		}
+		purged.Revisions++
+	}
+	return nil
+}
+
+// purgeObject deletes one resource of a release manifest. It reports
+// whether the resource was deleted, or why it was skipped. A resource that
+// is already gone is neither.
+func (p *Pruner) purgeObject(ctx context.Context, rel *releasev1.Release, obj *unstructured.Unstructured, propagation metav1.DeletionPropagation) (bool, *SkippedObject, error) {
+	gvk := obj.GroupVersionKind()
+	skip := func(namespace, reason string) (bool, *SkippedObject, error) {
+		return false, &SkippedObject{
+			APIVersion: obj.GetAPIVersion(),
+			Kind:       gvk.Kind,
+			Namespace:  namespace,
+			Name:       obj.GetName(),
+			Reason:     reason,
+		}, nil
+	}
+
+	namespace := obj.GetNamespace()
+	mapping, err := p.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		if namespace == "" {
+			namespace = rel.Namespace
+		}
+		return skip(namespace, "kind not served by the cluster")
+	}
+	if err != nil {
+		return false, nil, fmt.Errorf("map %s: %w", gvk, err)
+	}
+
+	var client dynamic.ResourceInterface = p.dynamic.Resource(mapping.Resource)
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		if namespace == "" {
+			namespace = rel.Namespace
+		}
+		client = p.dynamic.Resource(mapping.Resource).Namespace(namespace)
+	} else {
+		namespace = ""
+	}
+
+	if obj.GetAnnotations()[kube.ResourcePolicyAnno] == kube.KeepPolicy {
+		return skip(namespace, kube.ResourcePolicyAnno+": "+kube.KeepPolicy)
+	}
+
+	err = client.Delete(ctx, obj.GetName(), metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if apierrors.IsNotFound(err) {
+		return false, nil, nil
+	}
+	if err != nil {
+		return false, nil, fmt.Errorf("delete %s %s: %w", mapping.Resource.GroupResource(), obj.GetName(), err)
+	}
+	return true, nil, nil
+}
+
+// parseManifest decodes the documents of a release manifest in order.
+// Documents that cannot be decoded are returned as skipped objects.
+func parseManifest(manifest string) ([]*unstructured.Unstructured, []SkippedObject) {
+	docs := releaseutil.SplitManifests(manifest)
+	keys := make([]string, 0, len(docs))
+	for key := range docs {
+		keys = append(keys, key)
+	}
+	sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+
+	var objects []*unstructured.Unstructured
+	var invalid []SkippedObject
+	for _, key := range keys {
+		content := map[string]any{}
+		if err := yaml.Unmarshal([]byte(docs[key]), &content); err != nil {
+			invalid = append(invalid, SkippedObject{Reason: "invalid manifest document: " + err.Error()})
+			continue
+		}
+		if len(content) == 0 {
+			continue
+		}
+		obj := &unstructured.Unstructured{Object: content}
+		if obj.GetKind() == "" || obj.GetName() == "" {
+			invalid = append(invalid, SkippedObject{
+				APIVersion: obj.GetAPIVersion(),
+				Kind:       obj.GetKind(),
+				Name:       obj.GetName(),
+				Reason:     "manifest document without kind or name",
+			})
+			continue
+		}
+		objects = append(objects, obj)
+	}
+	return objects, invalid
+}
+
+// deletionPropagation converts an UninstallOptions.DeletionPropagation value
+// to the API's propagation policy.
+func deletionPropagation(s string) metav1.DeletionPropagation {
+	switch s {
+	case PropagationForeground:
+		return metav1.DeletePropagationForeground
+	case PropagationOrphan:
+		return metav1.DeletePropagationOrphan
+	default:
+		return metav1.DeletePropagationBackground
+	}
+}
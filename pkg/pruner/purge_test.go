@@ -0,0 +1,211 @@
+package pruner
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"helm.sh/helm/v4/pkg/release/common"
+	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var configMapGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+const purgeManifest = `---
+# Source: web/templates/config.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web-config
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  annotations:
+    helm.sh/resource-policy: keep
+---
+apiVersion: example.com/v1
+kind: Gadget
+metadata:
+  name: web-gadget
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: web-widget
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+`
+
+// storePurgeRelease stores two revisions of a release with purgeManifest.
+func storePurgeRelease(t *testing.T, p *Pruner) *releasev1.Release {
+	t.Helper()
+	cfg, err := p.newActionConfig("feature-a")
+	if err != nil {
+		t.Fatalf("newActionConfig: %v", err)
+	}
+	var rel *releasev1.Release
+	for version := 1; version <= 2; version++ {
+		rel = mockRelease("web", "feature-a", time.Now().Add(-48*time.Hour))
+		rel.Version = version
+		rel.Manifest = purgeManifest
+		if version == 1 {
+			rel.Info.Status = common.StatusSuperseded
+		}
+		if err := cfg.Releases.Create(rel); err != nil {
+			t.Fatalf("failed to store revision %d: %v", version, err)
+		}
+	}
+	return rel
+}
+
+func TestParseManifest(t *testing.T) {
+	objects, invalid := parseManifest(purgeManifest + "---\nkind: [unterminated\n---\napiVersion: v1\nkind: Secret\n")
+
+	var names []string
+	for _, obj := range objects {
+		names = append(names, obj.GetKind()+"/"+obj.GetName())
+	}
+	want := []string{"ConfigMap/web-config", "Service/web", "Gadget/web-gadget", "Widget/web-widget", "Deployment/web"}
+	if len(names) != len(want) {
+		t.Fatalf("parsed objects = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("parsed objects = %v, want %v", names, want)
+			break
+		}
+	}
+	if len(invalid) != 2 {
+		t.Errorf("expected the malformed and the nameless documents to be reported, got %+v", invalid)
+	}
+}
+
+func TestForcePurgeDue(t *testing.T) {
+	now := time.Now()
+	p := newTestPruner(Options{ForcePurgeAfter: 2})
+	rel := mockRelease("web", "feature-a", now)
+
+	p.recordUninstallFailure(rel, errors.New("boom"), now)
+	if p.forcePurgeDue(rel) {
+		t.Error("expected no force purge after one failure")
+	}
+	p.recordUninstallFailure(rel, errors.New("boom"), now)
+	if !p.forcePurgeDue(rel) {
+		t.Error("expected a force purge after two failures")
+	}
+
+	p.opts.ForcePurgeAfter = 0
+	if p.forcePurgeDue(rel) {
+		t.Error("expected no force purge when disabled")
+	}
+}
+
+func TestForcePurgeRelease(t *testing.T) {
+	ctx := context.Background()
+	p := newOrphanPruner(t, Options{})
+	p.dynamic = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(),
+		newObject(configMapGVR, "ConfigMap", "feature-a", "web-config"),
+		newObject(configMapGVR, "ConfigMap", "feature-a", "unrelated"),
+		newObject(widgetGVR, "Widget", "feature-a", "web-widget"),
+	)
+	rel := storePurgeRelease(t, p)
+
+	purged, err := p.forcePurgeRelease(ctx, rel)
+	if err != nil {
+		t.Fatalf("forcePurgeRelease: %v", err)
+	}
+	if purged.Deleted != 2 || purged.Revisions != 2 {
+		t.Errorf("expected 2 objects and 2 revisions removed, got %+v", purged)
+	}
+	skipped := map[string]string{}
+	for _, s := range purged.Skipped {
+		skipped[s.Kind+"/"+s.Name] = s.Reason
+	}
+	if len(skipped) != 2 || skipped["Gadget/web-gadget"] != "kind not served by the cluster" ||
+		skipped["Service/web"] != "helm.sh/resource-policy: keep" {
+		t.Errorf("unexpected skipped objects %+v", purged.Skipped)
+	}
+
+	if _, err := p.dynamic.Resource(configMapGVR).Namespace("feature-a").Get(ctx, "web-config", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected web-config to be deleted, got %v", err)
+	}
+	if _, err := p.dynamic.Resource(configMapGVR).Namespace("feature-a").Get(ctx, "unrelated", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected objects outside the manifest to be kept, got %v", err)
+	}
+	if hasReleases, err := p.namespaceHasReleases(ctx, "feature-a"); err != nil || hasReleases {
+		t.Errorf("expected the release records to be removed, got %v, %v", hasReleases, err)
+	}
+
+	events, err := p.k8s.CoreV1().Events("feature-a").List(ctx, metav1.ListOptions{})
+	if err != nil || len(events.Items) != 1 || events.Items[0].Reason != releaseForcePurgedReason {
+		t.Errorf("expected a force purge event, got %v, %v", events, err)
+	}
+}
+
+func TestForcePurgeRelease_KeepHistory(t *testing.T) {
+	ctx := context.Background()
+	p := newOrphanPruner(t, Options{Uninstall: UninstallOptions{KeepHistory: true}})
+	p.dynamic = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(),
+		newObject(configMapGVR, "ConfigMap", "feature-a", "web-config"),
+	)
+	rel := storePurgeRelease(t, p)
+
+	purged, err := p.forcePurgeRelease(ctx, rel)
+	if err != nil {
+		t.Fatalf("forcePurgeRelease: %v", err)
+	}
+	if purged.Deleted != 1 || purged.Revisions != 0 {
+		t.Errorf("expected 1 object and no revisions removed, got %+v", purged)
+	}
+
+	cfg, err := p.newActionConfig("feature-a")
+	if err != nil {
+		t.Fatalf("newActionConfig: %v", err)
+	}
+	history, err := cfg.Releases.History("web")
+	if err != nil || len(history) != 2 {
+		t.Fatalf("expected both revisions to be kept, got %d, %v", len(history), err)
+	}
+	for _, r := range history {
+		rev := r.(*releasev1.Release)
+		want := common.StatusSuperseded
+		if rev.Version == 2 {
+			want = common.StatusUninstalled
+		}
+		if rev.Info.Status != want {
+			t.Errorf("revision %d status = %s, want %s", rev.Version, rev.Info.Status, want)
+		}
+	}
+}
+
+func TestForcePurgeRelease_DeleteError(t *testing.T) {
+	ctx := context.Background()
+	p := newOrphanPruner(t, Options{})
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(),
+		newObject(widgetGVR, "Widget", "feature-a", "web-widget"),
+	)
+	client.PrependReactor("delete", "widgets", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(widgetGVR.GroupResource(), "web-widget", errors.New("denied"))
+	})
+	p.dynamic = client
+	rel := storePurgeRelease(t, p)
+
+	if _, err := p.forcePurgeRelease(ctx, rel); err == nil {
+		t.Fatal("expected a forbidden deletion to fail the purge")
+	}
+	if hasReleases, err := p.namespaceHasReleases(ctx, "feature-a"); err != nil || !hasReleases {
+		t.Errorf("expected the release records to be kept for the next attempt, got %v, %v", hasReleases, err)
+	}
+}
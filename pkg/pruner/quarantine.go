@@ -0,0 +1,220 @@
+package pruner
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// releaseQuarantinedReason is the reason of the event emitted when a
+// release is quarantined.
+const releaseQuarantinedReason = "ReleaseQuarantined"
+
+// maxReleaseBackoff caps the retry backoff of a release whose uninstall
+// keeps failing.
+const maxReleaseBackoff = 24 * time.Hour
+
+// ReleaseFailure records the failed uninstalls of a release. The record is
+// dropped when an uninstall succeeds, when the release changes (a new
+// revision) or disappears, or when it is cleared with ClearReleaseFailures.
+type ReleaseFailure struct {
+	Name        string    `json:"name"`
+	Namespace   string    `json:"namespace"`
+	Revision    int       `json:"revision"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"lastFailure"`
+	LastError   string    `json:"lastError"`
+	Quarantined bool      `json:"quarantined"`
+}
+
+// CalculateReleaseBackoff returns how long to wait before retrying the
+// uninstall of a release that failed consecutiveFailures times: no wait
+// after the first failure, then base doubling with every further failure,
+// capped at maxReleaseBackoff.
+func CalculateReleaseBackoff(consecutiveFailures int, base time.Duration) time.Duration {
+	if consecutiveFailures <= 1 || base <= 0 {
+		return 0
+	}
+
+	if base >= maxReleaseBackoff {
+		return maxReleaseBackoff
+	}
+	shift := min(consecutiveFailures-2, maxBackoffShift)
+	return min(base<<shift, maxReleaseBackoff)
+}
+
+// releaseKey identifies a release in the pruner state. Namespace names
+// cannot contain dots, so the key is unambiguous.
+func releaseKey(namespace, name string) string {
+	return namespace + "." + name
+}
+
+// retryBlocked returns why the uninstall of rel is not attempted this cycle,
+// or "" if it is.
+func (p *Pruner) retryBlocked(rel *releasev1.Release, now time.Time) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := releaseKey(rel.Namespace, rel.Name)
+	f := p.releaseFailures[key]
+	if f == nil {
+		return ""
+	}
+	if f.Revision != rel.Version {
+		// The release changed since it last failed; start over.
+		if !p.previewing {
+			delete(p.releaseFailures, key)
+		}
+		return ""
+	}
+	if f.Quarantined {
+		return fmt.Sprintf("quarantined after %d failed uninstalls", f.Failures)
+	}
+	if retryAt := f.LastFailure.Add(CalculateReleaseBackoff(f.Failures, p.opts.ReleaseRetryBackoff)); now.Before(retryAt) {
+		return "retry backoff until " + retryAt.Format(time.RFC3339)
+	}
+	return ""
+}
+
+// dropRetryBlocked removes the releases in quarantine or retry backoff from
+// releases, which it modifies, and returns them as skipped.
+func (p *Pruner) dropRetryBlocked(releases []*releasev1.Release, now time.Time) ([]*releasev1.Release, []SkippedRelease) {
+	var skipped []SkippedRelease
+	releases = slices.DeleteFunc(releases, func(rel *releasev1.Release) bool {
+		reason := p.retryBlocked(rel, now)
+		if reason == "" {
+			return false
+		}
+		p.logger.Info("skipping release",
+			"name", rel.Name,
+			"namespace", rel.Namespace,
+			"reason", reason)
+		skipped = append(skipped, SkippedRelease{Name: rel.Name, Namespace: rel.Namespace, Reason: reason})
+		return true
+	})
+	return releases, skipped
+}
+
+// recordUninstallFailure counts a failed uninstall of rel and reports
+// whether this failure put the release in quarantine.
+func (p *Pruner) recordUninstallFailure(rel *releasev1.Release, err error, now time.Time) (*ReleaseFailure, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.releaseFailures == nil {
+		p.releaseFailures = make(map[string]*ReleaseFailure)
+	}
+	key := releaseKey(rel.Namespace, rel.Name)
+	f := p.releaseFailures[key]
+	if f == nil {
+		f = &ReleaseFailure{Name: rel.Name, Namespace: rel.Namespace, Revision: rel.Version}
+		p.releaseFailures[key] = f
+	}
+	f.Failures++
+	f.LastFailure = now
+	f.LastError = err.Error()
+
+	if p.opts.QuarantineAfter > 0 && f.Failures >= p.opts.QuarantineAfter && !f.Quarantined {
+		f.Quarantined = true
+		return f, true
+	}
+	return f, false
+}
+
+// recordUninstallSuccess forgets the failures of an uninstalled release.
+func (p *Pruner) recordUninstallSuccess(rel *releasev1.Release) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := releaseKey(rel.Namespace, rel.Name)
+	if _, ok := p.releaseFailures[key]; ok {
+		delete(p.releaseFailures, key)
+	}
+}
+
+// quarantineRelease reports a release that was just quarantined.
+func (p *Pruner) quarantineRelease(ctx context.Context, rel *releasev1.Release, f *ReleaseFailure) {
+	p.logger.Warn("release quarantined after repeated uninstall failures",
+		"name", rel.Name,
+		"namespace", rel.Namespace,
+		"failures", f.Failures,
+		"error", f.LastError)
+
+	message := fmt.Sprintf("Uninstall of Helm release %s failed %d times; it will not be retried until it changes or its failures are cleared. Last error: %s",
+		rel.Name, f.Failures, f.LastError)
+	if err := p.recordReleaseEvent(ctx, rel, corev1.EventTypeWarning, releaseQuarantinedReason, message); err != nil {
+		p.logger.Error("failed to record quarantine event",
+			"name", rel.Name,
+			"namespace", rel.Namespace,
+			"error", err)
+	}
+}
+
+// updateQuarantineMetric sets the quarantined releases gauge.
+func (p *Pruner) updateQuarantineMetric() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	quarantined := 0
+	for _, f := range p.releaseFailures {
+		if f.Quarantined {
+			quarantined++
+		}
+	}
+	releasesQuarantined.WithLabelValues(p.opts.ClusterName).Set(float64(quarantined))
+}
+
+// ReleaseFailures returns the failure records of releases whose uninstall
+// failed, sorted by namespace and name.
+func (p *Pruner) ReleaseFailures() []ReleaseFailure {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	failures := make([]ReleaseFailure, 0, len(p.releaseFailures))
+	for _, f := range p.releaseFailures {
+		failures = append(failures, *f)
+	}
+	slices.SortFunc(failures, func(a, b ReleaseFailure) int {
+		return cmp.Or(cmp.Compare(a.Namespace, b.Namespace), cmp.Compare(a.Name, b.Name))
+	})
+	return failures
+}
+
+// ClearReleaseFailures forgets the failures of a release, lifting its
+// quarantine and backoff, and reports whether the release had any. The
+// change is saved to the state store right away, or by the running cycle
+// when it finishes.
+func (p *Pruner) ClearReleaseFailures(ctx context.Context, namespace, name string) (bool, error) {
+	if !p.cycleMu.TryLock() {
+		return p.forgetReleaseFailures(namespace, name), nil
+	}
+	defer p.cycleMu.Unlock()
+
+	if err := p.loadState(ctx); err != nil {
+		return false, err
+	}
+	if !p.forgetReleaseFailures(namespace, name) {
+		return false, nil
+	}
+	return true, p.saveState(ctx)
+}
+
+// forgetReleaseFailures drops the failure record of a release and reports
+// whether there was one.
+func (p *Pruner) forgetReleaseFailures(namespace, name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := releaseKey(namespace, name)
+	if _, ok := p.releaseFailures[key]; !ok {
+		return false
+	}
+	delete(p.releaseFailures, key)
+	p.logger.Info("release failures cleared", "name", name, "namespace", namespace)
+	return true
+}
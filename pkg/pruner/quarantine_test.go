@@ -0,0 +1,192 @@
+package pruner
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCalculateReleaseBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		base     time.Duration
+		expected time.Duration
+	}{
+		{"first failure - retried next cycle", 1, time.Hour, 0},
+		{"second failure - base", 2, time.Hour, time.Hour},
+		{"third failure - doubled", 3, time.Hour, 2 * time.Hour},
+		{"sixth failure - 2^4 hours", 6, time.Hour, 16 * time.Hour},
+		{"seventh failure - capped at a day", 7, time.Hour, 24 * time.Hour},
+		{"very high failures - capped at a day", 1000, time.Hour, 24 * time.Hour},
+		{"very high failures - shift limited", 1000, time.Minute, 1024 * time.Minute},
+		{"base above the cap", 2, 30 * 24 * time.Hour, 24 * time.Hour},
+		{"no base - no backoff", 5, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CalculateReleaseBackoff(tt.failures, tt.base); got != tt.expected {
+				t.Errorf("CalculateReleaseBackoff(%d, %v) = %v, want %v", tt.failures, tt.base, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRetryBlocked(t *testing.T) {
+	now := time.Now()
+	p := newClusterPruner(Options{ReleaseRetryBackoff: time.Hour, QuarantineAfter: 3}, nil)
+	rel := mockRelease("web", "feature-a", now)
+	rel.Version = 1
+	failure := errors.New("hook failed")
+
+	if reason := p.retryBlocked(rel, now); reason != "" {
+		t.Fatalf("expected a release without failures to be attempted, got %q", reason)
+	}
+
+	if _, quarantined := p.recordUninstallFailure(rel, failure, now); quarantined {
+		t.Fatal("expected no quarantine after one failure")
+	}
+	if reason := p.retryBlocked(rel, now); reason != "" {
+		t.Errorf("expected a retry on the cycle after the first failure, got %q", reason)
+	}
+
+	p.recordUninstallFailure(rel, failure, now)
+	if reason := p.retryBlocked(rel, now.Add(30*time.Minute)); reason == "" {
+		t.Error("expected the release to be backing off after the second failure")
+	}
+	if reason := p.retryBlocked(rel, now.Add(time.Hour)); reason != "" {
+		t.Errorf("expected a retry once the backoff elapsed, got %q", reason)
+	}
+
+	f, quarantined := p.recordUninstallFailure(rel, failure, now)
+	if !quarantined || !f.Quarantined || f.Failures != 3 || f.LastError != "hook failed" {
+		t.Fatalf("expected quarantine after three failures, got %+v", f)
+	}
+	if reason := p.retryBlocked(rel, now.Add(48*time.Hour)); reason != "quarantined after 3 failed uninstalls" {
+		t.Errorf("retryBlocked = %q, want quarantine", reason)
+	}
+
+	upgraded := mockRelease("web", "feature-a", now)
+	upgraded.Version = 2
+	if reason := p.retryBlocked(upgraded, now); reason != "" {
+		t.Errorf("expected a new revision to lift the quarantine, got %q", reason)
+	}
+	if failures := p.ReleaseFailures(); len(failures) != 0 {
+		t.Errorf("expected the failures to be forgotten, got %+v", failures)
+	}
+}
+
+func TestDropRetryBlocked(t *testing.T) {
+	now := time.Now()
+	p := newClusterPruner(Options{QuarantineAfter: 1, MaxDeletionsPerCycle: 2}, nil)
+	var releases []*releasev1.Release
+	for _, ns := range []string{"feature-a", "feature-b", "feature-c"} {
+		rel := mockRelease("web", ns, now.Add(-48*time.Hour))
+		rel.Version = 1
+		releases = append(releases, rel)
+	}
+	p.recordUninstallFailure(releases[1], errors.New("hook failed"), now)
+
+	toDelete, skipped := p.dropRetryBlocked(releases, now)
+	if got := releaseNames(toDelete); !slices.Equal(got, []string{"feature-a/web", "feature-c/web"}) {
+		t.Errorf("to delete = %v, want the releases outside quarantine", got)
+	}
+	if len(skipped) != 1 || skipped[0].Namespace != "feature-b" || !strings.Contains(skipped[0].Reason, "quarantined") {
+		t.Errorf("expected the quarantined release to be skipped, got %+v", skipped)
+	}
+	// Quarantined releases do not count toward the deletion limit.
+	if err := p.checkDeletionLimit("releases", len(toDelete)); err != nil {
+		t.Errorf("checkDeletionLimit: %v", err)
+	}
+}
+
+func TestForgetGoneReleases(t *testing.T) {
+	now := time.Now()
+	p := newClusterPruner(Options{}, nil)
+	p.recordUninstallFailure(mockRelease("web", "feature-a", now), errors.New("boom"), now)
+	p.recordUninstallFailure(mockRelease("api", "feature-b", now), errors.New("boom"), now)
+
+	p.forgetGoneReleases([]*releasev1.Release{mockRelease("api", "feature-b", now)})
+
+	failures := p.ReleaseFailures()
+	if len(failures) != 1 || failures[0].Name != "api" {
+		t.Errorf("expected only the existing release to keep its failures, got %+v", failures)
+	}
+
+	p.recordUninstallSuccess(mockRelease("api", "feature-b", now))
+	if failures := p.ReleaseFailures(); len(failures) != 0 {
+		t.Errorf("expected a successful uninstall to clear the failures, got %+v", failures)
+	}
+}
+
+func TestQuarantineRelease_RecordsEvent(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	p := newClusterPruner(Options{QuarantineAfter: 1}, nil)
+	rel := mockRelease("web", "feature-a", now)
+
+	f, quarantined := p.recordUninstallFailure(rel, errors.New("missing CRD"), now)
+	if !quarantined {
+		t.Fatal("expected quarantine after the first failure")
+	}
+	p.quarantineRelease(ctx, rel, f)
+
+	events, err := p.k8s.CoreV1().Events("feature-a").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list events: %v", err)
+	}
+	if len(events.Items) != 1 {
+		t.Fatalf("expected one event, got %d", len(events.Items))
+	}
+	if e := events.Items[0]; e.Reason != releaseQuarantinedReason || e.Type != corev1.EventTypeWarning {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
+
+func TestClearReleaseFailures(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	client := fake.NewClientset()
+	newPruner := func() *Pruner {
+		p := newClusterPruner(Options{QuarantineAfter: 2}, nil)
+		p.state = &ConfigMapStateStore{Client: client, Namespace: "pruner", Name: "state"}
+		if err := p.loadState(ctx); err != nil {
+			t.Fatalf("loadState: %v", err)
+		}
+		return p
+	}
+
+	p := newPruner()
+	rel := mockRelease("web", "feature-a", now)
+	p.recordUninstallFailure(rel, errors.New("boom"), now)
+	p.recordUninstallFailure(rel, errors.New("boom"), now)
+	if err := p.saveState(ctx); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+
+	// A restarted pruner keeps the release quarantined.
+	restarted := newPruner()
+	failures := restarted.ReleaseFailures()
+	if len(failures) != 1 || !failures[0].Quarantined || failures[0].Failures != 2 || !failures[0].LastFailure.Equal(now) {
+		t.Fatalf("unexpected loaded failures %+v", failures)
+	}
+
+	if cleared, err := restarted.ClearReleaseFailures(ctx, "feature-a", "api"); err != nil || cleared {
+		t.Errorf("ClearReleaseFailures of an unknown release = %v, %v", cleared, err)
+	}
+	if cleared, err := restarted.ClearReleaseFailures(ctx, "feature-a", "web"); err != nil || !cleared {
+		t.Fatalf("ClearReleaseFailures = %v, %v", cleared, err)
+	}
+	if failures := newPruner().ReleaseFailures(); len(failures) != 0 {
+		t.Errorf("expected the cleared failures to be saved, got %+v", failures)
+	}
+}
@@ -0,0 +1,335 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// helmReleaseNameAnnotation is set by Helm on every resource it manages.
+const helmReleaseNameAnnotation = "meta.helm.sh/release-name"
+
+// nsReference is a dependency of one namespace on another.
+type nsReference struct {
+	// From is the namespace that depends on To.
+	From string
+	// To is the namespace depended on.
+	To string
+	// Service is the referenced Service in To, if the reference is that
+	// specific. Empty means the whole namespace.
+	Service string
+	// Via describes the object that creates the reference.
+	Via string
+}
+
+func (r nsReference) String() string {
+	return fmt.Sprintf("%s (%s)", r.From, r.Via)
+}
+
+// referenceGraph holds the cross-namespace references found in the cluster.
+type referenceGraph struct {
+	refs []nsReference
+	// serviceOwners maps "namespace/service" to the Helm release that owns it.
+	serviceOwners map[string]string
+}
+
+// referencesTo returns the references into namespace from namespaces that
+// are not leaving. A nil graph has no references.
+func (g *referenceGraph) referencesTo(namespace string, leaving map[string]bool) []nsReference {
+	if g == nil {
+		return nil
+	}
+	var refs []nsReference
+	for _, ref := range g.refs {
+		if ref.To == namespace && !leaving[ref.From] {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// releaseReferences returns the references that depend on a release. A
+// reference to a Service depends only on the release that owns the Service;
+// if the owner is unknown, every release in the namespace is protected.
+func (g *referenceGraph) releaseReferences(rel *releasev1.Release, leaving map[string]bool) []nsReference {
+	var refs []nsReference
+	for _, ref := range g.referencesTo(rel.Namespace, leaving) {
+		if ref.Service != "" {
+			owner := g.serviceOwners[ref.To+"/"+ref.Service]
+			if owner != "" && owner != rel.Name {
+				continue
+			}
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// keepReferencedReleases removes releases that are still referenced from
+// outside the set of namespaces being pruned. Keeping a release keeps its
+// namespace, whose own references are then honoured too, so this repeats
+// until nothing changes. It returns the remaining releases and the
+// namespaces still leaving.
+func (p *Pruner) keepReferencedReleases(graph *referenceGraph, releases []*releasev1.Release) ([]*releasev1.Release, map[string]bool) {
+	leaving := make(map[string]bool)
+	for _, rel := range releases {
+		leaving[rel.Namespace] = true
+	}
+
+	for changed := true; changed; {
+		changed = false
+		kept := releases[:0:0]
+		for _, rel := range releases {
+			refs := graph.releaseReferences(rel, leaving)
+			if len(refs) == 0 {
+				kept = append(kept, rel)
+				continue
+			}
+			p.logger.Info("keeping release referenced from another namespace",
+				"name", rel.Name,
+				"namespace", rel.Namespace,
+				"referenced_by", refs)
+			delete(leaving, rel.Namespace)
+			changed = true
+		}
+		releases = kept
+	}
+
+	return releases, leaving
+}
+
+// keepReferencedNamespaces removes namespaces that are still referenced
+// from namespaces that are not being deleted, repeating until nothing
+// changes.
+func (p *Pruner) keepReferencedNamespaces(graph *referenceGraph, namespaces []string) []string {
+	leaving := make(map[string]bool)
+	for _, ns := range namespaces {
+		leaving[ns] = true
+	}
+
+	for changed := true; changed; {
+		changed = false
+		kept := namespaces[:0:0]
+		for _, ns := range namespaces {
+			refs := graph.referencesTo(ns, leaving)
+			if len(refs) == 0 {
+				kept = append(kept, ns)
+				continue
+			}
+			p.logger.Info("keeping namespace referenced from another namespace",
+				"namespace", ns,
+				"referenced_by", refs)
+			delete(leaving, ns)
+			changed = true
+		}
+		namespaces = kept
+	}
+
+	return namespaces
+}
+
+// buildReferenceGraph collects cross-namespace references from ExternalName
+// Services, Ingress backends, NetworkPolicy namespace selectors and the
+// configured custom references. It returns nil when reference protection is
+// disabled. References within a namespace, or to namespaces that do not
+// exist, are ignored.
+func (p *Pruner) buildReferenceGraph(ctx context.Context) (*referenceGraph, error) {
+	if !p.opts.ProtectReferencedNamespaces {
+		return nil, nil
+	}
+
+	namespaces, err := p.k8s.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+	}
+	exists := make(map[string]bool, len(namespaces.Items))
+	for _, ns := range namespaces.Items {
+		exists[ns.Name] = true
+	}
+
+	g := &referenceGraph{serviceOwners: make(map[string]string)}
+	add := func(ref nsReference) {
+		if ref.From != ref.To && exists[ref.To] {
+			g.refs = append(g.refs, ref)
+		}
+	}
+
+	services, err := p.k8s.CoreV1().Services(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services: %w", err)
+	}
+	externalNames := make(map[string]corev1.Service)
+	for _, svc := range services.Items {
+		if owner := svc.Annotations[helmReleaseNameAnnotation]; owner != "" {
+			g.serviceOwners[svc.Namespace+"/"+svc.Name] = owner
+		}
+		if svc.Spec.Type != corev1.ServiceTypeExternalName {
+			continue
+		}
+		externalNames[svc.Namespace+"/"+svc.Name] = svc
+		if name, ns, ok := parseServiceHost(svc.Spec.ExternalName); ok {
+			add(nsReference{From: svc.Namespace, To: ns, Service: name, Via: "service/" + svc.Name})
+		}
+	}
+
+	ingresses, err := p.k8s.NetworkingV1().Ingresses(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ingresses: %w", err)
+	}
+	for _, ing := range ingresses.Items {
+		for _, backend := range ingressServiceBackends(ing) {
+			svc, ok := externalNames[ing.Namespace+"/"+backend]
+			if !ok {
+				continue
+			}
+			if name, ns, ok := parseServiceHost(svc.Spec.ExternalName); ok {
+				add(nsReference{From: ing.Namespace, To: ns, Service: name,
+					Via: fmt.Sprintf("ingress/%s -> service/%s", ing.Name, backend)})
+			}
+		}
+	}
+
+	policies, err := p.k8s.NetworkingV1().NetworkPolicies(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list network policies: %w", err)
+	}
+	for _, policy := range policies.Items {
+		via := "networkpolicy/" + policy.Name
+		// Egress peers are namespaces the policy's namespace talks to.
+		// Ingress peers are only allowed to talk to it, which does not
+		// make them depend on it: a preview that admits traffic from the
+		// ingress controller must still be pruned.
+		for _, rule := range policy.Spec.Egress {
+			for _, ns := range selectedNamespaces(rule.To, namespaces.Items) {
+				add(nsReference{From: policy.Namespace, To: ns, Via: via})
+			}
+		}
+	}
+
+	for _, custom := range p.opts.CustomReferences {
+		refs, err := p.customReferences(ctx, custom)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range refs {
+			add(ref)
+		}
+	}
+
+	p.logger.Debug("built namespace reference graph", "references", len(g.refs))
+	return g, nil
+}
+
+// customReferences lists the objects of a custom reference, given as
+// "resource:field.path", and returns a reference from each object's
+// namespace to the namespaces named at the field path. The field may hold a
+// string or a list of strings.
+func (p *Pruner) customReferences(ctx context.Context, custom string) ([]nsReference, error) {
+	resource, path, ok := strings.Cut(custom, ":")
+	if !ok || resource == "" || path == "" {
+		return nil, fmt.Errorf("invalid custom reference %q (expected resource:field.path)", custom)
+	}
+
+	gvr, err := p.resolveResource(resource)
+	if meta.IsNoMatchError(err) {
+		p.logger.Debug("custom reference resource not served by cluster, ignoring",
+			"resource", resource)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve resource %q: %w", resource, err)
+	}
+
+	list, err := p.dynamic.Resource(gvr).Namespace(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", gvr.GroupResource(), err)
+	}
+
+	fields := strings.Split(strings.TrimPrefix(path, "."), ".")
+	var refs []nsReference
+	for _, item := range list.Items {
+		value, found, err := unstructured.NestedFieldNoCopy(item.Object, fields...)
+		if err != nil || !found {
+			continue
+		}
+		var targets []string
+		switch v := value.(type) {
+		case string:
+			targets = []string{v}
+		case []any:
+			for _, t := range v {
+				if s, ok := t.(string); ok {
+					targets = append(targets, s)
+				}
+			}
+		}
+		for _, target := range targets {
+			refs = append(refs, nsReference{
+				From: item.GetNamespace(),
+				To:   target,
+				Via:  fmt.Sprintf("%s/%s", gvr.GroupResource(), item.GetName()),
+			})
+		}
+	}
+	return refs, nil
+}
+
+// parseServiceHost extracts the service and namespace from an in-cluster
+// service host name: name.namespace, name.namespace.svc or
+// name.namespace.svc.<cluster domain>.
+func parseServiceHost(host string) (name, namespace string, ok bool) {
+	parts := strings.Split(strings.TrimSuffix(host, "."), ".")
+	if len(parts) < 2 || (len(parts) > 2 && parts[2] != "svc") {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// ingressServiceBackends returns the names of the Services an Ingress routes to.
+func ingressServiceBackends(ing networkingv1.Ingress) []string {
+	var names []string
+	if b := ing.Spec.DefaultBackend; b != nil && b.Service != nil {
+		names = append(names, b.Service.Name)
+	}
+	for _, rule := range ing.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			if path.Backend.Service != nil {
+				names = append(names, path.Backend.Service.Name)
+			}
+		}
+	}
+	return names
+}
+
+// selectedNamespaces returns the namespaces matched by the namespace
+// selectors of NetworkPolicy peers. Empty selectors match every namespace
+// and are not treated as references.
+func selectedNamespaces(peers []networkingv1.NetworkPolicyPeer, namespaces []corev1.Namespace) []string {
+	var names []string
+	for _, peer := range peers {
+		if peer.NamespaceSelector == nil {
+			continue
+		}
+		sel, err := metav1.LabelSelectorAsSelector(peer.NamespaceSelector)
+		if err != nil || sel.Empty() {
+			continue
+		}
+		for _, ns := range namespaces {
+			if sel.Matches(labels.Set(ns.Labels)) {
+				names = append(names, ns.Name)
+			}
+		}
+	}
+	return names
+}
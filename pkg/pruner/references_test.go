@@ -0,0 +1,240 @@
+package pruner
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+)
+
+func service(namespace, name, release, externalName string) *corev1.Service {
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	if release != "" {
+		svc.Annotations = map[string]string{helmReleaseNameAnnotation: release}
+	}
+	if externalName != "" {
+		svc.Spec.Type = corev1.ServiceTypeExternalName
+		svc.Spec.ExternalName = externalName
+	}
+	return svc
+}
+
+func releaseNames(releases []*releasev1.Release) []string {
+	var names []string
+	for _, rel := range releases {
+		names = append(names, rel.Namespace+"/"+rel.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestParseServiceHost(t *testing.T) {
+	tests := []struct {
+		host      string
+		name      string
+		namespace string
+		ok        bool
+	}{
+		{"db.preview-db", "db", "preview-db", true},
+		{"db.preview-db.svc", "db", "preview-db", true},
+		{"db.preview-db.svc.cluster.local", "db", "preview-db", true},
+		{"db.preview-db.svc.cluster.local.", "db", "preview-db", true},
+		{"db.example.com.", "", "", false},
+		{"api.example.com", "", "", false},
+		{"localhost", "", "", false},
+	}
+	for _, tt := range tests {
+		name, namespace, ok := parseServiceHost(tt.host)
+		if name != tt.name || namespace != tt.namespace || ok != tt.ok {
+			t.Errorf("parseServiceHost(%q) = %q, %q, %v; want %q, %q, %v",
+				tt.host, name, namespace, ok, tt.name, tt.namespace, tt.ok)
+		}
+	}
+}
+
+func TestKeepReferencedReleases(t *testing.T) {
+	created := time.Now().Add(-24 * time.Hour)
+	previewDB := labelledNamespace("preview-db", map[string]string{"role": "shared-db"}, nil)
+	typed := []runtime.Object{
+		namespace("feature-a", created),
+		namespace("feature-b", created),
+		namespace("feature-c", created),
+		namespace("feature-d", created),
+		previewDB,
+		namespace("cache", created),
+		namespace("queue", created),
+		// feature-a (kept) points at db in preview-db.
+		service("feature-a", "db", "", "db.preview-db.svc.cluster.local"),
+		service("preview-db", "db", "postgres", ""),
+		// feature-b (pruned) points at redis in cache: does not protect it.
+		service("feature-b", "redis", "", "redis.cache"),
+		service("cache", "redis", "redis", ""),
+		// feature-c routes ingress traffic to queue via an ExternalName service.
+		service("feature-c", "queue-proxy", "", "rabbit.queue.svc"),
+		&networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "feature-c", Name: "web"},
+			Spec: networkingv1.IngressSpec{
+				DefaultBackend: &networkingv1.IngressBackend{
+					Service: &networkingv1.IngressServiceBackend{Name: "queue-proxy"},
+				},
+			},
+		},
+		// feature-d allows egress to the shared database namespace.
+		&networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "feature-d", Name: "to-db"},
+			Spec: networkingv1.NetworkPolicySpec{
+				Egress: []networkingv1.NetworkPolicyEgressRule{{
+					To: []networkingv1.NetworkPolicyPeer{{
+						NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"role": "shared-db"}},
+					}},
+				}},
+			},
+		},
+	}
+
+	p := newClusterPruner(Options{ProtectReferencedNamespaces: true}, typed)
+	graph, err := p.buildReferenceGraph(context.Background())
+	if err != nil {
+		t.Fatalf("buildReferenceGraph: %v", err)
+	}
+
+	now := time.Now()
+	toDelete := []*releasev1.Release{
+		mockRelease("web", "feature-b", now),
+		mockRelease("postgres", "preview-db", now),
+		mockRelease("migrations", "preview-db", now),
+		mockRelease("redis", "cache", now),
+		mockRelease("rabbit", "queue", now),
+	}
+
+	kept, leaving := p.keepReferencedReleases(graph, toDelete)
+
+	// postgres and migrations are kept: the NetworkPolicy in feature-d
+	// protects the whole namespace. rabbit has no known owner, so every
+	// release in queue is protected.
+	expected := []string{"cache/redis", "feature-b/web"}
+	if got := releaseNames(kept); !slices.Equal(got, expected) {
+		t.Errorf("releases to delete = %v, want %v", got, expected)
+	}
+	if leaving["preview-db"] || leaving["queue"] || !leaving["cache"] {
+		t.Errorf("unexpected leaving namespaces %v", leaving)
+	}
+}
+
+func TestKeepReferencedReleases_ServiceOwner(t *testing.T) {
+	created := time.Now().Add(-24 * time.Hour)
+	p := newClusterPruner(Options{ProtectReferencedNamespaces: true}, []runtime.Object{
+		namespace("feature-a", created),
+		namespace("preview-db", created),
+		service("feature-a", "db", "", "db.preview-db"),
+		service("preview-db", "db", "postgres", ""),
+	})
+	graph, err := p.buildReferenceGraph(context.Background())
+	if err != nil {
+		t.Fatalf("buildReferenceGraph: %v", err)
+	}
+
+	now := time.Now()
+	kept, leaving := p.keepReferencedReleases(graph, []*releasev1.Release{
+		mockRelease("postgres", "preview-db", now),
+		mockRelease("adminer", "preview-db", now),
+	})
+
+	if got := releaseNames(kept); !slices.Equal(got, []string{"preview-db/adminer"}) {
+		t.Errorf("releases to delete = %v, want only the release not owning the service", got)
+	}
+	if refs := graph.referencesTo("preview-db", leaving); len(refs) != 1 {
+		t.Errorf("expected the namespace to stay referenced, got %v", refs)
+	}
+}
+
+func TestKeepReferencedReleases_IngressPolicy(t *testing.T) {
+	created := time.Now().Add(-24 * time.Hour)
+	p := newClusterPruner(Options{ProtectReferencedNamespaces: true}, []runtime.Object{
+		namespace("feature-a", created),
+		labelledNamespace("ingress-nginx", map[string]string{"kubernetes.io/metadata.name": "ingress-nginx"}, nil),
+		// feature-a admits traffic from the ingress controller, which
+		// does not make ingress-nginx depend on it.
+		&networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "feature-a", Name: "allow-ingress"},
+			Spec: networkingv1.NetworkPolicySpec{
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					From: []networkingv1.NetworkPolicyPeer{{
+						NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"kubernetes.io/metadata.name": "ingress-nginx"}},
+					}},
+				}},
+			},
+		},
+	})
+	graph, err := p.buildReferenceGraph(context.Background())
+	if err != nil {
+		t.Fatalf("buildReferenceGraph: %v", err)
+	}
+
+	kept, leaving := p.keepReferencedReleases(graph, []*releasev1.Release{mockRelease("web", "feature-a", time.Now())})
+	if got := releaseNames(kept); !slices.Equal(got, []string{"feature-a/web"}) {
+		t.Errorf("releases to delete = %v, want [feature-a/web]", got)
+	}
+	if !leaving["feature-a"] {
+		t.Errorf("expected feature-a to be leaving, got %v", leaving)
+	}
+	if kept := p.keepReferencedNamespaces(graph, []string{"feature-a"}); !slices.Equal(kept, []string{"feature-a"}) {
+		t.Errorf("namespaces to delete = %v, want [feature-a]", kept)
+	}
+}
+
+func TestKeepReferencedNamespaces(t *testing.T) {
+	widget := newObject(widgetGVR, "Widget", "team-app", "gizmo")
+	widget.Object["spec"] = map[string]any{"databaseNamespaces": []any{"feature-db"}}
+
+	created := time.Now().Add(-24 * time.Hour)
+	p := newClusterPruner(Options{
+		ProtectReferencedNamespaces: true,
+		CustomReferences:            []string{"widgets.example.com:spec.databaseNamespaces"},
+	}, []runtime.Object{
+		namespace("team-app", created),
+		namespace("feature-db", created),
+		namespace("feature-x", created),
+		namespace("feature-y", created),
+		namespace("feature-z", created),
+		// feature-z (staying) -> feature-y -> feature-x, both orphans.
+		service("feature-z", "api", "", "api.feature-y"),
+		service("feature-y", "api", "", "api.feature-x"),
+	}, widget)
+
+	graph, err := p.buildReferenceGraph(context.Background())
+	if err != nil {
+		t.Fatalf("buildReferenceGraph: %v", err)
+	}
+
+	// feature-db is used by team-app; feature-y by feature-z, and so
+	// feature-x by feature-y.
+	kept := p.keepReferencedNamespaces(graph, []string{"feature-db", "feature-x", "feature-y"})
+	if len(kept) != 0 {
+		t.Errorf("expected every namespace to be kept, got %v", kept)
+	}
+
+	// Deleting feature-z as well releases the whole chain.
+	kept = p.keepReferencedNamespaces(graph, []string{"feature-db", "feature-x", "feature-y", "feature-z"})
+	if expected := []string{"feature-x", "feature-y", "feature-z"}; !slices.Equal(kept, expected) {
+		t.Errorf("namespaces to delete = %v, want %v", kept, expected)
+	}
+}
+
+func TestBuildReferenceGraph_Disabled(t *testing.T) {
+	p := newClusterPruner(Options{}, nil)
+	graph, err := p.buildReferenceGraph(context.Background())
+	if err != nil || graph != nil {
+		t.Errorf("expected no graph when disabled, got %v, %v", graph, err)
+	}
+	if refs := graph.referencesTo("any", nil); refs != nil {
+		t.Errorf("expected nil graph to have no references, got %v", refs)
+	}
+}
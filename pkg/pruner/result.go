@@ -0,0 +1,114 @@
+package pruner
+
+import (
+	"errors"
+	"fmt"
+
+	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+)
+
+var (
+	// ErrDeletionFailures is wrapped by the error RunOnce returns when a
+	// larger share of deletions failed than Options.MaxDeletionFailurePercent
+	// allows.
+	ErrDeletionFailures = errors.New("too many deletions failed")
+
+	// ErrSafetyGuard is wrapped by the error RunOnce returns when a cycle is
+	// aborted before deleting anything because it would delete more than
+	// Options.MaxDeletionsPerCycle releases or orphan namespaces.
+	ErrSafetyGuard = errors.New("aborted by safety guard")
+)
+
+// CycleResult describes what a prune cycle did. In dry-run mode, Deleted and
+// NamespacesDeleted list what would have been deleted.
+type CycleResult struct {
+	DryRun bool `json:"dryRun"`
+	// Scanned is the number of releases listed, and Candidates the number
+	// left after filtering, before age, count and branch selection.
+	Scanned           int              `json:"scanned"`
+	Candidates        int              `json:"candidates"`
+	Deleted           []ReleaseRef     `json:"deleted,omitempty"`
+	Failed            []FailedDeletion `json:"failed,omitempty"`
+	Skipped           []SkippedRelease `json:"skipped,omitempty"`
+	NamespacesDeleted []string         `json:"namespacesDeleted,omitempty"`
+	// Purged lists the releases among Deleted that were force purged, with
+	// the resources the purge left in place.
+	Purged []PurgedRelease `json:"purged,omitempty"`
+}
+
+// ReleaseRef identifies a release.
+type ReleaseRef struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// FailedDeletion is a release or namespace whose deletion failed. Release is
+// empty for namespaces.
+type FailedDeletion struct {
+	Release   string `json:"release,omitempty"`
+	Namespace string `json:"namespace"`
+	Error     string `json:"error"`
+}
+
+// SkippedRelease is a release left alone by filters or protections.
+// Filtered is set for releases that did not pass the filters, and so were
+// never candidates for deletion.
+type SkippedRelease struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Reason    string `json:"reason"`
+	Filtered  bool   `json:"filtered,omitempty"`
+}
+
+// Attempted returns the number of release and namespace deletions attempted.
+func (r *CycleResult) Attempted() int {
+	return len(r.Deleted) + len(r.NamespacesDeleted) + len(r.Failed)
+}
+
+// FailurePercent returns the percentage of attempted deletions that failed.
+func (r *CycleResult) FailurePercent() float64 {
+	if r.Attempted() == 0 {
+		return 0
+	}
+	return float64(len(r.Failed)) * 100 / float64(r.Attempted())
+}
+
+func (r *CycleResult) deleted(rel *releasev1.Release) {
+	r.Deleted = append(r.Deleted, ReleaseRef{Name: rel.Name, Namespace: rel.Namespace})
+}
+
+func (r *CycleResult) failed(rel *releasev1.Release, err error) {
+	r.Failed = append(r.Failed, FailedDeletion{Release: rel.Name, Namespace: rel.Namespace, Error: err.Error()})
+}
+
+func (r *CycleResult) failedNamespace(namespace string, err error) {
+	r.Failed = append(r.Failed, FailedDeletion{Namespace: namespace, Error: err.Error()})
+}
+
+func (r *CycleResult) skipped(rel *releasev1.Release, reason string) {
+	r.Skipped = append(r.Skipped, SkippedRelease{Name: rel.Name, Namespace: rel.Namespace, Reason: reason})
+}
+
+// checkDeletionFailures fails a cycle whose share of failed deletions
+// exceeds MaxDeletionFailurePercent.
+func (p *Pruner) checkDeletionFailures(result *CycleResult) error {
+	if len(result.Failed) == 0 {
+		return nil
+	}
+	if percent := result.FailurePercent(); percent > p.opts.MaxDeletionFailurePercent {
+		return fmt.Errorf("%w: %d of %d (%.0f%%, limit %.0f%%)", ErrDeletionFailures,
+			len(result.Failed), result.Attempted(), percent, p.opts.MaxDeletionFailurePercent)
+	}
+	return nil
+}
+
+// checkDeletionLimit aborts a cycle that would delete more than
+// MaxDeletionsPerCycle objects of one kind, which usually means a filter
+// matches far more than intended.
+func (p *Pruner) checkDeletionLimit(kind string, count int) error {
+	if p.opts.MaxDeletionsPerCycle > 0 && count > p.opts.MaxDeletionsPerCycle {
+		return fmt.Errorf("%w: %d %s selected for deletion, limit is %d", ErrSafetyGuard,
+			count, kind, p.opts.MaxDeletionsPerCycle)
+	}
+	return nil
+}
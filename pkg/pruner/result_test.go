@@ -0,0 +1,154 @@
+package pruner
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"helm.sh/helm/v4/pkg/release/common"
+	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+)
+
+func TestCheckDeletionFailures(t *testing.T) {
+	deleted := []ReleaseRef{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+	failed := []FailedDeletion{{Release: "d", Error: "boom"}}
+
+	tests := []struct {
+		name      string
+		result    CycleResult
+		maxFailed float64
+		wantErr   bool
+	}{
+		{"no deletions", CycleResult{}, 0, false},
+		{"no failures", CycleResult{Deleted: deleted}, 0, false},
+		{"any failure", CycleResult{Deleted: deleted, Failed: failed}, 0, true},
+		{"under threshold", CycleResult{Deleted: deleted, Failed: failed}, 25, false},
+		{"over threshold", CycleResult{Deleted: deleted[:1], Failed: failed}, 25, true},
+		{"all failed", CycleResult{Failed: failed}, 50, true},
+		{"never", CycleResult{Failed: failed}, 100, false},
+		{"namespaces count", CycleResult{NamespacesDeleted: []string{"a", "b", "c"}, Failed: failed}, 25, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPruner(Options{MaxDeletionFailurePercent: tt.maxFailed})
+			err := p.checkDeletionFailures(&tt.result)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkDeletionFailures() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrDeletionFailures) {
+				t.Errorf("expected ErrDeletionFailures, got %v", err)
+			}
+		})
+	}
+}
+
+func TestPartitionReleases(t *testing.T) {
+	now := time.Now()
+	uninstalled := mockRelease("feature-old", "feature-c", now)
+	uninstalled.Info.Status = common.StatusUninstalled
+	releases := []*releasev1.Release{
+		mockRelease("feature-a", "feature-a", now),
+		mockRelease("feature-b-keep", "feature-b", now),
+		mockRelease("web", "production", now),
+		uninstalled,
+	}
+
+	p := newTestPruner(Options{
+		NamespaceFilter: regexp.MustCompile(`^feature-`),
+		ReleaseExclude:  regexp.MustCompile(`-keep$`),
+		Uninstall:       UninstallOptions{KeepHistory: true},
+	})
+	kept, skipped := p.partitionReleases(releases, nil)
+
+	if got := releaseNames(kept); !slices.Equal(got, []string{"feature-a/feature-a"}) {
+		t.Errorf("kept = %v, want [feature-a/feature-a]", got)
+	}
+	want := []SkippedRelease{
+		{Name: "feature-b-keep", Namespace: "feature-b", Reason: "release exclude", Filtered: true},
+		{Name: "web", Namespace: "production", Reason: "namespace filter", Filtered: true},
+		{Name: "feature-old", Namespace: "feature-c", Reason: "already uninstalled, history kept", Filtered: true},
+	}
+	if !slices.Equal(skipped, want) {
+		t.Errorf("skipped = %+v, want %+v", skipped, want)
+	}
+}
+
+func TestRunOnce_Result(t *testing.T) {
+	old := time.Now().Add(-24 * time.Hour)
+	newPruner := func(opts Options) *Pruner {
+		opts.CleanupOrphanNamespaces = true
+		p := newOrphanPruner(t, opts,
+			namespace("feature-a", old),
+			namespace("feature-b", old),
+			namespace("feature-c", old),
+		)
+		// feature-b and feature-c cannot be deleted.
+		p.k8s.(*fake.Clientset).PrependReactor("delete", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if name := action.(k8stesting.DeleteAction).GetName(); name != "feature-a" {
+				return true, nil, errors.New("admission webhook denied the request")
+			}
+			return false, nil, nil
+		})
+		return p
+	}
+
+	p := newPruner(Options{MaxDeletionFailurePercent: 50})
+	result, err := p.RunOnce(context.Background())
+	if !errors.Is(err, ErrDeletionFailures) {
+		t.Fatalf("expected ErrDeletionFailures, got %v", err)
+	}
+	if !slices.Equal(result.NamespacesDeleted, []string{"feature-a"}) {
+		t.Errorf("NamespacesDeleted = %v, want [feature-a]", result.NamespacesDeleted)
+	}
+	if len(result.Failed) != 2 || result.Failed[0].Namespace != "feature-b" || result.Failed[0].Error == "" {
+		t.Errorf("unexpected failures %+v", result.Failed)
+	}
+
+	p = newPruner(Options{MaxDeletionFailurePercent: 70})
+	if _, err := p.RunOnce(context.Background()); err != nil {
+		t.Errorf("expected 2 of 3 failed deletions to be tolerated at 70%%, got %v", err)
+	}
+
+	p = newPruner(Options{DryRun: true})
+	result, err = p.RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("RunOnce (dry run): %v", err)
+	}
+	if !result.DryRun || len(result.NamespacesDeleted) != 3 || len(result.Failed) != 0 {
+		t.Errorf("unexpected dry-run result %+v", result)
+	}
+}
+
+func TestRunOnce_DeletionLimit(t *testing.T) {
+	old := time.Now().Add(-24 * time.Hour)
+	p := newOrphanPruner(t, Options{CleanupOrphanNamespaces: true, MaxDeletionsPerCycle: 1},
+		namespace("feature-a", old),
+		namespace("feature-b", old),
+	)
+
+	result, err := p.RunOnce(context.Background())
+	if !errors.Is(err, ErrSafetyGuard) {
+		t.Fatalf("expected ErrSafetyGuard, got %v", err)
+	}
+	if len(result.NamespacesDeleted) != 0 {
+		t.Errorf("expected nothing to be deleted, got %v", result.NamespacesDeleted)
+	}
+	if got := remainingNamespaces(t, p); len(got) != 2 {
+		t.Errorf("remaining namespaces = %v, want both", got)
+	}
+
+	p.opts.MaxDeletionsPerCycle = 2
+	if _, err := p.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce within the limit: %v", err)
+	}
+	if got := remainingNamespaces(t, p); len(got) != 0 {
+		t.Errorf("remaining namespaces = %v, want none", got)
+	}
+}
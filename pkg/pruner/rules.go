@@ -0,0 +1,468 @@
+package pruner
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"helm.sh/helm/v4/pkg/release/common"
+	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Release limit modes: how MaxReleasesToKeep and OlderThan combine.
+const (
+	// LimitModeAny deletes the releases beyond either limit.
+	LimitModeAny = "any"
+	// LimitModeAll deletes the releases beyond both limits.
+	LimitModeAll = "all"
+)
+
+// Release limit scopes: the sets of releases counted by MaxReleasesToKeep
+// and MinReleasesToKeep.
+const (
+	LimitScopeGlobal    = "global"
+	LimitScopeNamespace = "namespace"
+	LimitScopeGroup     = "group"
+)
+
+// groupCaptureGroup is the named capture group of Options.GroupPattern that
+// holds the group name. Without it, the first capture group or else the
+// whole match is used.
+const groupCaptureGroup = "group"
+
+// Decision is what a rule decides about a release.
+type Decision int
+
+const (
+	// Abstain leaves the release to the other rules.
+	Abstain Decision = iota
+	// Keep protects the release, whatever the other rules decide.
+	Keep
+	// Delete selects the release for deletion, unless a rule keeps it.
+	Delete
+)
+
+// String returns the decision name.
+func (d Decision) String() string {
+	switch d {
+	case Keep:
+		return "keep"
+	case Delete:
+		return "delete"
+	default:
+		return "abstain"
+	}
+}
+
+// Verdict is a rule's decision about a release, with the reason for it.
+// Reasons of Keep verdicts are reported as skip reasons.
+type Verdict struct {
+	Decision Decision
+	Reason   string
+}
+
+// RuleContext is what a rule knows about a release besides the release
+// itself.
+type RuleContext struct {
+	// Now is when the selection started.
+	Now time.Time
+
+	// Namespace is the metadata of the release namespace. It is only
+	// populated when namespace selectors or custom rules are configured.
+	Namespace metav1.ObjectMeta
+
+	// LastActive is when the release was last deployed or, with activity
+	// signals, last active.
+	LastActive time.Time
+
+	// Rank is the position of the release among the candidate releases of
+	// its limit scope (see Options.LimitScope), sorted by LastActive, newest
+	// first, starting at 0.
+	Rank int
+}
+
+// Rule decides whether a release is kept or deleted. Rules are evaluated
+// once per candidate release each cycle and must not block: external state
+// belongs in an ActivitySignal or a DeletionHook.
+//
+// Releases are selected in two steps. The filters (name and namespace
+// regexes and selectors, then Options.Filters) run first and keep releases
+// outside the pruning scope; the remaining releases are the candidates.
+// Then the custom rules of Options.Rules and the built-in max count, age,
+// lifetime, failed install and branch rules are evaluated for every
+// candidate: a release is deleted when at least one rule decides Delete and
+// none decides Keep, unless deleting it would leave fewer than
+// Options.MinReleasesToKeep releases in its limit scope.
+type Rule interface {
+	Evaluate(rc *RuleContext, rel *releasev1.Release) Verdict
+}
+
+// RuleFunc adapts a function to the Rule interface.
+type RuleFunc func(rc *RuleContext, rel *releasev1.Release) Verdict
+
+// Evaluate calls f.
+func (f RuleFunc) Evaluate(rc *RuleContext, rel *releasev1.Release) Verdict {
+	return f(rc, rel)
+}
+
+// And deletes a release when every rule decides Delete, and keeps it when
+// any rule decides Keep, with the first Keep reason. Otherwise it abstains.
+func And(rules ...Rule) Rule {
+	return RuleFunc(func(rc *RuleContext, rel *releasev1.Release) Verdict {
+		var reasons []string
+		for _, rule := range rules {
+			v := rule.Evaluate(rc, rel)
+			switch v.Decision {
+			case Keep:
+				return v
+			case Delete:
+				reasons = append(reasons, v.Reason)
+			}
+		}
+		if len(rules) > 0 && len(reasons) == len(rules) {
+			return Verdict{Decision: Delete, Reason: strings.Join(reasons, " and ")}
+		}
+		return Verdict{}
+	})
+}
+
+// Or deletes a release when any rule decides Delete, with the first Delete
+// reason, and keeps it when every rule decides Keep. Otherwise it abstains.
+func Or(rules ...Rule) Rule {
+	return RuleFunc(func(rc *RuleContext, rel *releasev1.Release) Verdict {
+		var reasons []string
+		for _, rule := range rules {
+			v := rule.Evaluate(rc, rel)
+			switch v.Decision {
+			case Delete:
+				return v
+			case Keep:
+				reasons = append(reasons, v.Reason)
+			}
+		}
+		if len(rules) > 0 && len(reasons) == len(rules) {
+			return Verdict{Decision: Keep, Reason: strings.Join(reasons, " and ")}
+		}
+		return Verdict{}
+	})
+}
+
+// Not turns the Delete decisions of rule into Keep and its Keep decisions
+// into Delete. Abstentions are unchanged.
+func Not(rule Rule) Rule {
+	return RuleFunc(func(rc *RuleContext, rel *releasev1.Release) Verdict {
+		v := rule.Evaluate(rc, rel)
+		switch v.Decision {
+		case Keep:
+			return Verdict{Decision: Delete, Reason: "not " + v.Reason}
+		case Delete:
+			return Verdict{Decision: Keep, Reason: "not " + v.Reason}
+		default:
+			return v
+		}
+	})
+}
+
+// ReleaseFilterRule keeps releases whose name does not match re.
+func ReleaseFilterRule(re *regexp.Regexp) Rule {
+	return RuleFunc(func(_ *RuleContext, rel *releasev1.Release) Verdict {
+		if !re.MatchString(rel.Name) {
+			return Verdict{Decision: Keep, Reason: "release filter"}
+		}
+		return Verdict{}
+	})
+}
+
+// ReleaseExcludeRule keeps releases whose name matches re.
+func ReleaseExcludeRule(re *regexp.Regexp) Rule {
+	return RuleFunc(func(_ *RuleContext, rel *releasev1.Release) Verdict {
+		if re.MatchString(rel.Name) {
+			return Verdict{Decision: Keep, Reason: "release exclude"}
+		}
+		return Verdict{}
+	})
+}
+
+// NamespaceFilterRule keeps releases whose namespace does not match re.
+func NamespaceFilterRule(re *regexp.Regexp) Rule {
+	return RuleFunc(func(_ *RuleContext, rel *releasev1.Release) Verdict {
+		if !re.MatchString(rel.Namespace) {
+			return Verdict{Decision: Keep, Reason: "namespace filter"}
+		}
+		return Verdict{}
+	})
+}
+
+// NamespaceExcludeRule keeps releases whose namespace matches re.
+func NamespaceExcludeRule(re *regexp.Regexp) Rule {
+	return RuleFunc(func(_ *RuleContext, rel *releasev1.Release) Verdict {
+		if re.MatchString(rel.Namespace) {
+			return Verdict{Decision: Keep, Reason: "namespace exclude"}
+		}
+		return Verdict{}
+	})
+}
+
+// NamespaceSelectorRule keeps releases whose namespace labels do not match
+// sel.
+func NamespaceSelectorRule(sel labels.Selector) Rule {
+	return RuleFunc(func(rc *RuleContext, _ *releasev1.Release) Verdict {
+		if !sel.Matches(labels.Set(rc.Namespace.Labels)) {
+			return Verdict{Decision: Keep, Reason: "namespace selector"}
+		}
+		return Verdict{}
+	})
+}
+
+// OlderThanRule deletes releases inactive for longer than d.
+func OlderThanRule(d time.Duration) Rule {
+	return RuleFunc(func(rc *RuleContext, _ *releasev1.Release) Verdict {
+		if age := rc.Now.Sub(rc.LastActive); age > d {
+			return Verdict{Decision: Delete, Reason: fmt.Sprintf("inactive for %s, limit %s", age.Round(time.Second), d)}
+		}
+		return Verdict{}
+	})
+}
+
+// MaxReleasesRule deletes the candidate releases beyond the n most recently
+// active of their limit scope.
+func MaxReleasesRule(n int) Rule {
+	return RuleFunc(func(rc *RuleContext, _ *releasev1.Release) Verdict {
+		if rc.Rank >= n {
+			return Verdict{Decision: Delete, Reason: fmt.Sprintf("beyond the newest %d releases", n)}
+		}
+		return Verdict{}
+	})
+}
+
+// MaxLifetimeRule deletes releases first deployed longer than d ago,
+// however recently they were redeployed or active.
+func MaxLifetimeRule(d time.Duration) Rule {
+	return RuleFunc(func(rc *RuleContext, rel *releasev1.Release) Verdict {
+		if rel.Info.FirstDeployed.IsZero() {
+			return Verdict{}
+		}
+		if lifetime := rc.Now.Sub(rel.Info.FirstDeployed); lifetime > d {
+			return Verdict{Decision: Delete, Reason: fmt.Sprintf("first deployed %s ago, lifetime limit %s", lifetime.Round(time.Second), d)}
+		}
+		return Verdict{}
+	})
+}
+
+// FailedInstallRule deletes releases whose only revision failed, which
+// never ran.
+func FailedInstallRule() Rule {
+	return RuleFunc(func(_ *RuleContext, rel *releasev1.Release) Verdict {
+		if rel.Version == 1 && rel.Info.Status == common.StatusFailed {
+			return Verdict{Decision: Delete, Reason: "install failed"}
+		}
+		return Verdict{}
+	})
+}
+
+// filterRules returns the rules that scope release pruning, in the order
+// their reasons take precedence: the built-in filters, then Options.Filters.
+func (p *Pruner) filterRules() []Rule {
+	var rules []Rule
+	if p.opts.Uninstall.KeepHistory {
+		rules = append(rules, RuleFunc(func(_ *RuleContext, rel *releasev1.Release) Verdict {
+			if rel.Info.Status == common.StatusUninstalled {
+				return Verdict{Decision: Keep, Reason: "already uninstalled, history kept"}
+			}
+			return Verdict{}
+		}))
+	}
+	if p.opts.NamespaceFilter != nil {
+		rules = append(rules, NamespaceFilterRule(p.opts.NamespaceFilter))
+	}
+	if p.opts.NamespaceExclude != nil {
+		rules = append(rules, NamespaceExcludeRule(p.opts.NamespaceExclude))
+	}
+	if p.opts.NamespaceSelector != nil {
+		rules = append(rules, NamespaceSelectorRule(p.opts.NamespaceSelector))
+	}
+	if p.opts.NamespaceExcludeSelector != nil || p.opts.NamespaceExcludeAnnotations != nil {
+		rules = append(rules, RuleFunc(func(rc *RuleContext, _ *releasev1.Release) Verdict {
+			if reason := p.namespaceExcluded(rc.Namespace); reason != "" {
+				return Verdict{Decision: Keep, Reason: reason}
+			}
+			return Verdict{}
+		}))
+	}
+	if p.opts.ReleaseFilter != nil {
+		rules = append(rules, ReleaseFilterRule(p.opts.ReleaseFilter))
+	}
+	if p.opts.ReleaseExclude != nil {
+		rules = append(rules, ReleaseExcludeRule(p.opts.ReleaseExclude))
+	}
+	return append(rules, p.opts.Filters...)
+}
+
+// selectionRules returns the rules evaluated for candidate releases: the
+// custom rules, then the built-in max count, age, lifetime, failed install
+// and branch rules. gone holds the releases whose source branch no longer
+// exists.
+func (p *Pruner) selectionRules(gone map[*releasev1.Release]bool) []Rule {
+	rules := slices.Clone(p.opts.Rules)
+	var limits []Rule
+	if p.opts.MaxReleasesToKeep > 0 {
+		limits = append(limits, MaxReleasesRule(p.opts.MaxReleasesToKeep))
+	}
+	if p.opts.OlderThan > 0 {
+		limits = append(limits, OlderThanRule(p.opts.OlderThan))
+	}
+	if p.opts.LimitMode == LimitModeAll && len(limits) > 1 {
+		rules = append(rules, And(limits...))
+	} else {
+		rules = append(rules, limits...)
+	}
+	if p.opts.MaxLifetime > 0 {
+		rules = append(rules, MaxLifetimeRule(p.opts.MaxLifetime))
+	}
+	if p.opts.DeleteFailedInstalls {
+		rules = append(rules, FailedInstallRule())
+	}
+	if len(gone) > 0 {
+		rules = append(rules, RuleFunc(func(_ *RuleContext, rel *releasev1.Release) Verdict {
+			if gone[rel] {
+				return Verdict{Decision: Delete, Reason: "source branch is gone"}
+			}
+			return Verdict{}
+		}))
+	}
+	return rules
+}
+
+// validateLimits checks the limit mode and scope options.
+func validateLimits(opts Options) error {
+	switch opts.LimitMode {
+	case "", LimitModeAny, LimitModeAll:
+	default:
+		return fmt.Errorf("unknown limit mode %q (valid: %s, %s)", opts.LimitMode, LimitModeAny, LimitModeAll)
+	}
+	switch opts.LimitScope {
+	case "", LimitScopeGlobal, LimitScopeNamespace:
+	case LimitScopeGroup:
+		if opts.GroupPattern == nil {
+			return fmt.Errorf("limit scope %q requires a group pattern", LimitScopeGroup)
+		}
+	default:
+		return fmt.Errorf("unknown limit scope %q (valid: %s, %s, %s)",
+			opts.LimitScope, LimitScopeGlobal, LimitScopeNamespace, LimitScopeGroup)
+	}
+	if opts.MinReleasesToKeep < 0 {
+		return fmt.Errorf("minimum releases to keep must not be negative")
+	}
+	return nil
+}
+
+// limitScope returns the scope of a release for the release count limits:
+// "" globally, its namespace, or its group.
+func (p *Pruner) limitScope(rel *releasev1.Release) string {
+	switch p.opts.LimitScope {
+	case LimitScopeNamespace:
+		return rel.Namespace
+	case LimitScopeGroup:
+		m := p.opts.GroupPattern.FindStringSubmatch(rel.Name)
+		switch {
+		case m == nil:
+			return ""
+		case p.opts.GroupPattern.SubexpIndex(groupCaptureGroup) > 0:
+			return m[p.opts.GroupPattern.SubexpIndex(groupCaptureGroup)]
+		case len(m) > 1:
+			return m[1]
+		default:
+			return m[0]
+		}
+	default:
+		return ""
+	}
+}
+
+// evaluateReleases evaluates the selection rules for the candidate
+// releases, then spares the newest selected releases of each limit scope
+// that would otherwise keep fewer than MinReleasesToKeep. It returns the
+// releases to delete, newest first, and the releases a rule or the minimum
+// kept, with the reason.
+func (p *Pruner) evaluateReleases(candidates []*releasev1.Release, namespaces map[string]metav1.ObjectMeta, gone map[*releasev1.Release]bool) ([]*releasev1.Release, []SkippedRelease) {
+	rules := p.selectionRules(gone)
+	if len(candidates) == 0 || len(rules) == 0 {
+		return nil, nil
+	}
+
+	sorted := slices.Clone(candidates)
+	slices.SortStableFunc(sorted, func(a, b *releasev1.Release) int {
+		return p.lastActive(b).Compare(p.lastActive(a))
+	})
+
+	var (
+		selected []*releasev1.Release
+		kept     []SkippedRelease
+		ranks    = make(map[string]int)
+		// survivors counts the releases of each scope that are not deleted.
+		survivors = make(map[string]int)
+	)
+	now := time.Now()
+	for _, rel := range sorted {
+		scope := p.limitScope(rel)
+		rc := &RuleContext{
+			Now:        now,
+			Namespace:  namespaces[rel.Namespace],
+			LastActive: p.lastActive(rel),
+			Rank:       ranks[scope],
+		}
+		ranks[scope]++
+
+		var reasons []string
+		keep := ""
+		for _, rule := range rules {
+			switch v := rule.Evaluate(rc, rel); v.Decision {
+			case Keep:
+				if keep == "" {
+					keep = v.Reason
+				}
+			case Delete:
+				reasons = append(reasons, v.Reason)
+			}
+		}
+
+		switch {
+		case len(reasons) == 0:
+			survivors[scope]++
+		case keep != "":
+			// Kept releases are only reported when another rule would
+			// have deleted them.
+			p.logger.Debug("skipping release ("+keep+")",
+				"name", rel.Name,
+				"namespace", rel.Namespace)
+			kept = append(kept, SkippedRelease{Name: rel.Name, Namespace: rel.Namespace, Reason: keep})
+			survivors[scope]++
+		default:
+			p.logger.Debug("release selected for deletion",
+				"name", rel.Name,
+				"namespace", rel.Namespace,
+				"reasons", reasons)
+			selected = append(selected, rel)
+		}
+	}
+
+	var toDelete []*releasev1.Release
+	for _, rel := range selected {
+		scope := p.limitScope(rel)
+		if survivors[scope] < p.opts.MinReleasesToKeep {
+			survivors[scope]++
+			reason := fmt.Sprintf("within the minimum of %d releases to keep", p.opts.MinReleasesToKeep)
+			p.logger.Debug("skipping release ("+reason+")",
+				"name", rel.Name,
+				"namespace", rel.Namespace)
+			kept = append(kept, SkippedRelease{Name: rel.Name, Namespace: rel.Namespace, Reason: reason})
+			continue
+		}
+		toDelete = append(toDelete, rel)
+	}
+	return toDelete, kept
+}
@@ -0,0 +1,242 @@
+package pruner
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"helm.sh/helm/v4/pkg/release/common"
+	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fixedRule always returns the same verdict.
+func fixedRule(d Decision, reason string) Rule {
+	return RuleFunc(func(*RuleContext, *releasev1.Release) Verdict {
+		return Verdict{Decision: d, Reason: reason}
+	})
+}
+
+func TestRuleComposition(t *testing.T) {
+	del := fixedRule(Delete, "stale")
+	keep := fixedRule(Keep, "protected")
+	abstain := fixedRule(Abstain, "")
+
+	tests := []struct {
+		name     string
+		rule     Rule
+		decision Decision
+		reason   string
+	}{
+		{"and - all delete", And(del, fixedRule(Delete, "idle")), Delete, "stale and idle"},
+		{"and - keep wins", And(del, keep), Keep, "protected"},
+		{"and - abstention", And(del, abstain), Abstain, ""},
+		{"and - empty", And(), Abstain, ""},
+		{"or - any delete", Or(abstain, del, keep), Delete, "stale"},
+		{"or - all keep", Or(keep, fixedRule(Keep, "pinned")), Keep, "protected and pinned"},
+		{"or - abstention", Or(keep, abstain), Abstain, ""},
+		{"not - delete", Not(del), Keep, "not stale"},
+		{"not - keep", Not(keep), Delete, "not protected"},
+		{"not - abstain", Not(abstain), Abstain, ""},
+		{"nested", And(Not(keep), Or(abstain, del)), Delete, "not protected and stale"},
+	}
+
+	rel := mockRelease("web", "feature-a", time.Now())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := tt.rule.Evaluate(&RuleContext{}, rel)
+			if v.Decision != tt.decision || v.Reason != tt.reason {
+				t.Errorf("Evaluate = %v %q, want %v %q", v.Decision, v.Reason, tt.decision, tt.reason)
+			}
+		})
+	}
+}
+
+func TestBuiltinRules(t *testing.T) {
+	now := time.Now()
+	rel := mockRelease("feature-login-web", "feature-login", now.Add(-48*time.Hour))
+	rc := &RuleContext{Now: now, LastActive: rel.Info.LastDeployed, Rank: 3}
+
+	tests := []struct {
+		name     string
+		rule     Rule
+		decision Decision
+	}{
+		{"release filter - match", ReleaseFilterRule(regexp.MustCompile(`^feature-`)), Abstain},
+		{"release filter - no match", ReleaseFilterRule(regexp.MustCompile(`^main-`)), Keep},
+		{"release exclude", ReleaseExcludeRule(regexp.MustCompile(`-web$`)), Keep},
+		{"namespace filter", NamespaceFilterRule(regexp.MustCompile(`^production$`)), Keep},
+		{"namespace exclude", NamespaceExcludeRule(regexp.MustCompile(`^feature-`)), Keep},
+		{"older than - stale", OlderThanRule(24 * time.Hour), Delete},
+		{"older than - fresh", OlderThanRule(72 * time.Hour), Abstain},
+		{"max releases - beyond", MaxReleasesRule(3), Delete},
+		{"max releases - within", MaxReleasesRule(4), Abstain},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if v := tt.rule.Evaluate(rc, rel); v.Decision != tt.decision {
+				t.Errorf("Evaluate = %v (%q), want %v", v.Decision, v.Reason, tt.decision)
+			}
+		})
+	}
+}
+
+func TestEvaluateReleases_CustomRules(t *testing.T) {
+	now := time.Now()
+	releases := []*releasev1.Release{
+		mockRelease("web", "feature-a", now.Add(-1*time.Hour)),
+		mockRelease("db", "feature-a", now.Add(-72*time.Hour)),
+		mockRelease("web", "feature-pinned", now.Add(-72*time.Hour)),
+		mockRelease("legacy", "feature-b", now.Add(-2*time.Hour)),
+	}
+	namespaces := map[string]metav1.ObjectMeta{
+		"feature-pinned": {Name: "feature-pinned", Labels: map[string]string{"pinned": "true"}},
+	}
+
+	pinned := RuleFunc(func(rc *RuleContext, _ *releasev1.Release) Verdict {
+		if rc.Namespace.Labels["pinned"] == "true" {
+			return Verdict{Decision: Keep, Reason: "pinned namespace"}
+		}
+		return Verdict{}
+	})
+	legacy := RuleFunc(func(_ *RuleContext, rel *releasev1.Release) Verdict {
+		if rel.Name == "legacy" {
+			return Verdict{Decision: Delete, Reason: "legacy chart"}
+		}
+		return Verdict{}
+	})
+
+	p := newTestPruner(Options{OlderThan: 24 * time.Hour, Rules: []Rule{pinned, legacy}})
+	toDelete, kept := p.evaluateReleases(releases, namespaces, nil)
+
+	if got := releaseNames(toDelete); !slices.Equal(got, []string{"feature-a/db", "feature-b/legacy"}) {
+		t.Errorf("deleted = %v, want the stale db and the legacy release", got)
+	}
+	if len(kept) != 1 || kept[0].Namespace != "feature-pinned" || kept[0].Reason != "pinned namespace" {
+		t.Errorf("expected only the stale pinned release to be reported as kept, got %+v", kept)
+	}
+}
+
+func TestEvaluateReleases_LimitMode(t *testing.T) {
+	now := time.Now()
+	var releases []*releasev1.Release
+	for i, age := range []time.Duration{1, 2, 3, 200, 300} {
+		releases = append(releases, mockRelease("web", fmt.Sprintf("feature-%d", i), now.Add(-age*time.Hour)))
+	}
+
+	tests := []struct {
+		mode string
+		want []string
+	}{
+		// Beyond the newest 2 or older than 100h.
+		{LimitModeAny, []string{"feature-2/web", "feature-3/web", "feature-4/web"}},
+		// Beyond the newest 2 and older than 100h.
+		{LimitModeAll, []string{"feature-3/web", "feature-4/web"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			p := newTestPruner(Options{MaxReleasesToKeep: 2, OlderThan: 100 * time.Hour, LimitMode: tt.mode})
+			toDelete, _ := p.evaluateReleases(releases, nil, nil)
+			if got := releaseNames(toDelete); !slices.Equal(got, tt.want) {
+				t.Errorf("deleted = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateReleases_MinReleasesToKeep(t *testing.T) {
+	now := time.Now()
+	releases := []*releasev1.Release{
+		mockRelease("web", "feature-a", now.Add(-100*time.Hour)),
+		mockRelease("api", "feature-a", now.Add(-200*time.Hour)),
+		mockRelease("db", "feature-a", now.Add(-300*time.Hour)),
+		mockRelease("web", "feature-b", now.Add(-1*time.Hour)),
+		mockRelease("api", "feature-b", now.Add(-400*time.Hour)),
+	}
+	alwaysDelete := fixedRule(Delete, "unwanted")
+
+	tests := []struct {
+		name       string
+		opts       Options
+		wantDelete []string
+	}{
+		{
+			name:       "global",
+			opts:       Options{OlderThan: 24 * time.Hour, MinReleasesToKeep: 3},
+			wantDelete: []string{"feature-a/db", "feature-b/api"},
+		},
+		{
+			name:       "per namespace",
+			opts:       Options{OlderThan: 24 * time.Hour, MinReleasesToKeep: 2, LimitScope: LimitScopeNamespace},
+			wantDelete: []string{"feature-a/db"},
+		},
+		{
+			name:       "per group",
+			opts:       Options{Rules: []Rule{alwaysDelete}, MinReleasesToKeep: 1, LimitScope: LimitScopeGroup, GroupPattern: regexp.MustCompile(`^(?P<group>[a-z]+)$`)},
+			wantDelete: []string{"feature-a/web", "feature-b/api"},
+		},
+		{
+			name:       "max releases per namespace",
+			opts:       Options{MaxReleasesToKeep: 1, LimitScope: LimitScopeNamespace},
+			wantDelete: []string{"feature-a/api", "feature-a/db", "feature-b/api"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPruner(tt.opts)
+			toDelete, kept := p.evaluateReleases(releases, nil, nil)
+			if got := releaseNames(toDelete); !slices.Equal(got, tt.wantDelete) {
+				t.Errorf("deleted = %v, want %v", got, tt.wantDelete)
+			}
+			for _, k := range kept {
+				if !strings.Contains(k.Reason, "minimum") {
+					t.Errorf("unexpected kept release %+v", k)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateLimits(t *testing.T) {
+	for _, opts := range []Options{
+		{LimitMode: "both"},
+		{LimitScope: "cluster"},
+		{LimitScope: LimitScopeGroup},
+		{MinReleasesToKeep: -1},
+	} {
+		if err := validateLimits(opts); err == nil {
+			t.Errorf("expected an error for %+v", opts)
+		}
+	}
+	if err := validateLimits(Options{LimitMode: LimitModeAll, LimitScope: LimitScopeGroup, GroupPattern: regexp.MustCompile(`-(\w+)$`)}); err != nil {
+		t.Errorf("validateLimits: %v", err)
+	}
+}
+
+func TestEvaluateReleases_LifetimeAndFailedInstalls(t *testing.T) {
+	now := time.Now()
+	// A preview redeployed nightly for 40 days, a recent one, and two
+	// failed releases: a failed install and a failed upgrade.
+	longLived := mockRelease("web", "feature-old", now.Add(-2*time.Hour))
+	longLived.Info.FirstDeployed = now.Add(-40 * 24 * time.Hour)
+	longLived.Version = 40
+	recent := mockRelease("web", "feature-new", now.Add(-2*time.Hour))
+	recent.Info.FirstDeployed = now.Add(-3 * 24 * time.Hour)
+	recent.Version = 3
+	failedInstall := mockRelease("web", "feature-broken", now.Add(-1*time.Hour))
+	failedInstall.Info.Status = common.StatusFailed
+	failedInstall.Version = 1
+	failedUpgrade := mockRelease("api", "feature-new", now.Add(-1*time.Hour))
+	failedUpgrade.Info.Status = common.StatusFailed
+	failedUpgrade.Version = 2
+	releases := []*releasev1.Release{longLived, recent, failedInstall, failedUpgrade}
+
+	p := newTestPruner(Options{OlderThan: 7 * 24 * time.Hour, MaxLifetime: 30 * 24 * time.Hour, DeleteFailedInstalls: true})
+	toDelete, _ := p.evaluateReleases(releases, nil, nil)
+	if got := releaseNames(toDelete); !slices.Equal(got, []string{"feature-broken/web", "feature-old/web"}) {
+		t.Errorf("deleted = %v, want the failed install and the long-lived preview", got)
+	}
+}
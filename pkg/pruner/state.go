@@ -0,0 +1,441 @@
+package pruner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// stateKey is the ConfigMap key that holds the pruner state.
+const stateKey = "state.json"
+
+// stateSaveAttempts bounds how often saving the state is attempted when
+// someone else keeps saving it concurrently.
+const stateSaveAttempts = 3
+
+// stateSaveTimeout bounds saving the state at the end of a cycle, which
+// also happens when the cycle was cancelled.
+const stateSaveTimeout = 10 * time.Second
+
+// ErrStateConflict is returned by StateStore.Save when the stored state was
+// written by someone else since it was last loaded or saved.
+var ErrStateConflict = errors.New("pruner state was modified concurrently")
+
+// State is the bookkeeping a pruner keeps across cycles. It is persisted by
+// a StateStore so that restarts and leader handoffs do not reset it.
+type State struct {
+	LastCycle           *CycleSummary `json:"lastCycle,omitempty"`
+	ConsecutiveFailures int           `json:"consecutiveFailures"`
+	Ready               bool          `json:"ready"`
+
+	// ReleaseFailures are the failed uninstalls of releases, keyed by
+	// <namespace>.<release>.
+	ReleaseFailures map[string]*ReleaseFailure `json:"releaseFailures,omitempty"`
+
+	// OrphanSince records when each namespace was first seen without Helm
+	// releases, for OrphanIdleTime.
+	OrphanSince map[string]time.Time `json:"orphanSince,omitempty"`
+
+	// DeletedNamespaces are namespaces the pruner deleted and is waiting on,
+	// for StuckNamespaceThreshold.
+	DeletedNamespaces map[string]*DeletedNamespace `json:"deletedNamespaces,omitempty"`
+
+	// LastActivity is the most recent activity observed for each release,
+	// keyed by <namespace>.<release>.
+	LastActivity map[string]time.Time `json:"lastActivity,omitempty"`
+}
+
+// StateStore persists the pruner state. Save must fail with an error
+// wrapping ErrStateConflict when the stored state changed since the last
+// Load or Save, so that two pruners never silently overwrite each other.
+// The pruner then reloads the state, applies its own changes on top of it
+// and saves again.
+type StateStore interface {
+	// Load returns the stored state, or an empty state if none is stored.
+	Load(ctx context.Context) (*State, error)
+	// Save stores state.
+	Save(ctx context.Context, state *State) error
+}
+
+// newStateStore builds the state store configured in the options, or nil
+// when the state is kept in memory only.
+func newStateStore(opts Options, k8s kubernetes.Interface) (StateStore, error) {
+	configured := 0
+	for _, set := range []bool{opts.StateStore != nil, opts.StateConfigMap != "", opts.StateFile != ""} {
+		if set {
+			configured++
+		}
+	}
+	if configured > 1 {
+		return nil, fmt.Errorf("at most one of a state ConfigMap, a state file or a custom state store can be set")
+	}
+
+	switch {
+	case opts.StateStore != nil:
+		return opts.StateStore, nil
+	case opts.StateConfigMap != "":
+		namespace, name, ok := strings.Cut(opts.StateConfigMap, "/")
+		if !ok || namespace == "" || name == "" {
+			return nil, fmt.Errorf("invalid state ConfigMap %q (expected namespace/name)", opts.StateConfigMap)
+		}
+		return &ConfigMapStateStore{Client: k8s, Namespace: namespace, Name: name}, nil
+	case opts.StateFile != "":
+		return &FileStateStore{Path: opts.StateFile}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// ConfigMapStateStore stores the state as JSON in a ConfigMap, which is
+// created on the first save. Saves are conditional on the resourceVersion
+// of the last load or save.
+type ConfigMapStateStore struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Name      string
+
+	mu sync.Mutex
+	// cm is the ConfigMap as last loaded or saved, nil if it did not exist.
+	cm *corev1.ConfigMap
+}
+
+// Load implements StateStore.
+func (s *ConfigMapStateStore) Load(ctx context.Context) (*State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cm, err := s.Client.CoreV1().ConfigMaps(s.Namespace).Get(ctx, s.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		s.cm = nil
+		return &State{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get state ConfigMap: %w", err)
+	}
+	s.cm = cm
+
+	state := &State{}
+	if data, ok := cm.Data[stateKey]; ok {
+		if err := json.Unmarshal([]byte(data), state); err != nil {
+			return nil, fmt.Errorf("invalid state in ConfigMap %s/%s: %w", s.Namespace, s.Name, err)
+		}
+	}
+	return state, nil
+}
+
+// Save implements StateStore.
+func (s *ConfigMapStateStore) Save(ctx context.Context, state *State) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	configMaps := s.Client.CoreV1().ConfigMaps(s.Namespace)
+	var saved *corev1.ConfigMap
+	if s.cm == nil {
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: s.Namespace, Name: s.Name},
+			Data:       map[string]string{stateKey: string(data)},
+		}
+		saved, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("%w: ConfigMap %s/%s was created by someone else", ErrStateConflict, s.Namespace, s.Name)
+		}
+	} else {
+		cm := s.cm.DeepCopy()
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		cm.Data[stateKey] = string(data)
+		saved, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		if apierrors.IsConflict(err) || apierrors.IsNotFound(err) {
+			return fmt.Errorf("%w: ConfigMap %s/%s changed since it was loaded", ErrStateConflict, s.Namespace, s.Name)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save state ConfigMap: %w", err)
+	}
+	s.cm = saved
+	return nil
+}
+
+// FileStateStore stores the state as JSON in a local file, for example on
+// a persistent volume. Each save increments a version stored in the file
+// and is refused if the file's version changed since the last load or save.
+type FileStateStore struct {
+	Path string
+
+	mu      sync.Mutex
+	version int64
+}
+
+// stateFile is the content of a FileStateStore file.
+type stateFile struct {
+	Version int64  `json:"version"`
+	State   *State `json:"state"`
+}
+
+// Load implements StateStore.
+func (s *FileStateStore) Load(context.Context) (*State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	s.version = f.Version
+	return f.State, nil
+}
+
+// Save implements StateStore.
+func (s *FileStateStore) Save(_ context.Context, state *State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.read()
+	if err != nil {
+		return err
+	}
+	if current.Version != s.version {
+		return fmt.Errorf("%w: %s is at version %d, expected %d", ErrStateConflict, s.Path, current.Version, s.version)
+	}
+
+	data, err := json.Marshal(stateFile{Version: s.version + 1, State: state})
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	// Write to a temporary file and rename it, so that a crash never
+	// leaves a truncated state behind.
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.Path); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	s.version++
+	return nil
+}
+
+// read returns the content of the state file, or version 0 with an empty
+// state if the file does not exist.
+func (s *FileStateStore) read() (*stateFile, error) {
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &stateFile{State: &State{}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	f := &stateFile{}
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %w", s.Path, err)
+	}
+	if f.State == nil {
+		f.State = &State{}
+	}
+	return f, nil
+}
+
+// loadState restores the pruner state from the state store, once, or again
+// after a save failed. The caller must hold cycleMu.
+func (p *Pruner) loadState(ctx context.Context) error {
+	if p.state == nil || p.stateLoaded {
+		return nil
+	}
+
+	state, err := p.state.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load pruner state: %w", err)
+	}
+	p.stateBase = cloneState(state)
+	p.applyState(state)
+
+	p.stateLoaded = true
+	p.logger.Debug("pruner state loaded",
+		"release_failures", len(state.ReleaseFailures),
+		"orphan_namespaces", len(state.OrphanSince),
+		"deleted_namespaces", len(state.DeletedNamespaces))
+	return nil
+}
+
+// applyState replaces the in-memory pruner state. The caller must hold
+// cycleMu.
+func (p *Pruner) applyState(state *State) {
+	p.mu.Lock()
+	p.lastCycle = state.LastCycle
+	p.consecutiveFailures = state.ConsecutiveFailures
+	p.releaseFailures = state.ReleaseFailures
+	p.orphanSince = state.OrphanSince
+	p.lastActivity = state.LastActivity
+	p.mu.Unlock()
+	p.deletedNamespaces = state.DeletedNamespaces
+	if state.Ready {
+		p.ready.Store(true)
+	}
+}
+
+// saveState writes the pruner state to the state store. When someone else
+// saved the state in the meantime, the stored state is reloaded, the
+// changes made here since the last load or save are applied on top of it,
+// and the save is retried. In dry-run mode the state is only loaded, so
+// that a dry run cannot overwrite the state of pruners that delete. The
+// caller must hold cycleMu.
+func (p *Pruner) saveState(ctx context.Context) error {
+	if p.state == nil || !p.stateLoaded || p.opts.DryRun {
+		return nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		local := p.snapshotState()
+		err := p.state.Save(ctx, local)
+		if err == nil {
+			p.stateBase = local
+			return nil
+		}
+		if !errors.Is(err, ErrStateConflict) || attempt == stateSaveAttempts {
+			// Start the next cycle from the stored state.
+			p.stateLoaded = false
+			return err
+		}
+
+		stored, err := p.state.Load(ctx)
+		if err != nil {
+			p.stateLoaded = false
+			return fmt.Errorf("failed to reload pruner state after a conflict: %w", err)
+		}
+		p.logger.Debug("pruner state changed concurrently, merging", "attempt", attempt)
+		merged := mergeState(stored, p.stateBase, local)
+		p.stateBase = stored
+		p.applyState(merged)
+	}
+}
+
+// mergeState applies the changes from base to local on top of stored and
+// returns the result, which shares nothing with its arguments. Entries
+// added, changed or removed locally win; the other entries are stored's.
+// The cycle bookkeeping is local's, since it describes this pruner.
+func mergeState(stored, base, local *State) *State {
+	merged := cloneState(stored)
+	local = cloneState(local)
+	if base == nil {
+		base = &State{}
+	}
+
+	merged.LastCycle = local.LastCycle
+	merged.ConsecutiveFailures = local.ConsecutiveFailures
+	merged.Ready = local.Ready
+	merged.ReleaseFailures = mergeMap(merged.ReleaseFailures, base.ReleaseFailures, local.ReleaseFailures)
+	merged.OrphanSince = mergeMap(merged.OrphanSince, base.OrphanSince, local.OrphanSince)
+	merged.DeletedNamespaces = mergeMap(merged.DeletedNamespaces, base.DeletedNamespaces, local.DeletedNamespaces)
+	merged.LastActivity = mergeMap(merged.LastActivity, base.LastActivity, local.LastActivity)
+	return merged
+}
+
+// mergeMap applies the entries added, changed or removed from base to local
+// on stored, which it modifies and returns.
+func mergeMap[V any](stored, base, local map[string]V) map[string]V {
+	if stored == nil {
+		stored = make(map[string]V)
+	}
+	for key, value := range local {
+		if old, ok := base[key]; !ok || !reflect.DeepEqual(old, value) {
+			stored[key] = value
+		}
+	}
+	for key := range base {
+		if _, ok := local[key]; !ok {
+			delete(stored, key)
+		}
+	}
+	return stored
+}
+
+// cloneState returns a deep copy of state.
+func cloneState(state *State) *State {
+	data, err := json.Marshal(state)
+	if err != nil {
+		panic(fmt.Sprintf("failed to encode state: %v", err))
+	}
+	clone := &State{}
+	if err := json.Unmarshal(data, clone); err != nil {
+		panic(fmt.Sprintf("failed to decode state: %v", err))
+	}
+	return clone
+}
+
+// snapshotState copies the pruner state, so that it can be encoded without
+// holding mu. The caller must hold cycleMu.
+func (p *Pruner) snapshotState() *State {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state := &State{
+		ConsecutiveFailures: p.consecutiveFailures,
+		Ready:               p.Ready(),
+		OrphanSince:         maps.Clone(p.orphanSince),
+		LastActivity:        maps.Clone(p.lastActivity),
+	}
+	if p.lastCycle != nil {
+		last := *p.lastCycle
+		state.LastCycle = &last
+	}
+	if len(p.releaseFailures) > 0 {
+		state.ReleaseFailures = make(map[string]*ReleaseFailure, len(p.releaseFailures))
+		for key, f := range p.releaseFailures {
+			copied := *f
+			state.ReleaseFailures[key] = &copied
+		}
+	}
+	if len(p.deletedNamespaces) > 0 {
+		state.DeletedNamespaces = make(map[string]*DeletedNamespace, len(p.deletedNamespaces))
+		for name, deleted := range p.deletedNamespaces {
+			copied := *deleted
+			state.DeletedNamespaces[name] = &copied
+		}
+	}
+	return state
+}
+
+// forgetGoneReleases drops the failure records and activity observations of
+// releases that no longer exist, for example because someone uninstalled
+// them by hand.
+func (p *Pruner) forgetGoneReleases(releases []*releasev1.Release) {
+	existing := make(map[string]bool, len(releases))
+	for _, rel := range releases {
+		existing[releaseKey(rel.Namespace, rel.Name)] = true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	maps.DeleteFunc(p.releaseFailures, func(key string, _ *ReleaseFailure) bool { return !existing[key] })
+	maps.DeleteFunc(p.lastActivity, func(key string, _ time.Time) bool { return !existing[key] })
+}
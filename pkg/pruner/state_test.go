@@ -0,0 +1,311 @@
+package pruner
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// staticSignal is an activity signal that reports fixed activity times by
+// release name.
+type staticSignal map[string]time.Time
+
+func (staticSignal) Name() string { return "static" }
+
+func (s staticSignal) LastActivity(_ context.Context, releases []*releasev1.Release) (map[*releasev1.Release]time.Time, error) {
+	times := make(map[*releasev1.Release]time.Time)
+	for _, rel := range releases {
+		if t, ok := s[rel.Name]; ok {
+			times[rel] = t
+		}
+	}
+	return times, nil
+}
+
+func TestNewStateStore(t *testing.T) {
+	client := fake.NewClientset()
+	tests := []struct {
+		name    string
+		opts    Options
+		want    StateStore
+		wantErr bool
+	}{
+		{"memory only", Options{}, nil, false},
+		{"configmap", Options{StateConfigMap: "pruner/state"}, &ConfigMapStateStore{Client: client, Namespace: "pruner", Name: "state"}, false},
+		{"file", Options{StateFile: "/var/lib/pruner/state.json"}, &FileStateStore{Path: "/var/lib/pruner/state.json"}, false},
+		{"configmap without namespace", Options{StateConfigMap: "state"}, nil, true},
+		{"configmap and file", Options{StateConfigMap: "pruner/state", StateFile: "state.json"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newStateStore(tt.opts, client)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newStateStore error = %v, wantErr %v", err, tt.wantErr)
+			}
+			switch want := tt.want.(type) {
+			case nil:
+				if got != nil {
+					t.Errorf("expected no state store, got %#v", got)
+				}
+			case *ConfigMapStateStore:
+				if s, ok := got.(*ConfigMapStateStore); !ok || s.Namespace != want.Namespace || s.Name != want.Name {
+					t.Errorf("newStateStore = %#v, want %#v", got, want)
+				}
+			case *FileStateStore:
+				if s, ok := got.(*FileStateStore); !ok || s.Path != want.Path {
+					t.Errorf("newStateStore = %#v, want %#v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigMapStateStore(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewClientset()
+	store := &ConfigMapStateStore{Client: client, Namespace: "pruner", Name: "state"}
+
+	state, err := store.Load(ctx)
+	if err != nil || state == nil || state.ConsecutiveFailures != 0 {
+		t.Fatalf("Load without a ConfigMap = %+v, %v", state, err)
+	}
+	if err := store.Save(ctx, &State{ConsecutiveFailures: 2}); err != nil {
+		t.Fatalf("Save creating the ConfigMap: %v", err)
+	}
+	if err := store.Save(ctx, &State{ConsecutiveFailures: 3}); err != nil {
+		t.Fatalf("Save updating the ConfigMap: %v", err)
+	}
+
+	other := &ConfigMapStateStore{Client: client, Namespace: "pruner", Name: "state"}
+	if state, err := other.Load(ctx); err != nil || state.ConsecutiveFailures != 3 {
+		t.Fatalf("Load = %+v, %v", state, err)
+	}
+
+	// The fake clientset ignores resourceVersion; reject stale updates the
+	// way the API server does.
+	client.PrependReactor("update", "configmaps", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewConflict(schema.GroupResource{Resource: "configmaps"}, "state", errors.New("stale"))
+	})
+	if err := store.Save(ctx, &State{}); !errors.Is(err, ErrStateConflict) {
+		t.Errorf("Save after a concurrent write = %v, want ErrStateConflict", err)
+	}
+
+	// Creating a ConfigMap that someone else created is a conflict too.
+	late := &ConfigMapStateStore{Client: fake.NewClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "pruner", Name: "state"},
+	}), Namespace: "pruner", Name: "state"}
+	if err := late.Save(ctx, &State{}); !errors.Is(err, ErrStateConflict) {
+		t.Errorf("Save creating an existing ConfigMap = %v, want ErrStateConflict", err)
+	}
+}
+
+func TestFileStateStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "state.json")
+	store := &FileStateStore{Path: path}
+
+	state, err := store.Load(ctx)
+	if err != nil || state == nil || state.ConsecutiveFailures != 0 {
+		t.Fatalf("Load without a file = %+v, %v", state, err)
+	}
+	if err := store.Save(ctx, &State{ConsecutiveFailures: 2}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := store.Save(ctx, &State{ConsecutiveFailures: 3}); err != nil {
+		t.Fatalf("second Save: %v", err)
+	}
+
+	other := &FileStateStore{Path: path}
+	if state, err := other.Load(ctx); err != nil || state.ConsecutiveFailures != 3 {
+		t.Fatalf("Load = %+v, %v", state, err)
+	}
+	if err := other.Save(ctx, &State{ConsecutiveFailures: 4}); err != nil {
+		t.Fatalf("Save from another store: %v", err)
+	}
+
+	if err := store.Save(ctx, &State{}); !errors.Is(err, ErrStateConflict) {
+		t.Errorf("Save after a concurrent write = %v, want ErrStateConflict", err)
+	}
+	if state, err := store.Load(ctx); err != nil || state.ConsecutiveFailures != 4 {
+		t.Fatalf("Load after a conflict = %+v, %v", state, err)
+	}
+	if err := store.Save(ctx, &State{ConsecutiveFailures: 5}); err != nil {
+		t.Errorf("Save after reloading: %v", err)
+	}
+}
+
+func TestRunOnce_PersistsState(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "state.json")
+	old := time.Now().Add(-24 * time.Hour)
+	opts := Options{CleanupOrphanNamespaces: true, OrphanIdleTime: time.Hour, StuckNamespaceThreshold: time.Hour}
+	newPruner := func() *Pruner {
+		p := newOrphanPruner(t, opts, namespace("feature-a", old))
+		p.state = &FileStateStore{Path: path}
+		return p
+	}
+
+	p := newPruner()
+	if _, err := p.RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+	since, tracked := p.orphanSince["feature-a"]
+	if !tracked {
+		t.Fatal("expected the first cycle to start the idle window of feature-a")
+	}
+
+	// A restarted pruner continues the idle window instead of starting over.
+	restarted := newPruner()
+	if _, err := restarted.RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce after restart: %v", err)
+	}
+	if got := restarted.orphanSince["feature-a"]; !got.Equal(since) {
+		t.Errorf("idle window started at %v after restart, want %v", got, since)
+	}
+	status := restarted.Status()
+	if !status.Ready || status.LastCycle == nil {
+		t.Errorf("expected the restarted pruner to be ready with a last cycle, got %+v", status)
+	}
+}
+
+func TestRunOnce_DryRunDoesNotSaveState(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "state.json")
+	old := time.Now().Add(-24 * time.Hour)
+	seed := &State{ConsecutiveFailures: 2, OrphanSince: map[string]time.Time{"feature-gone": old}}
+	if err := (&FileStateStore{Path: path}).Save(ctx, seed); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	p := newOrphanPruner(t, Options{DryRun: true, CleanupOrphanNamespaces: true, OrphanIdleTime: time.Hour},
+		namespace("feature-a", old))
+	p.state = &FileStateStore{Path: path}
+	if _, err := p.RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+	if p.Status().ConsecutiveFailures != 0 {
+		t.Error("expected the dry run to load the state and record its cycle in memory")
+	}
+
+	stored, err := (&FileStateStore{Path: path}).Load(ctx)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if stored.ConsecutiveFailures != 2 || stored.LastCycle != nil || len(stored.OrphanSince) != 1 {
+		t.Errorf("expected a dry run to leave the stored state alone, got %+v", stored)
+	}
+}
+
+func TestSaveState_MergesConcurrentChanges(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "state.json")
+	since := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	seed := &FileStateStore{Path: path}
+	if err := seed.Save(ctx, &State{OrphanSince: map[string]time.Time{"feature-gone": since, "feature-b": since}}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	a, b := newTestPruner(Options{}), newTestPruner(Options{})
+	a.state, b.state = &FileStateStore{Path: path}, &FileStateStore{Path: path}
+	for _, p := range []*Pruner{a, b} {
+		if err := p.loadState(ctx); err != nil {
+			t.Fatalf("loadState: %v", err)
+		}
+	}
+
+	// a records a failure and saves first.
+	a.recordUninstallFailure(mockRelease("web", "feature-a", since), errors.New("timed out"), time.Now())
+	if err := a.saveState(ctx); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+
+	// b starts an idle window and forgets a namespace, then saves over a's
+	// state: both changes are kept.
+	b.orphanSince["feature-c"] = since
+	delete(b.orphanSince, "feature-gone")
+	if err := b.saveState(ctx); err != nil {
+		t.Fatalf("saveState after a concurrent save: %v", err)
+	}
+	if _, ok := b.releaseFailures[releaseKey("feature-a", "web")]; !ok {
+		t.Error("expected the merged failure in memory")
+	}
+
+	state, err := (&FileStateStore{Path: path}).Load(ctx)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if state.ReleaseFailures[releaseKey("feature-a", "web")] == nil {
+		t.Errorf("expected the failure saved by a to survive, got %+v", state.ReleaseFailures)
+	}
+	var namespaces []string
+	for ns := range state.OrphanSince {
+		namespaces = append(namespaces, ns)
+	}
+	slices.Sort(namespaces)
+	if !slices.Equal(namespaces, []string{"feature-b", "feature-c"}) {
+		t.Errorf("orphan namespaces = %v, want [feature-b feature-c]", namespaces)
+	}
+}
+
+func TestRunOnce_StateLoadFailure(t *testing.T) {
+	ctx := context.Background()
+	p := newOrphanPruner(t, Options{CleanupOrphanNamespaces: true}, namespace("feature-a", time.Now().Add(-24*time.Hour)))
+	client := fake.NewClientset()
+	client.PrependReactor("get", "configmaps", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("etcd unavailable")
+	})
+	p.state = &ConfigMapStateStore{Client: client, Namespace: "pruner", Name: "state"}
+
+	if _, err := p.RunOnce(ctx); err == nil {
+		t.Fatal("expected the cycle to fail when the state cannot be loaded")
+	}
+	if got := remainingNamespaces(t, p); len(got) != 1 {
+		t.Errorf("expected nothing to be deleted without the state, remaining %v", got)
+	}
+	if p.Status().ConsecutiveFailures != 1 {
+		t.Errorf("expected the failed cycle to be counted, got %+v", p.Status())
+	}
+}
+
+func TestRememberActivity(t *testing.T) {
+	now := time.Now()
+	p := newTestPruner(Options{})
+	p.activitySignals = []ActivitySignal{staticSignal{}}
+	web := mockRelease("web", "feature-a", now.Add(-48*time.Hour))
+	api := mockRelease("api", "feature-b", now.Add(-48*time.Hour))
+	releases := []*releasev1.Release{web, api}
+
+	activity := p.rememberActivity(releases, map[*releasev1.Release]time.Time{web: now.Add(-time.Hour)})
+	if !activity[web].Equal(now.Add(-time.Hour)) {
+		t.Fatalf("expected observed activity to be used, got %v", activity[web])
+	}
+
+	// The pods are gone, but the activity observed earlier remains.
+	activity = p.rememberActivity(releases, nil)
+	if !activity[web].Equal(now.Add(-time.Hour)) {
+		t.Errorf("expected remembered activity, got %v", activity[web])
+	}
+	if _, ok := activity[api]; ok {
+		t.Error("expected no activity for a release never seen active")
+	}
+
+	// An upgrade makes earlier activity irrelevant.
+	upgraded := mockRelease("web", "feature-a", now)
+	activity = p.rememberActivity([]*releasev1.Release{upgraded}, nil)
+	if _, ok := activity[upgraded]; ok {
+		t.Error("expected activity older than the last deployment to be ignored")
+	}
+}
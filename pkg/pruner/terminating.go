@@ -0,0 +1,219 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// stuckNamespaceReason is the event reason for namespaces stuck in Terminating.
+const stuckNamespaceReason = "NamespaceStuckTerminating"
+
+// removeFinalizersPatch clears metadata.finalizers with a JSON merge patch.
+var removeFinalizersPatch = []byte(`{"metadata":{"finalizers":null}}`)
+
+// DeletedNamespace records a namespace the pruner deleted and is waiting on.
+type DeletedNamespace struct {
+	DeletedAt time.Time `json:"deletedAt"`
+	// Reported is set once the namespace was reported as stuck.
+	Reported bool `json:"reported,omitempty"`
+}
+
+// deleteNamespace deletes a namespace between its deletion hooks and, when
+// stuck namespace tracking is enabled, remembers it so that
+// checkTerminatingNamespaces can follow up. A failed pre-delete hook returns
+// an error wrapping ErrDeletionVetoed.
+func (p *Pruner) deleteNamespace(ctx context.Context, namespace string) (err error) {
+	ctx, span := p.startSpan(ctx, "delete-namespace", attrNamespace.String(namespace))
+	defer func() { endSpan(span, err) }()
+
+	event := p.namespaceHookEvent(namespace)
+	if err := p.runPreDeleteHooks(ctx, event); err != nil {
+		return err
+	}
+	if err := p.k8s.CoreV1().Namespaces().Delete(ctx, namespace, metav1.DeleteOptions{}); err != nil {
+		return err
+	}
+	namespacesDeletedTotal.WithLabelValues(p.opts.ClusterName).Inc()
+	p.runPostDeleteHooks(ctx, event)
+
+	if p.opts.StuckNamespaceThreshold > 0 {
+		if p.deletedNamespaces == nil {
+			p.deletedNamespaces = make(map[string]*DeletedNamespace)
+		}
+		p.deletedNamespaces[namespace] = &DeletedNamespace{DeletedAt: time.Now()}
+	}
+	return nil
+}
+
+// checkTerminatingNamespaces follows up on namespaces the pruner deleted.
+// Namespaces that are gone are forgotten; namespaces still Terminating past
+// StuckNamespaceThreshold are reported once through a warning event, and on
+// every cycle through the stuck namespaces metric and, when configured,
+// finalizer removal. In dry-run mode, the event and finalizer removal are
+// only logged.
+func (p *Pruner) checkTerminatingNamespaces(ctx context.Context) (err error) {
+	ctx, span := p.startSpan(ctx, "check-terminating-namespaces")
+	stuck := 0
+	defer func() {
+		namespacesStuckTerminating.WithLabelValues(p.opts.ClusterName).Set(float64(stuck))
+		span.SetAttributes(attrNamespaceCount.Int(stuck))
+		endSpan(span, err)
+	}()
+
+	now := time.Now()
+	for name, deleted := range p.deletedNamespaces {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		ns, err := p.k8s.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			p.logger.Debug("deleted namespace is gone", "namespace", name)
+			delete(p.deletedNamespaces, name)
+			continue
+		}
+		if err != nil {
+			p.logger.Error("failed to get deleted namespace",
+				"namespace", name,
+				"error", err)
+			continue
+		}
+		if ns.DeletionTimestamp == nil {
+			// Deleted and recreated since; no longer ours to track.
+			delete(p.deletedNamespaces, name)
+			continue
+		}
+
+		terminating := now.Sub(deleted.DeletedAt)
+		if terminating < p.opts.StuckNamespaceThreshold {
+			continue
+		}
+		stuck++
+
+		remaining := remainingContent(ns)
+		p.logger.Warn("namespace stuck in Terminating",
+			"namespace", name,
+			"terminating_for", terminating.Round(time.Second),
+			"remaining", remaining)
+
+		if !deleted.Reported && p.opts.DryRun {
+			p.logger.Info("would report stuck namespace",
+				"namespace", name)
+		} else if !deleted.Reported {
+			message := fmt.Sprintf("Namespace deleted by %s has been Terminating for %s", eventComponent, terminating.Round(time.Second))
+			if remaining != "" {
+				message += ": " + remaining
+			}
+			if err := p.recordNamespaceEvent(ctx, name, corev1.EventTypeWarning, stuckNamespaceReason, message); err != nil {
+				p.logger.Error("failed to record event",
+					"namespace", name,
+					"error", err)
+			} else {
+				deleted.Reported = true
+			}
+		}
+
+		if len(p.opts.StuckNamespaceRemoveFinalizers) > 0 {
+			p.removeFinalizers(ctx, name)
+		}
+	}
+
+	return nil
+}
+
+// remainingContent summarizes the namespace controller's conditions that
+// explain why a namespace has not finished terminating.
+func remainingContent(ns *corev1.Namespace) string {
+	var messages []string
+	for _, cond := range ns.Status.Conditions {
+		if cond.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch cond.Type {
+		case corev1.NamespaceContentRemaining, corev1.NamespaceFinalizersRemaining:
+			messages = append(messages, cond.Message)
+		}
+	}
+	return strings.Join(messages, "; ")
+}
+
+// removeFinalizers lists the objects of each allowlisted kind left in a
+// stuck namespace and clears their finalizers so that deletion can finish.
+func (p *Pruner) removeFinalizers(ctx context.Context, namespace string) {
+	for _, kind := range p.opts.StuckNamespaceRemoveFinalizers {
+		gvr, err := p.resolveKind(kind)
+		if meta.IsNoMatchError(err) {
+			p.logger.Debug("finalizer removal kind not served by cluster, ignoring",
+				"kind", kind)
+			continue
+		}
+		if err != nil {
+			p.logger.Error("failed to resolve kind",
+				"kind", kind,
+				"error", err)
+			continue
+		}
+
+		client := p.dynamic.Resource(gvr).Namespace(namespace)
+		list, err := client.List(ctx, metav1.ListOptions{})
+		if err != nil {
+			p.logger.Error("failed to list objects in stuck namespace",
+				"namespace", namespace,
+				"resource", gvr.GroupResource(),
+				"error", err)
+			continue
+		}
+
+		for _, item := range list.Items {
+			finalizers := item.GetFinalizers()
+			if len(finalizers) == 0 {
+				continue
+			}
+			object := fmt.Sprintf("%s/%s", gvr.GroupResource(), item.GetName())
+
+			if p.opts.DryRun {
+				p.logger.Info("would remove finalizers",
+					"namespace", namespace,
+					"object", object,
+					"finalizers", finalizers)
+				continue
+			}
+
+			p.logger.Warn("removing finalizers",
+				"namespace", namespace,
+				"object", object,
+				"finalizers", finalizers)
+			if _, err := client.Patch(ctx, item.GetName(), types.MergePatchType, removeFinalizersPatch, metav1.PatchOptions{}); err != nil {
+				p.logger.Error("failed to remove finalizers",
+					"namespace", namespace,
+					"object", object,
+					"error", err)
+			}
+		}
+	}
+}
+
+// resolveKind maps a kind argument such as "Widget.v1.example.com" or
+// "Widget.example.com" to the resource the cluster serves.
+func (p *Pruner) resolveKind(kind string) (schema.GroupVersionResource, error) {
+	fullySpecified, groupKind := schema.ParseKindArg(kind)
+	if fullySpecified != nil {
+		if mapping, err := p.mapper.RESTMapping(fullySpecified.GroupKind(), fullySpecified.Version); err == nil {
+			return mapping.Resource, nil
+		}
+	}
+	mapping, err := p.mapper.RESTMapping(groupKind)
+	if err != nil {
+		return schema.GroupVersionResource{}, err
+	}
+	return mapping.Resource, nil
+}
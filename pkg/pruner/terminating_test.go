@@ -0,0 +1,143 @@
+package pruner
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// terminatingNamespace creates a namespace that is being deleted but still
+// has content left.
+func terminatingNamespace(name string) *corev1.Namespace {
+	deleted := metav1.NewTime(time.Now().Add(-time.Hour))
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: name, DeletionTimestamp: &deleted, Finalizers: []string{"kubernetes"}},
+		Status: corev1.NamespaceStatus{
+			Phase: corev1.NamespaceTerminating,
+			Conditions: []corev1.NamespaceCondition{{
+				Type:    corev1.NamespaceFinalizersRemaining,
+				Status:  corev1.ConditionTrue,
+				Message: "Some content in the namespace has finalizers remaining: example.com/cleanup in 1 resource instances",
+			}},
+		},
+	}
+}
+
+func TestCheckTerminatingNamespaces(t *testing.T) {
+	widget := newObject(widgetGVR, "Widget", "feature-stuck", "gizmo")
+	widget.SetFinalizers([]string{"example.com/cleanup"})
+	other := newObject(pvcGVR, "PersistentVolumeClaim", "feature-stuck", "data-0")
+	other.SetFinalizers([]string{"kubernetes.io/pvc-protection"})
+
+	p := newClusterPruner(Options{
+		ClusterName:                    "terminating-test",
+		StuckNamespaceThreshold:        15 * time.Minute,
+		StuckNamespaceRemoveFinalizers: []string{"Widget.v1.example.com", "Gadget.example.com"},
+	}, []runtime.Object{
+		terminatingNamespace("feature-stuck"),
+		terminatingNamespace("feature-slow"),
+	}, widget, other)
+
+	ctx := context.Background()
+	p.deletedNamespaces = map[string]*DeletedNamespace{
+		"feature-stuck": {DeletedAt: time.Now().Add(-time.Hour)},
+		"feature-slow":  {DeletedAt: time.Now().Add(-time.Minute)},
+		"feature-gone":  {DeletedAt: time.Now().Add(-time.Hour)},
+	}
+
+	for range 2 {
+		if err := p.checkTerminatingNamespaces(ctx); err != nil {
+			t.Fatalf("checkTerminatingNamespaces: %v", err)
+		}
+	}
+
+	if _, ok := p.deletedNamespaces["feature-gone"]; ok {
+		t.Error("expected namespace that finished deleting to be forgotten")
+	}
+	if len(p.deletedNamespaces) != 2 {
+		t.Errorf("expected 2 namespaces still tracked, got %d", len(p.deletedNamespaces))
+	}
+
+	if got := testutil.ToFloat64(namespacesStuckTerminating.WithLabelValues("terminating-test")); got != 1 {
+		t.Errorf("stuck namespaces gauge = %v, want 1", got)
+	}
+
+	events, err := p.k8s.CoreV1().Events(metav1.NamespaceDefault).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list events: %v", err)
+	}
+	if len(events.Items) != 1 {
+		t.Fatalf("expected one event across two cycles, got %d", len(events.Items))
+	}
+	if e := events.Items[0]; e.InvolvedObject.Name != "feature-stuck" || e.Reason != stuckNamespaceReason || e.Type != corev1.EventTypeWarning {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	got, err := p.dynamic.Resource(widgetGVR).Namespace("feature-stuck").Get(ctx, "gizmo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get widget: %v", err)
+	}
+	if len(got.GetFinalizers()) != 0 {
+		t.Errorf("expected widget finalizers to be removed, got %v", got.GetFinalizers())
+	}
+
+	pvc, err := p.dynamic.Resource(pvcGVR).Namespace("feature-stuck").Get(ctx, "data-0", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get pvc: %v", err)
+	}
+	if len(pvc.GetFinalizers()) != 1 {
+		t.Errorf("expected finalizers of kinds not in the allowlist to be kept, got %v", pvc.GetFinalizers())
+	}
+}
+
+func TestDeleteNamespace_TracksDeletion(t *testing.T) {
+	p := newClusterPruner(Options{StuckNamespaceThreshold: time.Minute}, []runtime.Object{
+		namespace("feature-a", time.Now()),
+	})
+
+	if err := p.deleteNamespace(context.Background(), "feature-a"); err != nil {
+		t.Fatalf("deleteNamespace: %v", err)
+	}
+	if _, ok := p.deletedNamespaces["feature-a"]; !ok {
+		t.Error("expected deleted namespace to be tracked")
+	}
+}
+
+func TestCheckTerminatingNamespaces_DryRun(t *testing.T) {
+	widget := newObject(widgetGVR, "Widget", "feature-stuck", "gizmo")
+	widget.SetFinalizers([]string{"example.com/cleanup"})
+
+	p := newClusterPruner(Options{
+		DryRun:                         true,
+		StuckNamespaceThreshold:        15 * time.Minute,
+		StuckNamespaceRemoveFinalizers: []string{"Widget.v1.example.com"},
+	}, []runtime.Object{terminatingNamespace("feature-stuck")}, widget)
+
+	ctx := context.Background()
+	p.deletedNamespaces = map[string]*DeletedNamespace{
+		"feature-stuck": {DeletedAt: time.Now().Add(-time.Hour)},
+	}
+	if err := p.checkTerminatingNamespaces(ctx); err != nil {
+		t.Fatalf("checkTerminatingNamespaces: %v", err)
+	}
+
+	events, err := p.k8s.CoreV1().Events(metav1.NamespaceDefault).List(ctx, metav1.ListOptions{})
+	if err != nil || len(events.Items) != 0 {
+		t.Errorf("expected no events in dry-run mode, got %v, %v", events, err)
+	}
+	if p.deletedNamespaces["feature-stuck"].Reported {
+		t.Error("expected the namespace to stay unreported for a real run")
+	}
+	got, err := p.dynamic.Resource(widgetGVR).Namespace("feature-stuck").Get(ctx, "gizmo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get widget: %v", err)
+	}
+	if len(got.GetFinalizers()) != 1 {
+		t.Errorf("expected dry-run mode to keep the widget finalizers, got %v", got.GetFinalizers())
+	}
+}
@@ -0,0 +1,68 @@
+package pruner
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	releasev1 "helm.sh/helm/v4/pkg/release/v1"
+)
+
+// instrumentationName identifies the pruner's spans to OpenTelemetry.
+const instrumentationName = "github.com/FairwindsOps/helm-release-pruner/pkg/pruner"
+
+// Span attribute keys.
+const (
+	attrCluster        = attribute.Key("pruner.cluster")
+	attrDryRun         = attribute.Key("pruner.dry_run")
+	attrReleaseCount   = attribute.Key("pruner.releases")
+	attrCandidateCount = attribute.Key("pruner.candidates")
+	attrSelectedCount  = attribute.Key("pruner.selected")
+	attrNamespaceCount = attribute.Key("pruner.namespaces")
+	attrReleaseName    = attribute.Key("helm.release.name")
+	attrReleaseVersion = attribute.Key("helm.release.revision")
+	attrReleaseStatus  = attribute.Key("helm.release.status")
+	attrChart          = attribute.Key("helm.chart")
+	attrWaitStrategy   = attribute.Key("helm.uninstall.wait_strategy")
+	attrTimeout        = attribute.Key("helm.uninstall.timeout")
+	attrNamespace      = attribute.Key("k8s.namespace.name")
+	attrHookName       = attribute.Key("pruner.hook.name")
+	attrHookPhase      = attribute.Key("pruner.hook.phase")
+)
+
+// startSpan starts a span for a pruner operation, tagged with the cluster.
+func (p *Pruner) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	tp := p.opts.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	attrs = append(attrs, attrCluster.String(p.opts.ClusterName))
+	return tp.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends span, marking it failed when err is not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// releaseAttributes describes a release on a span.
+func releaseAttributes(rel *releasev1.Release) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attrReleaseName.String(rel.Name),
+		attrNamespace.String(rel.Namespace),
+		attrReleaseVersion.Int(rel.Version),
+	}
+	if rel.Info != nil {
+		attrs = append(attrs, attrReleaseStatus.String(rel.Info.Status.String()))
+	}
+	if rel.Chart != nil && rel.Chart.Metadata != nil {
+		attrs = append(attrs, attrChart.String(rel.Chart.Metadata.Name+"-"+rel.Chart.Metadata.Version))
+	}
+	return attrs
+}
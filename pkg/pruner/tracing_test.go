@@ -0,0 +1,123 @@
+package pruner
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// newTracedPruner returns an orphan test pruner whose spans are recorded.
+func newTracedPruner(t *testing.T, opts Options, typed ...runtime.Object) (*Pruner, *tracetest.SpanRecorder) {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	opts.TracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	return newOrphanPruner(t, opts, typed...), recorder
+}
+
+// spanAttribute returns the value of key on span, or an empty value.
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+// spansByName indexes recorded spans by name.
+func spansByName(spans []sdktrace.ReadOnlySpan) map[string]sdktrace.ReadOnlySpan {
+	byName := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range spans {
+		byName[span.Name()] = span
+	}
+	return byName
+}
+
+func TestRunOnce_Spans(t *testing.T) {
+	p, recorder := newTracedPruner(t, Options{
+		ClusterName:             "staging",
+		CleanupOrphanNamespaces: true,
+	}, namespace("feature-old", time.Now().Add(-time.Hour)))
+
+	if _, err := p.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+
+	spans := recorder.Ended()
+	byName := spansByName(spans)
+	for _, name := range []string{"prune-cycle", "cleanup-orphan-namespaces", "delete-namespace"} {
+		if _, ok := byName[name]; !ok {
+			t.Fatalf("missing %s span, got %d spans", name, len(spans))
+		}
+	}
+
+	root := byName["prune-cycle"]
+	if root.Parent().IsValid() {
+		t.Error("expected prune-cycle to be a root span")
+	}
+	for _, span := range spans {
+		if span.SpanContext().TraceID() != root.SpanContext().TraceID() {
+			t.Errorf("span %s is not part of the cycle trace", span.Name())
+		}
+		if got := spanAttribute(span, attrCluster).AsString(); got != "staging" {
+			t.Errorf("span %s cluster = %q, want staging", span.Name(), got)
+		}
+	}
+
+	if parent := byName["delete-namespace"].Parent(); parent.SpanID() != byName["cleanup-orphan-namespaces"].SpanContext().SpanID() {
+		t.Error("expected delete-namespace to be a child of cleanup-orphan-namespaces")
+	}
+	if got := spanAttribute(byName["delete-namespace"], attrNamespace).AsString(); got != "feature-old" {
+		t.Errorf("delete-namespace namespace = %q, want feature-old", got)
+	}
+}
+
+func TestRunOnce_SpanErrors(t *testing.T) {
+	p, recorder := newTracedPruner(t, Options{OlderThan: time.Hour})
+
+	// Listing releases needs the unreachable test cluster.
+	if _, err := p.RunOnce(context.Background()); err == nil {
+		t.Fatal("expected RunOnce to fail")
+	}
+
+	byName := spansByName(recorder.Ended())
+	for _, name := range []string{"prune-cycle", "list-releases"} {
+		span, ok := byName[name]
+		if !ok {
+			t.Fatalf("missing %s span", name)
+		}
+		if span.Status().Code != codes.Error {
+			t.Errorf("%s status = %v, want error", name, span.Status().Code)
+		}
+	}
+	if _, ok := byName["filter-releases"]; ok {
+		t.Error("expected no filter-releases span after listing failed")
+	}
+}
+
+func TestDeleteRelease_SpanError(t *testing.T) {
+	p, recorder := newTracedPruner(t, Options{})
+
+	if err := p.deleteRelease(context.Background(), mockRelease("missing", "feature-a", time.Now())); err == nil {
+		t.Fatal("expected uninstalling a missing release to fail")
+	}
+
+	spans := recorder.Ended()
+	idx := slices.IndexFunc(spans, func(s sdktrace.ReadOnlySpan) bool { return s.Name() == "delete-release" })
+	if idx < 0 {
+		t.Fatal("missing delete-release span")
+	}
+	if status := spans[idx].Status(); status.Code != codes.Error {
+		t.Errorf("delete-release status = %v, want error", status.Code)
+	}
+	if got := spanAttribute(spans[idx], attrReleaseStatus).AsString(); got != "deployed" {
+		t.Errorf("delete-release status attribute = %q, want deployed", got)
+	}
+}